@@ -0,0 +1,298 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newOrder(amount float64) map[string]any {
+	return map[string]any{
+		"order_id":    uuid.NewString(),
+		"customer_id": uuid.NewString(),
+		"amount":      amount,
+	}
+}
+
+func TestOrderIsConsumed(t *testing.T) {
+	env := NewEnv(t)
+	orders := env.Start("order", nil)
+	env.Start("producer", nil)
+	consumer := env.Start("consumer", nil)
+
+	order := newOrder(120.50)
+	orderID := order["order_id"].(string)
+	if status, body := orders.PostJSON("/orders", order); status != http.StatusCreated {
+		t.Fatalf("POST /orders returned %d: %s", status, body)
+	}
+
+	record := consumer.WaitLog("Processed OrderCreated event", map[string]string{"order_id": orderID})
+	eventID, _ := env.OutboxEvent(orderID)
+	if record["event_id"] != eventID {
+		t.Errorf("consumer processed event %v, want the outbox event %s", record["event_id"], eventID)
+	}
+	// The producer marks the event after Kafka acknowledged it, which may be
+	// after the consumer got it.
+	eventually(t, 10*time.Second, "the outbox event to be PUBLISHED", func() bool {
+		_, status := env.OutboxEvent(orderID)
+		return status == "PUBLISHED"
+	})
+
+	// A retried request finds the order and writes no second event.
+	if status, body := orders.PostJSON("/orders", order); status != http.StatusOK {
+		t.Fatalf("retried POST /orders returned %d: %s", status, body)
+	}
+	var events int
+	if err := env.DB.QueryRow(`SELECT count(*) FROM outbox_events WHERE aggregate_id = $1`, orderID).Scan(&events); err != nil {
+		t.Fatal(err)
+	}
+	if events != 1 {
+		t.Errorf("order has %d outbox events, want 1", events)
+	}
+}
+
+func TestInvalidOrderIsRejected(t *testing.T) {
+	env := NewEnv(t)
+	orders := env.Start("order", nil)
+
+	order := newOrder(10.005)
+	order["customer_id"] = "customer-1"
+	order["discount"] = 25.0
+	order["currency"] = "EUR"
+	status, body := orders.PostJSON("/orders", order)
+	if status != http.StatusBadRequest {
+		t.Fatalf("POST /orders returned %d: %s", status, body)
+	}
+	var problem struct {
+		Status int
+		Errors []struct{ Field string }
+	}
+	if err := json.Unmarshal(body, &problem); err != nil {
+		t.Fatalf("response is not a problem: %v: %s", err, body)
+	}
+	// Unknown fields are refused before the order is validated.
+	if problem.Status != http.StatusBadRequest || len(problem.Errors) != 1 || problem.Errors[0].Field != "currency" {
+		t.Errorf("problem = %s, want the currency field refused", body)
+	}
+
+	delete(order, "currency")
+	_, body = orders.PostJSON("/orders", order)
+	problem.Errors = nil
+	if err := json.Unmarshal(body, &problem); err != nil {
+		t.Fatalf("response is not a problem: %v: %s", err, body)
+	}
+	var fields []string
+	for _, e := range problem.Errors {
+		fields = append(fields, e.Field)
+	}
+	if want := []string{"customer_id", "amount", "discount"}; !slices.Equal(fields, want) {
+		t.Errorf("problem fields = %v, want %v: %s", fields, want, body)
+	}
+	var n int
+	if err := env.DB.QueryRow(`SELECT count(*) FROM orders`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d orders stored, want none", n)
+	}
+}
+
+// The order API refuses a discount larger than the amount, but an event
+// written by an older version or another writer still reaches the consumer,
+// which rejects it: the event goes through the outbox into the DLQ.
+func TestInvalidOrderIsDeadLettered(t *testing.T) {
+	env := NewEnv(t)
+	env.Start("producer", nil)
+	env.Start("consumer", nil)
+
+	orderID, eventID := uuid.NewString(), uuid.NewString()
+	payload, err := json.Marshal(map[string]any{
+		"event_id":    eventID,
+		"order_id":    orderID,
+		"customer_id": uuid.NewString(),
+		"amount":      10.0,
+		"discount":    25.0,
+		"created_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.DB.Exec(`
+		INSERT INTO outbox_events (id, aggregate_type, aggregate_id, event_type, payload, schema_version)
+		VALUES ($1, 'order', $2, 'OrderCreated', $3, 1)`, eventID, orderID, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	dlq := env.DecodeDLQ(env.Consume(dlqTopic, 1)[0])
+	if dlq["errorType"] != "validation_error" {
+		t.Errorf("errorType = %v, want validation_error", dlq["errorType"])
+	}
+	if got := dlq["eventId"]; got == nil || got.(map[string]any)["string"] != eventID {
+		t.Errorf("eventId = %v, want %s", got, eventID)
+	}
+	if dlq["originalTopic"] != ordersTopic {
+		t.Errorf("originalTopic = %v, want %s", dlq["originalTopic"], ordersTopic)
+	}
+	if got := dlq["consumerGroup"]; got == nil || got.(map[string]any)["string"] != "orders-consumer" {
+		t.Errorf("consumerGroup = %v, want orders-consumer", got)
+	}
+}
+
+func TestPoisonMessageIsDeadLettered(t *testing.T) {
+	env := NewEnv(t)
+	consumer := env.Start("consumer", nil)
+
+	poison := []byte("not an avro record")
+	env.Produce(ordersTopic, []byte("poison"), poison)
+
+	dlq := env.DecodeDLQ(env.Consume(dlqTopic, 1)[0])
+	if dlq["errorType"] != "invalid_message" {
+		t.Errorf("errorType = %v, want invalid_message", dlq["errorType"])
+	}
+	if !bytes.Equal(dlq["payload"].([]byte), poison) {
+		t.Errorf("payload = %q, want %q", dlq["payload"], poison)
+	}
+
+	// The consumer moved past the message and stays ready.
+	consumer.waitReady(10 * time.Second)
+}
+
+// An outbox event that cannot be published is retried with backoff and
+// marked ERROR after PRODUCER_MAX_RETRIES attempts. The event before it in
+// the batch is published once, and the one after it once it was given up.
+func TestPoisonOutboxEventIsGivenUp(t *testing.T) {
+	env := NewEnv(t)
+	env.Start("producer", map[string]string{
+		"PRODUCER_MAX_RETRIES":       "3",
+		"PRODUCER_RETRY_BACKOFF":     "50ms",
+		"PRODUCER_MAX_RETRY_BACKOFF": "100ms",
+	})
+
+	insert := func(id string, created time.Time, payload string) {
+		t.Helper()
+		if _, err := env.DB.Exec(`
+			INSERT INTO outbox_events (id, aggregate_type, aggregate_id, event_type, payload, schema_version, created_at)
+			VALUES ($1, 'order', $1, 'OrderCreated', $2, $3, $4)`,
+			id, payload, env.OrderCreatedSchemaID, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := func(id string) string {
+		b, _ := json.Marshal(map[string]any{
+			"event_id":    id,
+			"order_id":    id,
+			"customer_id": uuid.NewString(),
+			"amount":      10.0,
+			"created_at":  time.Now().UTC().Format(time.RFC3339),
+		})
+		return string(b)
+	}
+	before, poison, after := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	now := time.Now()
+	insert(before, now, valid(before))
+	insert(poison, now.Add(time.Millisecond), `{"amount": "ten"}`)
+	insert(after, now.Add(2*time.Millisecond), valid(after))
+
+	// Republishing the event before the poison one would use up the two
+	// records.
+	var keys []string
+	for _, record := range env.Consume(ordersTopic, 2) {
+		keys = append(keys, string(record.Key))
+	}
+	want := []string{before, after}
+	slices.Sort(keys)
+	slices.Sort(want)
+	if !slices.Equal(keys, want) {
+		t.Errorf("published events %v, want %v", keys, want)
+	}
+
+	var (
+		status   string
+		attempts int
+	)
+	if err := env.DB.QueryRow(
+		`SELECT status, attempts FROM outbox_events WHERE id = $1`, poison,
+	).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != "ERROR" || attempts != 3 {
+		t.Errorf("poison event is %s after %d attempts, want ERROR after 3", status, attempts)
+	}
+	if _, status := env.OutboxEvent(before); status != "PUBLISHED" {
+		t.Errorf("event before the poison one is %s, want PUBLISHED", status)
+	}
+}
+
+// A schema registry outage is not the event's fault: the batch is retried
+// without counting attempts, so an outage longer than PRODUCER_MAX_RETRIES
+// backoffs still publishes the event once the registry is back.
+func TestRegistryOutageIsNotCountedAgainstEvents(t *testing.T) {
+	env := NewEnv(t)
+	var down atomic.Bool
+	var refused atomic.Int64
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Lookups fail while the readiness probe's /subjects still passes.
+		if down.Load() && strings.HasPrefix(r.URL.Path, "/schemas/ids/") {
+			refused.Add(1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		env.Registry.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(registry.Close)
+	down.Store(true)
+	env.Start("producer", map[string]string{
+		"SCHEMA_REGISTRY_URL":        registry.URL,
+		"PRODUCER_MAX_RETRIES":       "2",
+		"PRODUCER_RETRY_BACKOFF":     "50ms",
+		"PRODUCER_MAX_RETRY_BACKOFF": "100ms",
+	})
+
+	id := uuid.NewString()
+	payload, err := json.Marshal(map[string]any{
+		"event_id":    id,
+		"order_id":    id,
+		"customer_id": uuid.NewString(),
+		"amount":      10.0,
+		"created_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.DB.Exec(`
+		INSERT INTO outbox_events (id, aggregate_type, aggregate_id, event_type, payload, schema_version)
+		VALUES ($1, 'order', $1, 'OrderCreated', $2, $3)`, id, payload, env.OrderCreatedSchemaID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, 10*time.Second, "the producer to retry the lookup", func() bool { return refused.Load() >= 5 })
+
+	var (
+		status   string
+		attempts int
+	)
+	if err := env.DB.QueryRow(`SELECT status, attempts FROM outbox_events WHERE id = $1`, id).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != "PENDING" || attempts != 0 {
+		t.Errorf("event is %s after %d attempts during the outage, want PENDING after 0", status, attempts)
+	}
+
+	down.Store(false)
+	if record := env.Consume(ordersTopic, 1)[0]; string(record.Key) != id {
+		t.Errorf("published event %s, want %s", record.Key, id)
+	}
+	eventually(t, 10*time.Second, "the outbox event to be PUBLISHED", func() bool {
+		_, status := env.OutboxEvent(id)
+		return status == "PUBLISHED"
+	})
+}
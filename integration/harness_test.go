@@ -0,0 +1,546 @@
+//go:build integration
+
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/schemaregistry"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/linkedin/goavro/v2"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Topics of the test cluster, named as in the services' .env files.
+const (
+	ordersTopic = "orders.v1"
+	dlqTopic    = "orders.dlq"
+	retryTopic  = "orders.retry.1s"
+)
+
+// binaries are built from these directories, relative to the repository
+// root, by TestMain.
+var binaries = map[string]string{
+	"order":    "services/order",
+	"producer": "services/producer",
+	"consumer": "services/consumer",
+	"verify":   "tools/cmd/verify",
+}
+
+// Set up once by TestMain.
+var (
+	postgresURL *url.URL // superuser URL of the shared server
+	binDir      string   // service and tool binaries
+	databases   atomic.Int64
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "eda-integration-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	binDir = filepath.Join(dir, "bin")
+	if err := buildBinaries(binDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	u, stop, err := startPostgres(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer stop()
+	postgresURL = u
+	return m.Run()
+}
+
+// buildBinaries builds the binaries the tests run, the same way a
+// developer would from each one's directory.
+func buildBinaries(dir string) error {
+	for name, src := range binaries {
+		cmd := exec.Command("go", "build", "-o", filepath.Join(dir, name), ".")
+		cmd.Dir = filepath.Join("..", src)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to build %s: %w\n%s", name, err, out)
+		}
+	}
+	return nil
+}
+
+// startPostgres returns EDA_TEST_POSTGRES_URL, or starts an embedded
+// Postgres of the version docker-compose runs.
+func startPostgres(dir string) (*url.URL, func(), error) {
+	if raw := os.Getenv("EDA_TEST_POSTGRES_URL"); raw != "" {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid EDA_TEST_POSTGRES_URL: %w", err)
+		}
+		return u, func() {}, nil
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, nil, err
+	}
+	logPath := filepath.Join(dir, "postgres.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V18).
+		Port(uint32(port)).
+		RuntimePath(filepath.Join(dir, "postgres")).
+		Logger(logFile)
+	if cache := os.Getenv("EDA_TEST_POSTGRES_CACHE"); cache != "" {
+		cfg = cfg.CachePath(cache)
+	}
+	pg := embeddedpostgres.NewDatabase(cfg)
+	if err := pg.Start(); err != nil {
+		logFile.Close()
+		log, _ := os.ReadFile(logPath)
+		return nil, nil, fmt.Errorf("failed to start embedded Postgres (set EDA_TEST_POSTGRES_URL to use a running server): %w\n%s", err, log)
+	}
+	stop := func() {
+		if err := pg.Stop(); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to stop embedded Postgres:", err)
+		}
+		logFile.Close()
+	}
+	u, err := url.Parse(cfg.GetConnectionURL() + "?sslmode=disable")
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	return u, stop, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Env is the infrastructure of one test: its own database, Kafka cluster
+// and schema registry. Services started with Start are stopped, and
+// everything torn down, when the test ends.
+type Env struct {
+	t        *testing.T
+	DB       *sql.DB
+	dbURL    *url.URL
+	Kafka    *kfake.Cluster
+	Registry *schemaregistry.Registry
+	registry *httptest.Server
+
+	OrderCreatedSchemaID int
+	DLQSchemaID          int
+	dlqCodec             *goavro.Codec
+}
+
+func NewEnv(t *testing.T) *Env {
+	t.Helper()
+	e := &Env{t: t}
+	e.createDatabase()
+
+	cluster, err := kfake.NewCluster(
+		kfake.NumBrokers(1),
+		kfake.SeedTopics(3, ordersTopic, dlqTopic, retryTopic),
+	)
+	if err != nil {
+		t.Fatalf("failed to start Kafka: %v", err)
+	}
+	t.Cleanup(cluster.Close)
+	e.Kafka = cluster
+
+	// The order service writes OrderCreated with the schema ID it is
+	// configured with, which the producer looks up in the registry: it has
+	// to be registered first.
+	if e.Registry, err = schemaregistry.New(schemaregistry.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	e.registry = httptest.NewServer(e.Registry.Handler())
+	t.Cleanup(e.registry.Close)
+	e.OrderCreatedSchemaID = e.register(ordersTopic+"-value", readSchema(t, "order-created.avsc"))
+	dlqSchema := readSchema(t, "order-dlq-event.avsc")
+	e.DLQSchemaID = e.register(dlqTopic+"-value", dlqSchema)
+	if e.dlqCodec, err = goavro.NewCodec(dlqSchema); err != nil {
+		t.Fatalf("invalid DLQ schema: %v", err)
+	}
+	return e
+}
+
+// createDatabase creates a database only this test uses and drops it when
+// the test ends, so the services migrate it from scratch.
+func (e *Env) createDatabase() {
+	admin, err := sql.Open("pgx", postgresURL.String())
+	if err != nil {
+		e.t.Fatalf("failed to open Postgres: %v", err)
+	}
+	e.t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("eda_test_%d_%d", os.Getpid(), databases.Add(1))
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		e.t.Fatalf("failed to create database: %v", err)
+	}
+	e.t.Cleanup(func() {
+		if _, err := admin.Exec("DROP DATABASE " + name + " WITH (FORCE)"); err != nil {
+			e.t.Errorf("failed to drop database %s: %v", name, err)
+		}
+	})
+
+	u := *postgresURL
+	u.Path = "/" + name
+	e.dbURL = &u
+	if e.DB, err = sql.Open("pgx", u.String()); err != nil {
+		e.t.Fatalf("failed to open database: %v", err)
+	}
+	e.t.Cleanup(func() { e.DB.Close() })
+}
+
+func (e *Env) register(subject, schema string) int {
+	e.t.Helper()
+	id, err := e.Registry.Register(subject, schema)
+	if err != nil {
+		e.t.Fatalf("failed to register %s: %v", subject, err)
+	}
+	return id
+}
+
+func readSchema(t *testing.T, file string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "schemas", file))
+	if err != nil {
+		t.Fatalf("failed to read schema: %v", err)
+	}
+	return string(b)
+}
+
+// config is the environment of a service, on top of which the overrides
+// passed to Start are applied.
+func (e *Env) config(name, addr string) map[string]string {
+	password, _ := e.dbURL.User.Password()
+	cfg := map[string]string{
+		"ENVIRONMENT":          "test",
+		"SHUTDOWN_TIMEOUT":     "5s",
+		"LOG_FORMAT":           "json",
+		"LOG_LEVEL":            "info",
+		"OTEL_TRACES_EXPORTER": "none",
+		"KAFKA_BROKERS":        strings.Join(e.Kafka.ListenAddrs(), ","),
+		"KAFKA_TOPIC":          ordersTopic,
+		"SCHEMA_REGISTRY_URL":  e.registry.URL,
+	}
+	db := map[string]string{
+		"DB_HOST":             e.dbURL.Hostname(),
+		"DB_PORT":             e.dbURL.Port(),
+		"DB_USER":             e.dbURL.User.Username(),
+		"DB_PASSWORD":         password,
+		"DB_NAME":             strings.TrimPrefix(e.dbURL.Path, "/"),
+		"DB_MIGRATE_ON_START": "true",
+	}
+	switch name {
+	case "order":
+		cfg["HTTP_ADDR"] = addr
+		cfg["SCHEMA_REGISTRY_ID"] = strconv.Itoa(e.OrderCreatedSchemaID)
+		addAll(cfg, db)
+	case "producer":
+		cfg["METRICS_HTTP_ADDR"] = addr
+		cfg["SCHEMA_REGISTRY_ID"] = strconv.Itoa(e.OrderCreatedSchemaID)
+		cfg["OUTBOX_RETENTION_MODE"] = "disabled"
+		addAll(cfg, db)
+	case "consumer":
+		cfg["ADMIN_HTTP_ADDR"] = addr
+		cfg["KAFKA_GROUP_ID"] = "orders-consumer"
+		cfg["KAFKA_DLQ_TOPIC"] = dlqTopic
+		cfg["KAFKA_RETRY_TOPICS"] = retryTopic + "=1s"
+		cfg["SCHEMA_REGISTRY_DLQ_SCHEMA_ID"] = strconv.Itoa(e.DLQSchemaID)
+		cfg["CONSUMER_COMMIT_POLICY"] = "message"
+	}
+	return cfg
+}
+
+func addAll(dst, src map[string]string) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// Service is a service binary running as a child process.
+type Service struct {
+	t    *testing.T
+	name string
+	Addr string // of its HTTP server: the API, admin API or metrics
+	cmd  *exec.Cmd
+	logs *logBuffer
+	done chan struct{} // closed when the process exits
+}
+
+// Start runs the named service against the test's infrastructure and
+// waits until its readiness probe passes. overrides replace or add
+// configuration variables.
+func (e *Env) Start(name string, overrides map[string]string) *Service {
+	e.t.Helper()
+	port, err := freePort()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	addr := "127.0.0.1:" + strconv.Itoa(port)
+	cfg := e.config(name, addr)
+	addAll(cfg, overrides)
+
+	// Run in an empty directory so no .env.development is picked up, with
+	// only the test's configuration in the environment.
+	dir := e.t.TempDir()
+	cmd := exec.Command(filepath.Join(binDir, name))
+	cmd.Dir = dir
+	cmd.Env = []string{"HOME=" + dir}
+	for k, v := range cfg {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	s := &Service{t: e.t, name: name, Addr: addr, cmd: cmd, logs: &logBuffer{}, done: make(chan struct{})}
+	cmd.Stdout = s.logs
+	cmd.Stderr = s.logs
+	if err := cmd.Start(); err != nil {
+		e.t.Fatalf("failed to start %s: %v", name, err)
+	}
+	go func() {
+		cmd.Wait()
+		close(s.done)
+	}()
+	e.t.Cleanup(s.stop)
+	s.waitReady(30 * time.Second)
+	return s
+}
+
+func (s *Service) waitReady(timeout time.Duration) {
+	s.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case <-s.done:
+			s.t.Fatalf("%s exited during startup:\n%s", s.name, s.logs)
+		default:
+		}
+		resp, err := http.Get("http://" + s.Addr + "/health/ready")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+			if time.Now().After(deadline) {
+				s.t.Fatalf("%s not ready after %s: %s\n%s", s.name, timeout, body, s.logs)
+			}
+		} else if time.Now().After(deadline) {
+			s.t.Fatalf("%s not ready after %s: %v\n%s", s.name, timeout, err, s.logs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// stop sends SIGTERM and kills the service if it does not shut down in
+// time. The output of every service is logged when the test failed.
+func (s *Service) stop() {
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-s.done:
+	case <-time.After(15 * time.Second):
+		s.t.Errorf("%s did not shut down in time", s.name)
+		s.cmd.Process.Kill()
+		<-s.done
+	}
+	if s.t.Failed() {
+		s.t.Logf("%s output:\n%s", s.name, s.logs)
+	}
+}
+
+// PostJSON sends body as JSON and returns the response status and body.
+func (s *Service) PostJSON(path string, body any) (int, []byte) {
+	s.t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+s.Addr+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		s.t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("POST %s: %v", path, err)
+	}
+	return resp.StatusCode, respBody
+}
+
+// WaitLog waits for a log record with message msg whose attributes include
+// attrs, and returns it.
+func (s *Service) WaitLog(msg string, attrs map[string]string) map[string]any {
+	s.t.Helper()
+	var found map[string]any
+	eventually(s.t, 30*time.Second, fmt.Sprintf("%s to log %q %v", s.name, msg, attrs), func() bool {
+		found = s.logs.Find(msg, attrs)
+		return found != nil
+	})
+	return found
+}
+
+// logBuffer collects the output of a service.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Find returns the first JSON log record with message msg whose attributes
+// include attrs, or nil.
+func (b *logBuffer) Find(msg string, attrs map[string]string) map[string]any {
+	scanner := bufio.NewScanner(strings.NewReader(b.String()))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var record map[string]any
+		if json.Unmarshal(scanner.Bytes(), &record) != nil || record["msg"] != msg {
+			continue
+		}
+		if matches(record, attrs) {
+			return record
+		}
+	}
+	return nil
+}
+
+func matches(record map[string]any, attrs map[string]string) bool {
+	for k, v := range attrs {
+		if fmt.Sprint(record[k]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// eventually fails the test unless cond becomes true within timeout.
+func eventually(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out after %s waiting for %s", timeout, what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Produce writes a record to topic as an external producer would.
+func (e *Env) Produce(topic string, key, value []byte) {
+	e.t.Helper()
+	client, err := kgo.NewClient(kgo.SeedBrokers(e.Kafka.ListenAddrs()...))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.ProduceSync(ctx, &kgo.Record{Topic: topic, Key: key, Value: value}).FirstErr(); err != nil {
+		e.t.Fatalf("failed to produce to %s: %v", topic, err)
+	}
+}
+
+// Consume reads topic from the beginning until n records arrived.
+func (e *Env) Consume(topic string, n int) []*kgo.Record {
+	e.t.Helper()
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(e.Kafka.ListenAddrs()...),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for len(records) < n {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			e.t.Fatalf("timed out waiting for %d records on %s, got %d", n, topic, len(records))
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			e.t.Fatalf("failed to fetch %s[%d]: %v", topic, partition, err)
+		})
+		records = append(records, fetches.Records()...)
+	}
+	return records
+}
+
+// DecodeDLQ decodes a DLQ record written with the DLQ schema.
+func (e *Env) DecodeDLQ(record *kgo.Record) map[string]any {
+	e.t.Helper()
+	value := record.Value
+	if len(value) < 5 || value[0] != 0 {
+		e.t.Fatalf("DLQ record is not schema registry framed: %q", value)
+	}
+	if id := int(binary.BigEndian.Uint32(value[1:5])); id != e.DLQSchemaID {
+		e.t.Fatalf("DLQ record has schema ID %d, want %d", id, e.DLQSchemaID)
+	}
+	native, _, err := e.dlqCodec.NativeFromBinary(value[5:])
+	if err != nil {
+		e.t.Fatalf("failed to decode DLQ record: %v", err)
+	}
+	return native.(map[string]any)
+}
+
+// OutboxEvent returns the ID and status of the outbox event of an order.
+func (e *Env) OutboxEvent(orderID string) (id, status string) {
+	e.t.Helper()
+	err := e.DB.QueryRow(
+		`SELECT id, status FROM outbox_events WHERE aggregate_id = $1`, orderID,
+	).Scan(&id, &status)
+	if err != nil {
+		e.t.Fatalf("failed to read outbox event of order %s: %v", orderID, err)
+	}
+	return id, status
+}
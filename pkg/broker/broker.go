@@ -0,0 +1,130 @@
+// Package broker abstracts the message broker used by the services so the
+// outbox publisher, consumer and DLQ can run against Kafka in production and
+// against the in-memory implementation in tests.
+package broker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrClosed           = errors.New("broker: closed")
+	ErrNotTransactional = errors.New("broker: publisher is not transactional")
+	ErrNotAssigned      = errors.New("broker: partition is not assigned to this subscriber")
+)
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Time      time.Time
+	// HighWatermark is the partition's end offset when the message was
+	// fetched by a Subscriber, so HighWatermark-Offset-1 is how far the
+	// subscriber lags behind. It is zero on messages returned by Read.
+	HighWatermark int64
+
+	leaderEpoch int32 // Kafka only, lets commits be fenced by the partition leader
+}
+
+// Header returns the value of the first header with the given key.
+func (m Message) Header(key string) ([]byte, bool) {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return h.Value, true
+		}
+	}
+	return nil, false
+}
+
+type TopicPartition struct {
+	Topic     string
+	Partition int
+}
+
+// Broker creates publishers and consumer group subscriptions.
+type Broker interface {
+	Publisher(cfg PublisherConfig) (Publisher, error)
+	Subscribe(cfg SubscriberConfig) (Subscriber, error)
+	// Read returns the messages of a partition in [from, to) without joining
+	// a group, e.g. to rebuild state from recently consumed messages.
+	Read(ctx context.Context, partition TopicPartition, from, to int64) ([]Message, error)
+}
+
+type PublisherConfig struct {
+	Topic           string // used for messages that do not set Topic
+	Idempotent      bool
+	TransactionalID string // enables TxPublisher, implies Idempotent
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msgs ...Message) error
+	// Ping checks that the cluster is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// TxPublisher publishes atomically: messages published between BeginTx and
+// CommitTx become visible to subscribers together, or not at all.
+type TxPublisher interface {
+	Publisher
+	BeginTx(ctx context.Context) error
+	CommitTx(ctx context.Context) error
+	AbortTx(ctx context.Context) error
+}
+
+type SubscriberConfig struct {
+	Topics  []string
+	GroupID string
+	// Cooperative enables incremental rebalancing with sticky assignment:
+	// only partitions that move to another member are revoked, instead of
+	// every member giving up all its partitions on every rebalance.
+	Cooperative bool
+
+	// OnAssigned is called before the first message of newly assigned
+	// partitions is returned from Fetch.
+	OnAssigned func(ctx context.Context, partitions []TopicPartition)
+	// OnRevoked is called before partitions move to another group member.
+	// Offsets committed from OnRevoked are seen by the next owner. In
+	// cooperative mode partitions kept across a rebalance are not revoked.
+	OnRevoked func(ctx context.Context, partitions []TopicPartition)
+}
+
+// Subscriber is a consumer group member. Offsets are never committed
+// automatically.
+type Subscriber interface {
+	Fetch(ctx context.Context) (Message, error)
+	// Commit marks every message up to and including msgs as consumed for
+	// their partitions.
+	Commit(ctx context.Context, msgs ...Message) error
+	// Committed returns the group's committed offsets, the next offset to
+	// consume, of those partitions that have one.
+	Committed(ctx context.Context, partitions []TopicPartition) (map[TopicPartition]int64, error)
+	// Pause stops Fetch from returning messages of the partitions until they
+	// are resumed. Messages already fetched from them are held back, not
+	// dropped, and pausing survives a rebalance.
+	Pause(partitions ...TopicPartition)
+	Resume(partitions ...TopicPartition)
+	// Seek makes Fetch continue an assigned partition at offset, dropping
+	// messages already fetched from it. The committed offset is unchanged
+	// until the next Commit.
+	Seek(ctx context.Context, partition TopicPartition, offset int64) error
+	// OffsetForTime returns the first offset of the partition whose message
+	// time is at or after t, or the end offset when there is none.
+	OffsetForTime(ctx context.Context, partition TopicPartition, t time.Time) (int64, error)
+	// Ping checks that the cluster is reachable.
+	Ping(ctx context.Context) error
+	// Member returns the subscriber's member ID in its group, or false while
+	// it has not joined the group.
+	Member() (string, bool)
+	Close() error
+}
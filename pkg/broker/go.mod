@@ -0,0 +1,13 @@
+module github.com/dzon2000/eda/pkg/broker
+
+go 1.25.5
+
+require (
+	github.com/twmb/franz-go v1.21.7
+	github.com/twmb/franz-go/pkg/kmsg v1.13.1
+)
+
+require (
+	github.com/klauspost/compress v1.19.2 // indirect
+	github.com/pierrec/lz4/v4 v4.1.26 // indirect
+)
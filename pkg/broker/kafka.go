@@ -0,0 +1,490 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// closeFlushTimeout bounds how long Close waits for buffered records.
+const closeFlushTimeout = 10 * time.Second
+
+type KafkaConfig struct {
+	Brokers    []string
+	MaxRetries int // produce retries per record
+	MinBytes   int
+	MaxBytes   int
+}
+
+// Kafka is the production Broker, backed by franz-go. Unlike kafka-go's
+// Reader, franz-go exposes partition assignment and revocation, which the
+// Subscriber contract requires.
+type Kafka struct {
+	config KafkaConfig
+}
+
+func NewKafka(cfg KafkaConfig) *Kafka {
+	return &Kafka{config: cfg}
+}
+
+func (k *Kafka) Publisher(cfg PublisherConfig) (Publisher, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(k.config.Brokers...),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		kgo.RecordRetries(k.config.MaxRetries),
+	}
+	if cfg.Topic != "" {
+		opts = append(opts, kgo.DefaultProduceTopic(cfg.Topic))
+	}
+	switch {
+	case cfg.TransactionalID != "":
+		opts = append(opts, kgo.TransactionalID(cfg.TransactionalID))
+	case !cfg.Idempotent:
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	return &kafkaPublisher{
+		client:        client,
+		transactional: cfg.TransactionalID != "",
+	}, nil
+}
+
+func (k *Kafka) Subscribe(cfg SubscriberConfig) (Subscriber, error) {
+	s := &kafkaSubscriber{config: cfg}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(k.config.Brokers...),
+		kgo.ConsumerGroup(cfg.GroupID),
+		kgo.ConsumeTopics(cfg.Topics...),
+		kgo.DisableAutoCommit(),
+		// Skip records from aborted producer transactions.
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.OnPartitionsAssigned(s.onAssigned),
+		kgo.OnPartitionsRevoked(s.onRevoked),
+		kgo.OnPartitionsLost(s.onRevoked),
+	}
+	if cfg.Cooperative {
+		opts = append(opts, kgo.Balancers(kgo.CooperativeStickyBalancer()))
+	} else {
+		opts = append(opts, kgo.Balancers(kgo.RangeBalancer(), kgo.RoundRobinBalancer()))
+	}
+	opts = append(opts, k.fetchOpts()...)
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	s.client = client
+	return s, nil
+}
+
+func (k *Kafka) Read(ctx context.Context, partition TopicPartition, from, to int64) ([]Message, error) {
+	if from >= to {
+		return nil, nil
+	}
+	opts := append([]kgo.Opt{
+		kgo.SeedBrokers(k.config.Brokers...),
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+			partition.Topic: {int32(partition.Partition): kgo.NewOffset().At(from)},
+		}),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	}, k.fetchOpts()...)
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	defer client.Close()
+
+	// Offsets of aborted transactions and control records are never
+	// returned, so stop at the first record at or past to-1.
+	var msgs []Message
+	for {
+		fetches := client.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			return msgs, err
+		}
+		if err := fetches.Err(); err != nil {
+			return msgs, fmt.Errorf("failed to read %s[%d]: %w", partition.Topic, partition.Partition, err)
+		}
+		for _, record := range fetches.Records() {
+			if record.Offset >= to {
+				return msgs, nil
+			}
+			msgs = append(msgs, fromRecord(record))
+			if record.Offset == to-1 {
+				return msgs, nil
+			}
+		}
+	}
+}
+
+func (k *Kafka) fetchOpts() []kgo.Opt {
+	var opts []kgo.Opt
+	if k.config.MinBytes > 0 {
+		opts = append(opts, kgo.FetchMinBytes(int32(k.config.MinBytes)))
+	}
+	if k.config.MaxBytes > 0 {
+		opts = append(opts, kgo.FetchMaxBytes(int32(k.config.MaxBytes)))
+	}
+	return opts
+}
+
+type kafkaPublisher struct {
+	client        *kgo.Client
+	transactional bool
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, msgs ...Message) error {
+	records := make([]*kgo.Record, 0, len(msgs))
+	for _, msg := range msgs {
+		records = append(records, toRecord(msg))
+	}
+	return p.client.ProduceSync(ctx, records...).FirstErr()
+}
+
+func (p *kafkaPublisher) BeginTx(ctx context.Context) error {
+	if !p.transactional {
+		return ErrNotTransactional
+	}
+	return p.client.BeginTransaction()
+}
+
+func (p *kafkaPublisher) CommitTx(ctx context.Context) error {
+	if !p.transactional {
+		return ErrNotTransactional
+	}
+	if err := p.client.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to flush transaction: %w", err)
+	}
+	return p.client.EndTransaction(ctx, kgo.TryCommit)
+}
+
+func (p *kafkaPublisher) AbortTx(ctx context.Context) error {
+	if !p.transactional {
+		return ErrNotTransactional
+	}
+	if err := p.client.AbortBufferedRecords(ctx); err != nil {
+		return fmt.Errorf("failed to abort buffered records: %w", err)
+	}
+	return p.client.EndTransaction(ctx, kgo.TryAbort)
+}
+
+func (p *kafkaPublisher) Ping(ctx context.Context) error {
+	return p.client.Ping(ctx)
+}
+
+// Close flushes records still buffered by the client before disconnecting.
+func (p *kafkaPublisher) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeFlushTimeout)
+	defer cancel()
+	err := p.client.Flush(ctx)
+	p.client.Close()
+	return err
+}
+
+type kafkaSubscriber struct {
+	client *kgo.Client
+	config SubscriberConfig
+
+	mu       sync.Mutex
+	buffered []*kgo.Record
+	held     map[TopicPartition][]*kgo.Record // buffered records of paused partitions
+	assigned []TopicPartition
+	// watermarks holds the high watermark of every partition as of its
+	// latest fetch.
+	watermarks map[TopicPartition]int64
+}
+
+func (s *kafkaSubscriber) Fetch(ctx context.Context) (Message, error) {
+	for {
+		s.mu.Lock()
+		if len(s.buffered) > 0 {
+			record := s.buffered[0]
+			s.buffered = s.buffered[1:]
+			msg := fromRecord(record)
+			msg.HighWatermark = s.watermarks[TopicPartition{Topic: msg.Topic, Partition: msg.Partition}]
+			s.mu.Unlock()
+			return msg, nil
+		}
+		s.mu.Unlock()
+
+		fetches := s.client.PollFetches(ctx)
+		if fetches.IsClientClosed() {
+			return Message{}, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return Message{}, err
+		}
+
+		records := fetches.Records()
+		if len(records) == 0 {
+			var errs []error
+			fetches.EachError(func(topic string, partition int32, err error) {
+				errs = append(errs, fmt.Errorf("%s[%d]: %w", topic, partition, err))
+			})
+			if len(errs) > 0 {
+				return Message{}, errors.Join(errs...)
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		if s.watermarks == nil {
+			s.watermarks = make(map[TopicPartition]int64)
+		}
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			s.watermarks[TopicPartition{Topic: p.Topic, Partition: int(p.Partition)}] = p.HighWatermark
+		})
+		s.buffered = append(s.buffered, records...)
+		s.mu.Unlock()
+	}
+}
+
+func (s *kafkaSubscriber) Commit(ctx context.Context, msgs ...Message) error {
+	records := make([]*kgo.Record, 0, len(msgs))
+	for _, msg := range msgs {
+		records = append(records, &kgo.Record{
+			Topic:       msg.Topic,
+			Partition:   int32(msg.Partition),
+			Offset:      msg.Offset,
+			LeaderEpoch: msg.leaderEpoch,
+		})
+	}
+	return s.client.CommitRecords(ctx, records...)
+}
+
+func (s *kafkaSubscriber) Committed(ctx context.Context, partitions []TopicPartition) (map[TopicPartition]int64, error) {
+	group := kmsg.NewOffsetFetchRequestGroup()
+	group.Group = s.config.GroupID
+	for topic, ps := range fromTopicPartitions(partitions) {
+		reqTopic := kmsg.NewOffsetFetchRequestGroupTopic()
+		reqTopic.Topic = topic
+		reqTopic.Partitions = ps
+		group.Topics = append(group.Topics, reqTopic)
+	}
+	req := kmsg.NewPtrOffsetFetchRequest()
+	req.Groups = append(req.Groups, group)
+
+	resp, err := req.RequestWith(ctx, s.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets: %w", err)
+	}
+	committed := make(map[TopicPartition]int64)
+	for _, g := range resp.Groups {
+		if err := kerr.ErrorForCode(g.ErrorCode); err != nil {
+			return nil, fmt.Errorf("failed to fetch committed offsets: %w", err)
+		}
+		for _, topic := range g.Topics {
+			for _, p := range topic.Partitions {
+				if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+					return nil, fmt.Errorf("failed to fetch committed offset of %s[%d]: %w", topic.Topic, p.Partition, err)
+				}
+				if p.Offset >= 0 {
+					committed[TopicPartition{Topic: topic.Topic, Partition: int(p.Partition)}] = p.Offset
+				}
+			}
+		}
+	}
+	return committed, nil
+}
+
+func (s *kafkaSubscriber) Pause(partitions ...TopicPartition) {
+	s.client.PauseFetchPartitions(fromTopicPartitions(partitions))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		s.held = make(map[TopicPartition][]*kgo.Record)
+	}
+	kept := s.buffered[:0]
+	for _, record := range s.buffered {
+		tp := TopicPartition{Topic: record.Topic, Partition: int(record.Partition)}
+		if slices.Contains(partitions, tp) {
+			s.held[tp] = append(s.held[tp], record)
+		} else {
+			kept = append(kept, record)
+		}
+	}
+	s.buffered = kept
+}
+
+// Resume puts held records back in front of the buffer: the client only
+// fetches records after them once the partition is resumed.
+func (s *kafkaSubscriber) Resume(partitions ...TopicPartition) {
+	s.mu.Lock()
+	var held []*kgo.Record
+	for _, tp := range partitions {
+		held = append(held, s.held[tp]...)
+		delete(s.held, tp)
+	}
+	s.buffered = append(held, s.buffered...)
+	s.mu.Unlock()
+
+	s.client.ResumeFetchPartitions(fromTopicPartitions(partitions))
+}
+
+func (s *kafkaSubscriber) Seek(ctx context.Context, partition TopicPartition, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.assigned, partition) {
+		return fmt.Errorf("%w: %s[%d]", ErrNotAssigned, partition.Topic, partition.Partition)
+	}
+	s.buffered = slices.DeleteFunc(s.buffered, func(record *kgo.Record) bool {
+		return record.Topic == partition.Topic && int(record.Partition) == partition.Partition
+	})
+	delete(s.held, partition)
+	s.client.SetOffsets(map[string]map[int32]kgo.EpochOffset{
+		partition.Topic: {int32(partition.Partition): {Epoch: -1, Offset: offset}},
+	})
+	return nil
+}
+
+func (s *kafkaSubscriber) OffsetForTime(ctx context.Context, partition TopicPartition, t time.Time) (int64, error) {
+	offset, err := s.listOffset(ctx, partition, t.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		// No message at or after t.
+		return s.listOffset(ctx, partition, -1)
+	}
+	return offset, nil
+}
+
+// listOffset asks the partition leader for the first offset at or after
+// timestamp, which is -1 for the end offset.
+func (s *kafkaSubscriber) listOffset(ctx context.Context, partition TopicPartition, timestamp int64) (int64, error) {
+	reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+	reqPartition.Partition = int32(partition.Partition)
+	reqPartition.Timestamp = timestamp
+	reqTopic := kmsg.NewListOffsetsRequestTopic()
+	reqTopic.Topic = partition.Topic
+	reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.ReplicaID = -1
+	req.IsolationLevel = 1 // read committed, matching Fetch
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(ctx, s.client)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list offsets of %s[%d]: %w", partition.Topic, partition.Partition, err)
+	}
+	for _, topic := range resp.Topics {
+		for _, p := range topic.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return 0, fmt.Errorf("failed to list offsets of %s[%d]: %w", partition.Topic, partition.Partition, err)
+			}
+			return p.Offset, nil
+		}
+	}
+	return 0, fmt.Errorf("no offsets returned for %s[%d]", partition.Topic, partition.Partition)
+}
+
+func (s *kafkaSubscriber) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx)
+}
+
+// Member reports the member ID once the group has been joined, which is
+// when the client has a generation.
+func (s *kafkaSubscriber) Member() (string, bool) {
+	memberID, generation := s.client.GroupMetadata()
+	return memberID, memberID != "" && generation >= 0
+}
+
+func (s *kafkaSubscriber) Close() error {
+	s.client.Close()
+	return nil
+}
+
+func (s *kafkaSubscriber) onAssigned(ctx context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	s.mu.Lock()
+	s.assigned = append(s.assigned, toTopicPartitions(assigned)...)
+	s.mu.Unlock()
+
+	if s.config.OnAssigned != nil {
+		s.config.OnAssigned(ctx, toTopicPartitions(assigned))
+	}
+}
+
+// onRevoked drops records already polled from the revoked partitions, the
+// next owner starts again from the committed offset.
+func (s *kafkaSubscriber) onRevoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	s.mu.Lock()
+	kept := s.buffered[:0]
+	for _, record := range s.buffered {
+		partitions, ok := revoked[record.Topic]
+		if !ok || !slices.Contains(partitions, record.Partition) {
+			kept = append(kept, record)
+		}
+	}
+	s.buffered = kept
+	for _, tp := range toTopicPartitions(revoked) {
+		delete(s.held, tp)
+	}
+	s.assigned = slices.DeleteFunc(s.assigned, func(tp TopicPartition) bool {
+		partitions, ok := revoked[tp.Topic]
+		return ok && slices.Contains(partitions, int32(tp.Partition))
+	})
+	s.mu.Unlock()
+
+	if s.config.OnRevoked != nil {
+		s.config.OnRevoked(ctx, toTopicPartitions(revoked))
+	}
+}
+
+func toRecord(msg Message) *kgo.Record {
+	record := &kgo.Record{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Timestamp: msg.Time,
+	}
+	for _, h := range msg.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return record
+}
+
+func fromRecord(record *kgo.Record) Message {
+	msg := Message{
+		Topic:       record.Topic,
+		Partition:   int(record.Partition),
+		Offset:      record.Offset,
+		Key:         record.Key,
+		Value:       record.Value,
+		Time:        record.Timestamp,
+		leaderEpoch: record.LeaderEpoch,
+	}
+	for _, h := range record.Headers {
+		msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return msg
+}
+
+func fromTopicPartitions(tps []TopicPartition) map[string][]int32 {
+	m := make(map[string][]int32)
+	for _, tp := range tps {
+		m[tp.Topic] = append(m[tp.Topic], int32(tp.Partition))
+	}
+	return m
+}
+
+func toTopicPartitions(m map[string][]int32) []TopicPartition {
+	var tps []TopicPartition
+	for topic, partitions := range m {
+		for _, p := range partitions {
+			tps = append(tps, TopicPartition{Topic: topic, Partition: int(p)})
+		}
+	}
+	return tps
+}
@@ -0,0 +1,572 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process broker for tests. Like Kafka it keeps every
+// message in partitioned logs, hands out offsets per partition, stores
+// committed offsets per consumer group and rebalances partitions between
+// group members whenever a subscriber joins or leaves.
+type Memory struct {
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced on every state change
+	partitions int
+	topics     map[string][][]Message
+	groups     map[string]*memoryGroup
+	roundRobin map[string]int
+	members    int // member IDs handed out
+}
+
+func NewMemory(partitions int) *Memory {
+	if partitions <= 0 {
+		partitions = 1
+	}
+	return &Memory{
+		changed:    make(chan struct{}),
+		partitions: partitions,
+		topics:     make(map[string][][]Message),
+		groups:     make(map[string]*memoryGroup),
+		roundRobin: make(map[string]int),
+	}
+}
+
+// CreateTopic creates a topic with the given number of partitions. Topics
+// used without being created get the broker's default partition count.
+func (m *Memory) CreateTopic(topic string, partitions int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.topics[topic]; !ok {
+		m.topics[topic] = make([][]Message, max(partitions, 1))
+	}
+}
+
+// Messages returns a copy of every message in the topic, partition by
+// partition.
+func (m *Memory) Messages(topic string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var msgs []Message
+	for _, log := range m.topics[topic] {
+		msgs = append(msgs, log...)
+	}
+	return msgs
+}
+
+// Committed returns the next offset the group will consume from the
+// partition, and false when the group never committed it.
+func (m *Memory) Committed(groupID string, topic string, partition int) (int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.groups[groupID]
+	if !ok {
+		return 0, false
+	}
+	offset, ok := g.committed[TopicPartition{Topic: topic, Partition: partition}]
+	return offset, ok
+}
+
+func (m *Memory) Read(ctx context.Context, partition TopicPartition, from, to int64) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	partitions, ok := m.topics[partition.Topic]
+	if !ok || partition.Partition >= len(partitions) {
+		return nil, fmt.Errorf("broker: unknown partition %s[%d]", partition.Topic, partition.Partition)
+	}
+	log := partitions[partition.Partition]
+	from = min(max(from, 0), int64(len(log)))
+	to = min(max(to, from), int64(len(log)))
+	return slices.Clone(log[from:to]), nil
+}
+
+func (m *Memory) Publisher(cfg PublisherConfig) (Publisher, error) {
+	return &memoryPublisher{broker: m, topic: cfg.Topic}, nil
+}
+
+func (m *Memory) Subscribe(cfg SubscriberConfig) (Subscriber, error) {
+	if cfg.GroupID == "" {
+		return nil, fmt.Errorf("broker: group ID is required")
+	}
+	if len(cfg.Topics) == 0 {
+		return nil, fmt.Errorf("broker: at least one topic is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, topic := range cfg.Topics {
+		m.topicLocked(topic)
+	}
+	g, ok := m.groups[cfg.GroupID]
+	if !ok {
+		g = &memoryGroup{committed: make(map[TopicPartition]int64)}
+		m.groups[cfg.GroupID] = g
+	}
+	m.members++
+	s := &memorySubscriber{
+		broker:    m,
+		group:     g,
+		config:    cfg,
+		memberID:  fmt.Sprintf("%s-%d", cfg.GroupID, m.members),
+		positions: make(map[TopicPartition]int64),
+	}
+	g.members = append(g.members, s)
+	m.rebalanceLocked(g)
+	return s, nil
+}
+
+// broadcastLocked wakes up every subscriber waiting in Fetch.
+func (m *Memory) broadcastLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+func (m *Memory) topicLocked(topic string) [][]Message {
+	partitions, ok := m.topics[topic]
+	if !ok {
+		partitions = make([][]Message, m.partitions)
+		m.topics[topic] = partitions
+	}
+	return partitions
+}
+
+func (m *Memory) append(defaultTopic string, msgs []Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range msgs {
+		if msg.Topic == "" {
+			msg.Topic = defaultTopic
+		}
+		if msg.Topic == "" {
+			return fmt.Errorf("broker: message has no topic")
+		}
+		partitions := m.topicLocked(msg.Topic)
+		msg.Partition = m.partitionLocked(msg.Topic, msg.Key, len(partitions))
+		msg.Offset = int64(len(partitions[msg.Partition]))
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		partitions[msg.Partition] = append(partitions[msg.Partition], msg)
+	}
+	m.broadcastLocked()
+	return nil
+}
+
+// partitionLocked hashes keyed messages so one key always lands on one
+// partition, and spreads keyless messages round-robin.
+func (m *Memory) partitionLocked(topic string, key []byte, partitions int) int {
+	if key == nil {
+		p := m.roundRobin[topic] % partitions
+		m.roundRobin[topic]++
+		return p
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(partitions))
+}
+
+type memoryPublisher struct {
+	broker *Memory
+	topic  string
+
+	mu      sync.Mutex
+	inTx    bool
+	pending []Message
+	closed  bool
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, msgs ...Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.inTx {
+		p.pending = append(p.pending, msgs...)
+		return nil
+	}
+	return p.broker.append(p.topic, msgs)
+}
+
+func (p *memoryPublisher) BeginTx(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inTx {
+		return errors.New("broker: transaction already in progress")
+	}
+	p.inTx = true
+	return nil
+}
+
+func (p *memoryPublisher) CommitTx(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.inTx {
+		return errors.New("broker: no transaction in progress")
+	}
+	pending := p.pending
+	p.inTx, p.pending = false, nil
+	return p.broker.append(p.topic, pending)
+}
+
+func (p *memoryPublisher) AbortTx(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inTx, p.pending = false, nil
+	return nil
+}
+
+func (p *memoryPublisher) Ping(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (p *memoryPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed, p.inTx, p.pending = true, false, nil
+	return nil
+}
+
+type memoryGroup struct {
+	members   []*memorySubscriber // in join order
+	committed map[TopicPartition]int64
+}
+
+// rebalanceLocked computes a new assignment and starts a rebalance. In an
+// eager rebalance every member first gives up all its partitions (running
+// OnRevoked from its next Fetch); a cooperative member only gives up the
+// partitions that move elsewhere. Once all members have done so the new
+// assignments are handed out. Like a Kafka rebalance, a member that stops
+// calling Fetch holds up the whole group.
+func (m *Memory) rebalanceLocked(g *memoryGroup) {
+	targets := m.assignLocked(g)
+	for _, s := range g.members {
+		s.target = targets[s]
+		s.revoking = slices.Clone(s.owned)
+		if s.config.Cooperative {
+			s.revoking = slices.DeleteFunc(s.revoking, func(tp TopicPartition) bool { return slices.Contains(s.target, tp) })
+		}
+		s.rebalancing = true
+	}
+	m.completeRebalanceLocked(g)
+	m.broadcastLocked()
+}
+
+// assignLocked spreads partitions round-robin over the members subscribed
+// to their topic. Cooperative members keep the partitions they own as long
+// as that leaves the assignment balanced.
+func (m *Memory) assignLocked(g *memoryGroup) map[*memorySubscriber][]TopicPartition {
+	var topics []string
+	for _, s := range g.members {
+		for _, topic := range s.config.Topics {
+			if !slices.Contains(topics, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	slices.Sort(topics)
+
+	targets := make(map[*memorySubscriber][]TopicPartition)
+	for _, topic := range topics {
+		var members []*memorySubscriber
+		for _, s := range g.members {
+			if slices.Contains(s.config.Topics, topic) {
+				members = append(members, s)
+			}
+		}
+		assignTopic(topic, len(m.topics[topic]), members, targets)
+	}
+	return targets
+}
+
+// assignTopic assigns the partitions of one topic. Every member gets
+// partitions/members partitions, the first partitions%members one more;
+// sticky (cooperative) members first keep what they own up to that quota.
+func assignTopic(topic string, partitions int, members []*memorySubscriber, targets map[*memorySubscriber][]TopicPartition) {
+	quota := make(map[*memorySubscriber]int, len(members))
+	for i, s := range members {
+		quota[s] = partitions / len(members)
+		if i < partitions%len(members) {
+			quota[s]++
+		}
+	}
+
+	taken := make([]bool, partitions)
+	for _, s := range members {
+		if !s.config.Cooperative {
+			continue
+		}
+		for _, tp := range s.owned {
+			if tp.Topic == topic && tp.Partition < partitions && !taken[tp.Partition] && quota[s] > 0 {
+				targets[s] = append(targets[s], tp)
+				taken[tp.Partition] = true
+				quota[s]--
+			}
+		}
+	}
+
+	next := 0
+	for p := range partitions {
+		if taken[p] {
+			continue
+		}
+		for quota[members[next%len(members)]] == 0 {
+			next++
+		}
+		s := members[next%len(members)]
+		targets[s] = append(targets[s], TopicPartition{Topic: topic, Partition: p})
+		quota[s]--
+		next++
+	}
+}
+
+// completeRebalanceLocked hands out the new assignment once no member has
+// revocations left to run.
+func (m *Memory) completeRebalanceLocked(g *memoryGroup) {
+	for _, s := range g.members {
+		if s.rebalancing && len(s.revoking) > 0 {
+			return
+		}
+	}
+	for _, s := range g.members {
+		if !s.rebalancing {
+			continue
+		}
+		for _, tp := range s.target {
+			if slices.Contains(s.owned, tp) {
+				continue
+			}
+			s.owned = append(s.owned, tp)
+			s.assigned = append(s.assigned, tp)
+			s.positions[tp] = g.committed[tp]
+		}
+		s.target, s.rebalancing = nil, false
+	}
+}
+
+type memorySubscriber struct {
+	broker   *Memory
+	group    *memoryGroup
+	config   SubscriberConfig
+	memberID string
+
+	// Guarded by broker.mu.
+	owned       []TopicPartition
+	positions   map[TopicPartition]int64 // next offset to return per owned partition
+	rebalancing bool
+	target      []TopicPartition // assignment to take once the rebalance completes
+	revoking    []TopicPartition // owned partitions waiting for OnRevoked
+	assigned    []TopicPartition // new partitions waiting for OnAssigned
+	paused      []TopicPartition
+	cursor      int
+	closed      bool
+}
+
+func (s *memorySubscriber) Fetch(ctx context.Context) (Message, error) {
+	m := s.broker
+	m.mu.Lock()
+	for {
+		if s.closed {
+			m.mu.Unlock()
+			return Message{}, ErrClosed
+		}
+
+		if len(s.revoking) > 0 {
+			revoked := slices.Clone(s.revoking)
+			m.mu.Unlock()
+			if s.config.OnRevoked != nil {
+				s.config.OnRevoked(ctx, revoked)
+			}
+			m.mu.Lock()
+			s.owned = slices.DeleteFunc(s.owned, func(tp TopicPartition) bool { return slices.Contains(revoked, tp) })
+			s.revoking = slices.DeleteFunc(s.revoking, func(tp TopicPartition) bool { return slices.Contains(revoked, tp) })
+			for _, tp := range revoked {
+				delete(s.positions, tp)
+			}
+			m.completeRebalanceLocked(s.group)
+			m.broadcastLocked()
+			continue
+		}
+
+		if len(s.assigned) > 0 {
+			assigned := s.assigned
+			s.assigned = nil
+			if s.config.OnAssigned != nil {
+				m.mu.Unlock()
+				s.config.OnAssigned(ctx, assigned)
+				m.mu.Lock()
+			}
+			continue
+		}
+
+		if msg, ok := s.nextLocked(); ok {
+			m.mu.Unlock()
+			return msg, nil
+		}
+
+		changed := m.changed
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-changed:
+		}
+		m.mu.Lock()
+	}
+}
+
+// nextLocked returns the next unread message, rotating over the owned
+// partitions so a busy partition cannot starve the others.
+func (s *memorySubscriber) nextLocked() (Message, bool) {
+	for i := range s.owned {
+		idx := (s.cursor + i) % len(s.owned)
+		tp := s.owned[idx]
+		if slices.Contains(s.paused, tp) {
+			continue
+		}
+		log := s.broker.topics[tp.Topic][tp.Partition]
+		pos := s.positions[tp]
+		if pos < int64(len(log)) {
+			s.positions[tp] = pos + 1
+			s.cursor = idx + 1
+			msg := log[pos]
+			msg.HighWatermark = int64(len(log))
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+func (s *memorySubscriber) Commit(ctx context.Context, msgs ...Message) error {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, msg := range msgs {
+		tp := TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+		if !slices.Contains(s.owned, tp) {
+			return fmt.Errorf("%w: %s[%d]", ErrNotAssigned, tp.Topic, tp.Partition)
+		}
+		s.group.committed[tp] = msg.Offset + 1
+	}
+	return nil
+}
+
+func (s *memorySubscriber) Pause(partitions ...TopicPartition) {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tp := range partitions {
+		if !slices.Contains(s.paused, tp) {
+			s.paused = append(s.paused, tp)
+		}
+	}
+}
+
+func (s *memorySubscriber) Resume(partitions ...TopicPartition) {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.paused = slices.DeleteFunc(s.paused, func(tp TopicPartition) bool { return slices.Contains(partitions, tp) })
+	m.broadcastLocked()
+}
+
+func (s *memorySubscriber) Seek(ctx context.Context, partition TopicPartition, offset int64) error {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.Contains(s.owned, partition) {
+		return fmt.Errorf("%w: %s[%d]", ErrNotAssigned, partition.Topic, partition.Partition)
+	}
+	end := int64(len(m.topics[partition.Topic][partition.Partition]))
+	s.positions[partition] = min(max(offset, 0), end)
+	m.broadcastLocked()
+	return nil
+}
+
+func (s *memorySubscriber) OffsetForTime(ctx context.Context, partition TopicPartition, t time.Time) (int64, error) {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	partitions, ok := m.topics[partition.Topic]
+	if !ok || partition.Partition >= len(partitions) {
+		return 0, fmt.Errorf("broker: unknown partition %s[%d]", partition.Topic, partition.Partition)
+	}
+	log := partitions[partition.Partition]
+	for _, msg := range log {
+		if !msg.Time.Before(t) {
+			return msg.Offset, nil
+		}
+	}
+	return int64(len(log)), nil
+}
+
+func (s *memorySubscriber) Committed(ctx context.Context, partitions []TopicPartition) (map[TopicPartition]int64, error) {
+	m := s.broker
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	committed := make(map[TopicPartition]int64)
+	for _, tp := range partitions {
+		if offset, ok := s.group.committed[tp]; ok {
+			committed[tp] = offset
+		}
+	}
+	return committed, nil
+}
+
+func (s *memorySubscriber) Ping(ctx context.Context) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Member reports the subscriber as a member from Subscribe until Close.
+func (s *memorySubscriber) Member() (string, bool) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.memberID, !s.closed
+}
+
+// Close leaves the group, revoking the owned partitions first so their
+// offsets can be committed.
+func (s *memorySubscriber) Close() error {
+	m := s.broker
+	m.mu.Lock()
+	if s.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	owned := slices.Clone(s.owned)
+	m.mu.Unlock()
+
+	if len(owned) > 0 && s.config.OnRevoked != nil {
+		s.config.OnRevoked(context.Background(), owned)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.closed = true
+	s.owned, s.revoking, s.assigned = nil, nil, nil
+	s.group.members = slices.DeleteFunc(s.group.members, func(member *memorySubscriber) bool { return member == s })
+	if len(s.group.members) > 0 {
+		m.rebalanceLocked(s.group)
+	} else {
+		m.broadcastLocked()
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func publish(t *testing.T, m *Memory, topic string, keys ...string) {
+	t.Helper()
+	p, err := m.Publisher(PublisherConfig{Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for _, key := range keys {
+		if err := p.Publish(context.Background(), Message{Key: []byte(key), Value: []byte("value of " + key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func subscribe(t *testing.T, m *Memory, cfg SubscriberConfig) Subscriber {
+	t.Helper()
+	s, err := m.Subscribe(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// fetch returns the next n messages of s.
+func fetch(t *testing.T, s Subscriber, n int) []Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var msgs []Message
+	for range n {
+		msg, err := s.Fetch(ctx)
+		if err != nil {
+			t.Fatalf("fetched %d of %d messages: %v", len(msgs), n, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// fetchNone checks that s has no message ready.
+func fetchNone(t *testing.T, s Subscriber) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if msg, err := s.Fetch(ctx); err == nil {
+		t.Fatalf("fetched %s[%d]@%d, want no message", msg.Topic, msg.Partition, msg.Offset)
+	}
+}
+
+func TestMemoryPartitions(t *testing.T) {
+	m := NewMemory(3)
+	publish(t, m, "orders", "a", "b", "a", "c", "a", "b")
+
+	byKey := make(map[string]int)
+	next := make(map[int]int64)
+	for _, msg := range m.Messages("orders") {
+		if p, ok := byKey[string(msg.Key)]; ok && p != msg.Partition {
+			t.Errorf("key %s is on partitions %d and %d", msg.Key, p, msg.Partition)
+		}
+		byKey[string(msg.Key)] = msg.Partition
+		if msg.Offset != next[msg.Partition] {
+			t.Errorf("message on partition %d has offset %d, want %d", msg.Partition, msg.Offset, next[msg.Partition])
+		}
+		next[msg.Partition]++
+	}
+
+	// Keyless messages are spread over every partition.
+	m.CreateTopic("events", 3)
+	p, _ := m.Publisher(PublisherConfig{Topic: "events"})
+	for range 6 {
+		p.Publish(context.Background(), Message{Value: []byte("v")})
+	}
+	counts := make([]int, 3)
+	for _, msg := range m.Messages("events") {
+		counts[msg.Partition]++
+	}
+	if !slices.Equal(counts, []int{2, 2, 2}) {
+		t.Errorf("keyless messages per partition = %v, want 2 each", counts)
+	}
+
+	msgs, err := m.Read(context.Background(), TopicPartition{Topic: "events", Partition: 1}, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Offset != 1 {
+		t.Errorf("Read(1, 10) of a 2 message partition returned %d messages", len(msgs))
+	}
+}
+
+func TestMemoryCommittedOffsetsResume(t *testing.T) {
+	m := NewMemory(1)
+	publish(t, m, "orders", "a", "b", "c", "d")
+
+	s, err := m.Subscribe(SubscriberConfig{Topics: []string{"orders"}, GroupID: "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := fetch(t, s, 3)
+	if err := s.Commit(context.Background(), msgs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := m.Committed("g", "orders", 0); !ok || offset != 2 {
+		t.Errorf("committed offset = %d, %v, want 2", offset, ok)
+	}
+	s.Close()
+	if _, err := s.Fetch(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Fetch after Close returned %v, want ErrClosed", err)
+	}
+
+	// The next member starts at the committed offset, redelivering the
+	// fetched but uncommitted message.
+	s = subscribe(t, m, SubscriberConfig{Topics: []string{"orders"}, GroupID: "g"})
+	if got := fetch(t, s, 2); got[0].Offset != 2 || got[1].Offset != 3 {
+		t.Errorf("resumed at offsets %d, %d, want 2, 3", got[0].Offset, got[1].Offset)
+	}
+	fetchNone(t, s)
+
+	// Another group starts from the beginning.
+	other := subscribe(t, m, SubscriberConfig{Topics: []string{"orders"}, GroupID: "other"})
+	if got := fetch(t, other, 1); got[0].Offset != 0 {
+		t.Errorf("new group started at offset %d, want 0", got[0].Offset)
+	}
+
+	if err := s.Commit(context.Background(), Message{Topic: "unknown", Partition: 0}); !errors.Is(err, ErrNotAssigned) {
+		t.Errorf("committing an unassigned partition returned %v, want ErrNotAssigned", err)
+	}
+}
+
+func TestMemoryTransactions(t *testing.T) {
+	m := NewMemory(1)
+	p, _ := m.Publisher(PublisherConfig{Topic: "orders", TransactionalID: "tx"})
+	tx := p.(TxPublisher)
+	ctx := context.Background()
+
+	tx.BeginTx(ctx)
+	tx.Publish(ctx, Message{Value: []byte("aborted")})
+	if n := len(m.Messages("orders")); n != 0 {
+		t.Fatalf("%d messages visible before the commit", n)
+	}
+	tx.AbortTx(ctx)
+
+	tx.BeginTx(ctx)
+	tx.Publish(ctx, Message{Value: []byte("a")}, Message{Value: []byte("b")})
+	if err := tx.CommitTx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, msg := range m.Messages("orders") {
+		values = append(values, string(msg.Value))
+	}
+	if !slices.Equal(values, []string{"a", "b"}) {
+		t.Errorf("messages = %v, want the committed a, b only", values)
+	}
+}
+
+func TestMemoryPauseAndSeek(t *testing.T) {
+	m := NewMemory(1)
+	publish(t, m, "orders", "a", "b", "c")
+	s := subscribe(t, m, SubscriberConfig{Topics: []string{"orders"}, GroupID: "g"})
+	tp := TopicPartition{Topic: "orders", Partition: 0}
+
+	fetch(t, s, 1)
+	s.Pause(tp)
+	fetchNone(t, s)
+	s.Resume(tp)
+	if got := fetch(t, s, 1); got[0].Offset != 1 {
+		t.Errorf("fetched offset %d after resume, want 1", got[0].Offset)
+	}
+
+	if err := s.Seek(context.Background(), tp, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetch(t, s, 3); got[0].Offset != 0 || got[2].Offset != 2 {
+		t.Errorf("fetched offsets %d..%d after seeking to 0, want 0..2", got[0].Offset, got[2].Offset)
+	}
+	if _, ok := m.Committed("g", "orders", 0); ok {
+		t.Error("Seek committed an offset")
+	}
+}
+
+// member fetches in the background, like a consumer's fetch loop, so the
+// rebalances it takes part in can complete. It records the partitions it
+// was assigned and revoked.
+type member struct {
+	Subscriber
+	mu       sync.Mutex
+	owned    []TopicPartition
+	revoked  [][]TopicPartition
+	messages []Message
+	done     chan struct{}
+}
+
+func join(t *testing.T, m *Memory, cooperative bool) *member {
+	t.Helper()
+	mb := &member{done: make(chan struct{})}
+	s, err := m.Subscribe(SubscriberConfig{
+		Topics:      []string{"orders"},
+		GroupID:     "g",
+		Cooperative: cooperative,
+		OnAssigned: func(ctx context.Context, partitions []TopicPartition) {
+			mb.mu.Lock()
+			defer mb.mu.Unlock()
+			mb.owned = append(mb.owned, partitions...)
+		},
+		OnRevoked: func(ctx context.Context, partitions []TopicPartition) {
+			mb.mu.Lock()
+			defer mb.mu.Unlock()
+			mb.revoked = append(mb.revoked, partitions)
+			mb.owned = slices.DeleteFunc(mb.owned, func(tp TopicPartition) bool { return slices.Contains(partitions, tp) })
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb.Subscriber = s
+	go func() {
+		defer close(mb.done)
+		for {
+			msg, err := s.Fetch(context.Background())
+			if err != nil {
+				return
+			}
+			mb.mu.Lock()
+			mb.messages = append(mb.messages, msg)
+			mb.mu.Unlock()
+			s.Commit(context.Background(), msg)
+		}
+	}()
+	t.Cleanup(func() {
+		s.Close()
+		<-mb.done
+	})
+	return mb
+}
+
+func (mb *member) state() (owned []TopicPartition, revoked [][]TopicPartition, messages int) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return slices.Clone(mb.owned), slices.Clone(mb.revoked), len(mb.messages)
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryRebalance(t *testing.T) {
+	for _, cooperative := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cooperative=%v", cooperative), func(t *testing.T) {
+			m := NewMemory(4)
+			m.CreateTopic("orders", 4)
+			first := join(t, m, cooperative)
+			waitFor(t, "the first member to own every partition", func() bool {
+				owned, _, _ := first.state()
+				return len(owned) == 4
+			})
+
+			second := join(t, m, cooperative)
+			waitFor(t, "the partitions to be split", func() bool {
+				a, _, _ := first.state()
+				b, _, _ := second.state()
+				return len(a) == 2 && len(b) == 2
+			})
+			a, revoked, _ := first.state()
+			b, _, _ := second.state()
+			if slices.ContainsFunc(a, func(tp TopicPartition) bool { return slices.Contains(b, tp) }) {
+				t.Errorf("both members own a partition: %v and %v", a, b)
+			}
+			// An eager member gives up everything and gets half of it back,
+			// a cooperative one gives up only what moves.
+			want := 4
+			if cooperative {
+				want = 2
+			}
+			if len(revoked) != 1 || len(revoked[0]) != want {
+				t.Errorf("first member had %v revoked, want one revocation of %d partitions", revoked, want)
+			}
+
+			// Messages published after the rebalance go to their partition's
+			// owner, each exactly once.
+			publish(t, m, "orders", "a", "b", "c", "d", "e", "f", "g", "h")
+			waitFor(t, "every message to be fetched", func() bool {
+				_, _, n1 := first.state()
+				_, _, n2 := second.state()
+				return n1+n2 == 8
+			})
+
+			// When a member leaves its partitions move to the other one,
+			// which continues at the committed offsets.
+			second.Close()
+			waitFor(t, "the first member to own every partition again", func() bool {
+				owned, _, _ := first.state()
+				return len(owned) == 4
+			})
+			publish(t, m, "orders", "i")
+			waitFor(t, "the new message to be fetched", func() bool {
+				_, _, n := first.state()
+				_, _, n2 := second.state()
+				return n+n2 == 9
+			})
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// Broker wraps b so its publishers and subscribers publish, commit and
+// fetch under inj's faults. Reads and pings are not faulted.
+func Broker(b broker.Broker, inj *Injector) broker.Broker {
+	if inj == nil {
+		return b
+	}
+	return &faultyBroker{Broker: b, inj: inj}
+}
+
+type faultyBroker struct {
+	broker.Broker
+	inj *Injector
+}
+
+func (b *faultyBroker) Publisher(cfg broker.PublisherConfig) (broker.Publisher, error) {
+	p, err := b.Broker.Publisher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tx, ok := p.(broker.TxPublisher); ok {
+		return &txPublisher{publisher: publisher{Publisher: p, inj: b.inj}, tx: tx}, nil
+	}
+	return &publisher{Publisher: p, inj: b.inj}, nil
+}
+
+func (b *faultyBroker) Subscribe(cfg broker.SubscriberConfig) (broker.Subscriber, error) {
+	s, err := b.Broker.Subscribe(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &subscriber{Subscriber: s, inj: b.inj}, nil
+}
+
+type publisher struct {
+	broker.Publisher
+	inj *Injector
+}
+
+func (p *publisher) Publish(ctx context.Context, msgs ...broker.Message) error {
+	return p.inj.do(ctx, OpKafkaPublish, func() error {
+		return p.Publisher.Publish(ctx, msgs...)
+	})
+}
+
+type txPublisher struct {
+	publisher
+	tx broker.TxPublisher
+}
+
+func (p *txPublisher) BeginTx(ctx context.Context) error {
+	return p.tx.BeginTx(ctx)
+}
+
+func (p *txPublisher) CommitTx(ctx context.Context) error {
+	return p.inj.do(ctx, OpKafkaCommitTx, func() error {
+		return p.tx.CommitTx(ctx)
+	})
+}
+
+func (p *txPublisher) AbortTx(ctx context.Context) error {
+	return p.tx.AbortTx(ctx)
+}
+
+type subscriber struct {
+	broker.Subscriber
+	inj *Injector
+}
+
+// Fetch loses a dropped message by seeking its partition back to it, so it
+// is fetched again like after a crash before it was processed.
+func (s *subscriber) Fetch(ctx context.Context) (broker.Message, error) {
+	var msg broker.Message
+	err := s.inj.do(ctx, OpKafkaFetch, func() (err error) {
+		msg, err = s.Subscriber.Fetch(ctx)
+		return err
+	})
+	if errors.Is(err, ErrInjected) && msg.Topic != "" {
+		tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+		if seekErr := s.Subscriber.Seek(ctx, tp, msg.Offset); seekErr != nil {
+			return broker.Message{}, errors.Join(err, seekErr)
+		}
+	}
+	if err != nil {
+		return broker.Message{}, err
+	}
+	return msg, nil
+}
+
+func (s *subscriber) Commit(ctx context.Context, msgs ...broker.Message) error {
+	return s.inj.do(ctx, OpKafkaCommit, func() error {
+		return s.Subscriber.Commit(ctx, msgs...)
+	})
+}
@@ -0,0 +1,252 @@
+// Package chaos injects faults into the services' dependencies, so the
+// delivery guarantees of the outbox and the consumer's deduplication can be
+// checked under failures instead of assumed. It wraps the database pool,
+// the Kafka broker and the schema registry's HTTP transport; each operation
+// they perform may fail, be delayed, have its acknowledgement lost or be
+// repeated, as configured by Rules.
+//
+// Faults are never injected unless rules are configured, see ParseRules.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/logging"
+)
+
+var logger = logging.Logger("chaos")
+
+// ErrInjected is wrapped by every error returned for an injected fault.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// Operations faults can be injected into.
+const (
+	OpDBBegin       = "db.begin"
+	OpDBExec        = "db.exec"
+	OpDBQuery       = "db.query"
+	OpDBCommit      = "db.commit"
+	OpKafkaPublish  = "kafka.publish"
+	OpKafkaCommitTx = "kafka.commit_tx" // transactional publishers only
+	OpKafkaFetch    = "kafka.fetch"
+	OpKafkaCommit   = "kafka.commit" // consumer group offsets
+	OpRegistry      = "registry"     // any schema registry request
+)
+
+var operations = []string{
+	OpDBBegin, OpDBExec, OpDBQuery, OpDBCommit,
+	OpKafkaPublish, OpKafkaCommitTx, OpKafkaFetch, OpKafkaCommit,
+	OpRegistry,
+}
+
+// Actions a rule can take.
+const (
+	// ActionError fails the operation without performing it.
+	ActionError = "error"
+	// ActionDelay performs the operation after Rule.Delay.
+	ActionDelay = "delay"
+	// ActionDrop performs the operation and then reports it as failed, like
+	// an acknowledgement lost on the way back, so the caller retries
+	// something that already happened. A dropped fetch loses the message
+	// and fetches it again, as after a consumer crash. Operations without
+	// effects, like beginning a transaction, simply fail.
+	ActionDrop = "drop"
+	// ActionDuplicate performs the operation twice, only kafka.publish.
+	ActionDuplicate = "duplicate"
+)
+
+// Rule injects Action into a fraction Probability of the operations
+// matching Op.
+type Rule struct {
+	Op          string // an operation, or a prefix like "db.*"
+	Action      string
+	Delay       time.Duration // for ActionDelay
+	Probability float64
+}
+
+func (r Rule) matches(op string) bool {
+	if prefix, ok := strings.CutSuffix(r.Op, "*"); ok {
+		return strings.HasPrefix(op, prefix)
+	}
+	return r.Op == op
+}
+
+func (r Rule) String() string {
+	s := r.Op + "=" + r.Action
+	if r.Action == ActionDelay {
+		s += ":" + r.Delay.String()
+	}
+	return s + "@" + strconv.FormatFloat(r.Probability, 'g', -1, 64)
+}
+
+type Config struct {
+	Rules []Rule
+	// Seed makes the sequence of fault decisions repeatable; zero picks a
+	// random seed, which is logged.
+	Seed uint64
+}
+
+// Enabled reports whether any fault is configured.
+func (c Config) Enabled() bool {
+	return len(c.Rules) > 0
+}
+
+// ParseRules parses comma separated op=action[:delay][@probability] rules,
+// e.g. "kafka.publish=duplicate@0.1,db.*=delay:200ms@0.05,registry=error".
+// The probability defaults to 1. An operation gets the fault of the first
+// rule that matches and fires.
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("fault %q: %w", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(entry string) (Rule, error) {
+	op, action, ok := strings.Cut(entry, "=")
+	op, action = strings.TrimSpace(op), strings.TrimSpace(action)
+	if !ok || op == "" {
+		return Rule{}, fmt.Errorf("must be op=action[:delay][@probability]")
+	}
+	rule := Rule{Op: op, Probability: 1}
+	action, probability, ok := strings.Cut(action, "@")
+	if ok {
+		p, err := strconv.ParseFloat(probability, 64)
+		if err != nil || p <= 0 || p > 1 {
+			return Rule{}, fmt.Errorf("probability must be in (0, 1]")
+		}
+		rule.Probability = p
+	}
+	action, delay, ok := strings.Cut(action, ":")
+	if ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d <= 0 {
+			return Rule{}, fmt.Errorf("delay must be a positive duration")
+		}
+		rule.Delay = d
+	}
+	rule.Action = action
+	if rule.Delay > 0 && rule.Action != ActionDelay {
+		return Rule{}, fmt.Errorf("only %s takes a duration", ActionDelay)
+	}
+
+	switch rule.Action {
+	case ActionError, ActionDrop:
+	case ActionDelay:
+		if rule.Delay == 0 {
+			return Rule{}, fmt.Errorf("delay needs a duration, e.g. delay:100ms")
+		}
+	case ActionDuplicate:
+		if rule.Op != OpKafkaPublish {
+			return Rule{}, fmt.Errorf("only %s can be duplicated", OpKafkaPublish)
+		}
+	default:
+		return Rule{}, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	for _, known := range operations {
+		if rule.matches(known) {
+			return rule, nil
+		}
+	}
+	return Rule{}, fmt.Errorf("unknown operation %q, one of %s", rule.Op, strings.Join(operations, ", "))
+}
+
+// Injector decides which operations fail. A nil *Injector injects nothing,
+// and the wrappers return what they wrap unchanged.
+type Injector struct {
+	rules []Rule
+
+	mu  sync.Mutex // guards rng
+	rng *rand.Rand
+}
+
+// New returns an injector for cfg, or nil when no faults are configured.
+func New(cfg Config) *Injector {
+	if !cfg.Enabled() {
+		return nil
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	rules := make([]string, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		rules[i] = r.String()
+	}
+	logger.Warn("Fault injection enabled", "rules", strings.Join(rules, ","), "seed", seed)
+	return &Injector{
+		rules: cfg.Rules,
+		rng:   rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// fault returns the rule that fires for op, if any.
+func (i *Injector) fault(op string) (Rule, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, r := range i.rules {
+		if r.matches(op) && i.rng.Float64() < r.Probability {
+			logger.Warn("Injecting fault", "op", op, "action", r.Action)
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// do performs an operation under the fault that fires for op.
+func (i *Injector) do(ctx context.Context, op string, perform func() error) error {
+	rule, ok := i.fault(op)
+	if !ok {
+		return perform()
+	}
+	switch rule.Action {
+	case ActionError:
+		return injected(op, rule)
+	case ActionDelay:
+		if err := sleep(ctx, rule.Delay); err != nil {
+			return err
+		}
+		return perform()
+	case ActionDrop:
+		if err := perform(); err != nil {
+			return err
+		}
+		return injected(op, rule)
+	case ActionDuplicate:
+		if err := perform(); err != nil {
+			return err
+		}
+		return perform()
+	}
+	return perform()
+}
+
+func injected(op string, rule Rule) error {
+	return fmt.Errorf("%w: %s %s", ErrInjected, rule.Action, op)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
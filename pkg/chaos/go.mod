@@ -0,0 +1,22 @@
+module github.com/dzon2000/eda/pkg/chaos
+
+go 1.25.5
+
+require (
+	github.com/dzon2000/eda/pkg/broker v0.0.0
+	github.com/dzon2000/eda/pkg/logging v0.0.0
+)
+
+require (
+	github.com/klauspost/compress v1.19.2 // indirect
+	github.com/pierrec/lz4/v4 v4.1.26 // indirect
+	github.com/twmb/franz-go v1.21.7 // indirect
+	github.com/twmb/franz-go/pkg/kmsg v1.13.1 // indirect
+	go.opentelemetry.io/otel v1.38.0 // indirect
+	go.opentelemetry.io/otel/trace v1.38.0 // indirect
+)
+
+replace (
+	github.com/dzon2000/eda/pkg/broker => ../broker
+	github.com/dzon2000/eda/pkg/logging => ../logging
+)
@@ -0,0 +1,38 @@
+package chaos
+
+import (
+	"net/http"
+)
+
+// Transport wraps rt, http.DefaultTransport when nil, so the schema
+// registry requests sent through it fail under inj's faults. A dropped
+// request's response is discarded.
+func Transport(rt http.RoundTripper, inj *Injector) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if inj == nil {
+		return rt
+	}
+	return &transport{base: rt, inj: inj}
+}
+
+type transport struct {
+	base http.RoundTripper
+	inj  *Injector
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := t.inj.do(req.Context(), OpRegistry, func() (err error) {
+		resp, err = t.base.RoundTrip(req)
+		return err
+	})
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,169 @@
+package chaos
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// OpenDB opens a pool like sql.Open whose connections begin, execute,
+// query and commit under inj's faults. Pings and explicitly prepared
+// statements are not faulted.
+func OpenDB(driverName, dsn string, inj *Injector) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil || inj == nil {
+		return db, err
+	}
+	// sql.Open has not connected yet, the pool is only needed for its driver.
+	d := db.Driver()
+	db.Close()
+
+	c := &connector{driver: d, dsn: dsn, inj: inj}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if c.base, err = dc.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(c), nil
+}
+
+type connector struct {
+	driver driver.Driver
+	base   driver.Connector // nil when the driver has no connectors
+	dsn    string
+	inj    *Injector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	var dc driver.Conn
+	var err error
+	if c.base != nil {
+		dc, err = c.base.Connect(ctx)
+	} else {
+		dc, err = c.driver.Open(c.dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: dc, inj: c.inj}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn forwards the optional interfaces of the driver's connection that
+// database/sql looks for, so wrapping does not change how it is used.
+type conn struct {
+	driver.Conn
+	inj *Injector
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	err := c.inj.do(ctx, OpDBBegin, func() (err error) {
+		if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+			tx, err = b.BeginTx(ctx, opts)
+		} else {
+			tx, err = c.Conn.Begin()
+		}
+		return err
+	})
+	if err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return nil, err
+	}
+	return &transaction{Tx: tx, inj: c.inj}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var res driver.Result
+	err := c.inj.do(ctx, OpDBExec, func() (err error) {
+		res, err = execer.ExecContext(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	var rows driver.Rows
+	err := c.inj.do(ctx, OpDBQuery, func() (err error) {
+		rows, err = queryer.QueryContext(ctx, query, args)
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+type transaction struct {
+	driver.Tx
+	inj *Injector
+}
+
+// Commit rolls the transaction back when the commit fails without being
+// performed, database/sql returns the connection to the pool either way.
+func (t *transaction) Commit() error {
+	committed := false
+	err := t.inj.do(context.Background(), OpDBCommit, func() error {
+		committed = true
+		return t.Tx.Commit()
+	})
+	if !committed && errors.Is(err, ErrInjected) {
+		t.Tx.Rollback()
+	}
+	return err
+}
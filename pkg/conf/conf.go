@@ -0,0 +1,305 @@
+// Package conf loads the services' configuration from, in increasing
+// precedence, built-in defaults, a YAML or TOML file, environment variables
+// and command-line flags.
+//
+// Every setting has one key in environment variable form, e.g.
+// KAFKA_BROKERS. A file sets it as nested tables joined by underscores
+// (kafka: {brokers: ...}) or as the flat key, and a flag as --kafka-brokers.
+// The file is named by --config or CONFIG_FILE.
+//
+// Values that do not parse are errors rather than falling back to the
+// default, and so are keys in the file or flags that no setting reads.
+// Secrets may be read from the file named by KEY_FILE instead, which keeps
+// them out of the environment, and are redacted by --print-config.
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPrinted is returned by Finish after --print-config printed the
+// configuration; like flag.ErrHelp it asks the caller to exit successfully.
+var ErrPrinted = errors.New("configuration printed")
+
+// Redacted replaces secret values printed by --print-config.
+const Redacted = "[REDACTED]"
+
+// Value sources, in increasing precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Loader reads settings one key at a time and collects every error, so a
+// misconfigured service reports all of its mistakes at once.
+type Loader struct {
+	file  map[string]string
+	flags map[string]string
+	env   func(string) (string, bool)
+	print bool
+	out   io.Writer
+
+	read []setting
+	errs []error
+}
+
+type setting struct {
+	key    string
+	value  string
+	source string
+	secret bool
+}
+
+// New parses the command-line arguments, excluding the program name, and
+// reads the configuration file they or CONFIG_FILE name.
+func New(args []string) (*Loader, error) {
+	l := &Loader{
+		flags: make(map[string]string),
+		env:   os.LookupEnv,
+		out:   os.Stdout,
+	}
+	if err := l.parseFlags(args); err != nil {
+		return nil, err
+	}
+	path, ok := l.flags["CONFIG"]
+	delete(l.flags, "CONFIG")
+	if !ok {
+		path, ok = l.env("CONFIG_FILE")
+	}
+	if ok && path != "" {
+		file, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+// SetOutput sets where --print-config writes, os.Stdout by default.
+func (l *Loader) SetOutput(w io.Writer) {
+	l.out = w
+}
+
+// parseFlags accepts --key=value, --key value and the same with a single
+// dash. --print-config takes no value. A value starting with a dash, e.g. a
+// negative number, must use the --key=value form, as it would otherwise be
+// taken for the next flag.
+func (l *Loader) parseFlags(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, ok := strings.CutPrefix(arg, "-")
+		if !ok || name == "" || name == "-" {
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+		name = strings.TrimPrefix(name, "-")
+		name, value, hasValue := strings.Cut(name, "=")
+		if name == "print-config" {
+			if hasValue {
+				return fmt.Errorf("flag --print-config takes no value")
+			}
+			l.print = true
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+				return fmt.Errorf("flag --%s needs a value, use --%s=value if it starts with a dash", name, name)
+			}
+			i++
+			value = args[i]
+		}
+		l.flags[flagKey(name)] = value
+	}
+	return nil
+}
+
+// flagKey turns kafka-brokers into KAFKA_BROKERS.
+func flagKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// lookup returns the value of key with the highest precedence. Every key
+// looked up counts as known, so it is not reported as unused.
+func (l *Loader) lookup(key string) (string, string, bool) {
+	if value, ok := l.flags[key]; ok {
+		return value, SourceFlag, true
+	}
+	if value, ok := l.env(key); ok && value != "" {
+		return value, SourceEnv, true
+	}
+	if value, ok := l.file[key]; ok {
+		return value, SourceFile, true
+	}
+	return "", SourceDefault, false
+}
+
+func (l *Loader) record(key, value, source string, secret bool) {
+	l.read = append(l.read, setting{key: key, value: value, source: source, secret: secret})
+}
+
+func (l *Loader) fail(key, source, format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf("%s (from %s): %s", key, source, fmt.Sprintf(format, args...)))
+}
+
+// String returns the value of key, or def when it is not set.
+func (l *Loader) String(key, def string) string {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		value = def
+	}
+	l.record(key, value, source, false)
+	return value
+}
+
+// Strings splits a comma separated value, dropping empty elements.
+func (l *Loader) Strings(key string, def []string) []string {
+	value := l.String(key, strings.Join(def, ","))
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (l *Loader) Int(key string, def int) int {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, strconv.Itoa(def), source, false)
+		return def
+	}
+	l.record(key, value, source, false)
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(key, source, "invalid integer %q", value)
+		return def
+	}
+	return n
+}
+
+func (l *Loader) Duration(key string, def time.Duration) time.Duration {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, def.String(), source, false)
+		return def
+	}
+	l.record(key, value, source, false)
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(key, source, "invalid duration %q", value)
+		return def
+	}
+	return d
+}
+
+func (l *Loader) Bool(key string, def bool) bool {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		l.record(key, strconv.FormatBool(def), source, false)
+		return def
+	}
+	l.record(key, value, source, false)
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		l.fail(key, source, "invalid boolean %q", value)
+		return def
+	}
+	return b
+}
+
+// Secret returns the value of key, or the contents of the file named by
+// KEY_FILE without its trailing newline. Setting both is an error.
+func (l *Loader) Secret(key, def string) string {
+	fileKey := key + "_FILE"
+	value, source, ok := l.lookup(key)
+	path, pathSource, fromFile := l.lookup(fileKey)
+	if fromFile {
+		l.record(fileKey, path, pathSource, false)
+	}
+	switch {
+	case ok && fromFile:
+		l.fail(key, source, "set together with %s (from %s)", fileKey, pathSource)
+	case fromFile:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			l.fail(fileKey, pathSource, "%v", err)
+			break
+		}
+		value, source = strings.TrimRight(string(data), "\r\n"), SourceFile+" "+path
+	case !ok:
+		value = def
+	}
+	l.record(key, value, source, true)
+	return value
+}
+
+// Check records err for key, for settings validated by the caller's own
+// parser, e.g. a log level.
+func (l *Loader) Check(key string, err error) {
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %w", key, err))
+	}
+}
+
+// Finish reports every parse error and every key set in the file or as a
+// flag that no setting read, then validates the loaded configuration. With
+// --print-config and a valid configuration, it prints the configuration
+// and returns ErrPrinted.
+func (l *Loader) Finish(validate func() error) error {
+	known := make(map[string]bool, len(l.read))
+	for _, s := range l.read {
+		known[s.key] = true
+	}
+	errs := slices.Clone(l.errs)
+	for _, source := range []struct {
+		name   string
+		values map[string]string
+	}{{SourceFile, l.file}, {SourceFlag, l.flags}} {
+		var unknown []string
+		for key := range source.values {
+			if !known[key] {
+				unknown = append(unknown, key)
+			}
+		}
+		slices.Sort(unknown)
+		for _, key := range unknown {
+			errs = append(errs, fmt.Errorf("%s (from %s): unknown setting", key, source.name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	if err := validate(); err != nil {
+		return err
+	}
+	if l.print {
+		l.Print(l.out)
+		return ErrPrinted
+	}
+	return nil
+}
+
+// Print writes every setting read, as KEY=value with its source, secrets
+// redacted.
+func (l *Loader) Print(w io.Writer) {
+	settings := slices.Clone(l.read)
+	slices.SortStableFunc(settings, func(a, b setting) int { return strings.Compare(a.key, b.key) })
+	settings = slices.CompactFunc(settings, func(a, b setting) bool { return a.key == b.key })
+	for _, s := range settings {
+		value := s.value
+		if s.secret && value != "" {
+			value = Redacted
+		}
+		fmt.Fprintf(w, "%s=%s\t# %s\n", s.key, value, s.source)
+	}
+}
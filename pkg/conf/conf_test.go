@@ -0,0 +1,266 @@
+package conf
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load returns a Loader for args that reads env instead of the environment.
+func load(t *testing.T, args []string, env map[string]string) *Loader {
+	t.Helper()
+	l, err := New(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.env = func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	return l
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args  []string
+		flags map[string]string
+		print bool
+		err   string
+	}{
+		{args: nil, flags: map[string]string{}},
+		{args: []string{"--kafka-brokers=a:9092,b:9092"}, flags: map[string]string{"KAFKA_BROKERS": "a:9092,b:9092"}},
+		{args: []string{"--kafka-brokers", "a:9092"}, flags: map[string]string{"KAFKA_BROKERS": "a:9092"}},
+		{args: []string{"-log-level", "debug", "-port=8080"}, flags: map[string]string{"LOG_LEVEL": "debug", "PORT": "8080"}},
+		{args: []string{"--chaos-seed=-1"}, flags: map[string]string{"CHAOS_SEED": "-1"}},
+		{args: []string{"-offset=--5"}, flags: map[string]string{"OFFSET": "--5"}},
+		{args: []string{"--query=a=b"}, flags: map[string]string{"QUERY": "a=b"}},
+		{args: []string{"--empty="}, flags: map[string]string{"EMPTY": ""}},
+		{args: []string{"--print-config", "--port", "1"}, flags: map[string]string{"PORT": "1"}, print: true},
+		{args: []string{"--chaos-seed", "-1"}, err: "use --chaos-seed=value"},
+		{args: []string{"--port"}, err: "flag --port needs a value"},
+		{args: []string{"--print-config=true"}, err: "takes no value"},
+		{args: []string{"port"}, err: `unexpected argument "port"`},
+		{args: []string{"-"}, err: `unexpected argument "-"`},
+		{args: []string{"--"}, err: `unexpected argument "--"`},
+	}
+	for _, tt := range tests {
+		l := &Loader{flags: make(map[string]string)}
+		err := l.parseFlags(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseFlags(%q) = %v, want an error containing %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFlags(%q) = %v", tt.args, err)
+			continue
+		}
+		if !maps.Equal(l.flags, tt.flags) || l.print != tt.print {
+			t.Errorf("parseFlags(%q) = %v print=%v, want %v print=%v", tt.args, l.flags, l.print, tt.flags, tt.print)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "kafka:\n  brokers: file:9092\n")
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		value  string
+		source string
+	}{
+		{"default", nil, nil, "default:9092", SourceDefault},
+		{"file", []string{"--config", file}, nil, "file:9092", SourceFile},
+		{"env over file", []string{"--config", file}, map[string]string{"KAFKA_BROKERS": "env:9092"}, "env:9092", SourceEnv},
+		{"empty env is unset", []string{"--config", file}, map[string]string{"KAFKA_BROKERS": ""}, "file:9092", SourceFile},
+		{"flag over env", []string{"--config", file, "--kafka-brokers=flag:9092"}, map[string]string{"KAFKA_BROKERS": "env:9092"}, "flag:9092", SourceFlag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := load(t, tt.args, tt.env)
+			if got := l.String("KAFKA_BROKERS", "default:9092"); got != tt.value {
+				t.Errorf("KAFKA_BROKERS = %q, want %q", got, tt.value)
+			}
+			var out bytes.Buffer
+			l.Print(&out)
+			if want := "KAFKA_BROKERS=" + tt.value + "\t# " + tt.source + "\n"; out.String() != want {
+				t.Errorf("printed %q, want %q", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"config.yaml", "kafka:\n  brokers: [a:9092, b:9092]\nprocessing_timeout: 5s\nretries: 3\n", ""},
+		{"config.yml", "KAFKA_BROKERS: a:9092,b:9092\nprocessing:\n  timeout: 5s\nretries: 3\n", ""},
+		{"config.toml", "retries = 3\nprocessing_timeout = \"5s\"\n[kafka]\nbrokers = [\"a:9092\", \"b:9092\"]\n", ""},
+		{"config.json", "{}", "unsupported format"},
+		{"config.yaml", "kafka: [", "failed to parse"},
+		{"config.yaml", "kafka:\n  brokers: a\nkafka_brokers: b\n", "KAFKA_BROKERS is set more than once"},
+		{"config.yaml", "kafka:\n  brokers: [\"a,b\"]\n", "contains a comma"},
+	}
+	for _, tt := range tests {
+		path := writeFile(t, tt.name, tt.content)
+		l, err := New([]string{"--config=" + path})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %q: New = %v, want an error containing %q", tt.name, tt.content, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: New = %v", tt.name, err)
+			continue
+		}
+		l.env = func(string) (string, bool) { return "", false }
+		brokers := l.Strings("KAFKA_BROKERS", nil)
+		timeout := l.Duration("PROCESSING_TIMEOUT", time.Second)
+		retries := l.Int("RETRIES", 0)
+		if strings.Join(brokers, ",") != "a:9092,b:9092" || timeout != 5*time.Second || retries != 3 {
+			t.Errorf("%s: brokers=%v timeout=%v retries=%d, want [a:9092 b:9092] 5s 3", tt.name, brokers, timeout, retries)
+		}
+		if err := l.Finish(func() error { return nil }); err != nil {
+			t.Errorf("%s: Finish = %v", tt.name, err)
+		}
+	}
+}
+
+func TestInvalidValues(t *testing.T) {
+	env := map[string]string{
+		"PORT":    "eighty",
+		"TIMEOUT": "5",
+		"DEBUG":   "yes please",
+		"BROKERS": " a , ,b ",
+	}
+	l := load(t, []string{"--retries=-1"}, env)
+	if got := l.Int("PORT", 8080); got != 8080 {
+		t.Errorf("PORT = %d, want the default 8080", got)
+	}
+	if got := l.Duration("TIMEOUT", time.Second); got != time.Second {
+		t.Errorf("TIMEOUT = %v, want the default 1s", got)
+	}
+	if got := l.Bool("DEBUG", true); !got {
+		t.Errorf("DEBUG = %v, want the default true", got)
+	}
+	if got := l.Int("RETRIES", 3); got != -1 {
+		t.Errorf("RETRIES = %d, want -1", got)
+	}
+	if got := l.Strings("BROKERS", nil); strings.Join(got, "|") != "a|b" {
+		t.Errorf("BROKERS = %q, want [a b]", got)
+	}
+	l.Check("LOG_LEVEL", errors.New("unknown level"))
+
+	err := l.Finish(func() error {
+		t.Error("validate called despite parse errors")
+		return nil
+	})
+	for _, want := range []string{
+		`PORT (from env): invalid integer "eighty"`,
+		`TIMEOUT (from env): invalid duration "5"`,
+		`DEBUG (from env): invalid boolean "yes please"`,
+		`LOG_LEVEL: unknown level`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Finish = %v, want an error containing %q", err, want)
+		}
+	}
+}
+
+func TestSecret(t *testing.T) {
+	secretFile := writeFile(t, "password", "s3cret\n")
+	tests := []struct {
+		name   string
+		env    map[string]string
+		value  string
+		source string
+		err    string
+	}{
+		{"default", nil, "", SourceDefault, ""},
+		{"env", map[string]string{"DB_PASSWORD": "env-secret"}, "env-secret", SourceEnv, ""},
+		{"file", map[string]string{"DB_PASSWORD_FILE": secretFile}, "s3cret", SourceFile + " " + secretFile, ""},
+		{"both", map[string]string{"DB_PASSWORD": "env-secret", "DB_PASSWORD_FILE": secretFile}, "", "", "set together with DB_PASSWORD_FILE"},
+		{"missing file", map[string]string{"DB_PASSWORD_FILE": secretFile + ".missing"}, "", "", "DB_PASSWORD_FILE (from env)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := load(t, []string{"--print-config"}, tt.env)
+			var out bytes.Buffer
+			l.SetOutput(&out)
+			got := l.Secret("DB_PASSWORD", "")
+			err := l.Finish(func() error { return nil })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Finish = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrPrinted) {
+				t.Fatalf("Finish = %v, want ErrPrinted", err)
+			}
+			if got != tt.value {
+				t.Errorf("DB_PASSWORD = %q, want %q", got, tt.value)
+			}
+			if tt.value != "" && strings.Contains(out.String(), tt.value) {
+				t.Errorf("printed the secret: %q", out.String())
+			}
+			printed := "DB_PASSWORD=\t# " + tt.source + "\n"
+			if tt.value != "" {
+				printed = "DB_PASSWORD=" + Redacted + "\t# " + tt.source + "\n"
+			}
+			if !strings.Contains(out.String(), printed) {
+				t.Errorf("printed %q, want it to contain %q", out.String(), printed)
+			}
+		})
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	file := writeFile(t, "config.toml", "port = 8080\ntypo_in_file = 1\n")
+	l := load(t, []string{"--config", file, "--typo-in-flag=1", "--port=9090"}, map[string]string{"UNREAD_ENV": "1"})
+	l.Int("PORT", 0)
+	err := l.Finish(func() error { return nil })
+	for _, want := range []string{"TYPO_IN_FILE (from file): unknown setting", "TYPO_IN_FLAG (from flag): unknown setting"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Finish = %v, want an error containing %q", err, want)
+		}
+	}
+	// The environment holds much more than the service's settings.
+	if err != nil && strings.Contains(err.Error(), "UNREAD_ENV") {
+		t.Errorf("Finish = %v, want unread environment variables ignored", err)
+	}
+}
+
+func TestFinishValidates(t *testing.T) {
+	invalid := errors.New("invalid")
+	l := load(t, []string{"--print-config"}, nil)
+	var out bytes.Buffer
+	l.SetOutput(&out)
+	l.String("NAME", "orders")
+	if err := l.Finish(func() error { return invalid }); !errors.Is(err, invalid) {
+		t.Errorf("Finish = %v, want the validation error", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("printed an invalid configuration: %q", out.String())
+	}
+}
@@ -0,0 +1,8 @@
+module github.com/dzon2000/eda/pkg/conf
+
+go 1.25.5
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
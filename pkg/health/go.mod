@@ -0,0 +1,3 @@
+module github.com/dzon2000/eda/pkg/health
+
+go 1.25.5
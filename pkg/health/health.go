@@ -0,0 +1,147 @@
+// Package health serves the services' liveness and readiness probes. Each
+// dependency is checked separately and reported in a JSON breakdown, so an
+// operator can tell which one failed.
+//
+// Liveness checks detect a process that is up but wedged and must be
+// restarted. Readiness checks cover the dependencies the service needs to
+// do its work; every liveness check is a readiness check too.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check statuses.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check returns nil while the dependency it checks is healthy.
+type Check func(ctx context.Context) error
+
+type Checker struct {
+	timeout time.Duration // per check
+
+	mu        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Liveness registers a check that fails only when restarting the process
+// is the remedy.
+func (c *Checker) Liveness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, namedCheck{name, check})
+}
+
+// Readiness registers a dependency the service cannot work without.
+func (c *Checker) Readiness(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, namedCheck{name, check})
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Live runs the liveness checks.
+func (c *Checker) Live(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := c.liveness
+	c.mu.Unlock()
+	return c.run(ctx, checks)
+}
+
+// Ready runs the liveness and readiness checks.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append(append([]namedCheck(nil), c.liveness...), c.readiness...)
+	c.mu.Unlock()
+	return c.run(ctx, checks)
+}
+
+// run checks concurrently, each bounded by the checker's timeout, so one
+// hanging dependency cannot make the probe itself time out.
+func (c *Checker) run(ctx context.Context, checks []namedCheck) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, nc := range checks {
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			start := time.Now()
+			err := nc.check(ctx)
+			result := Result{
+				Status:     StatusOK,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status, result.Error = StatusFail, err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		})
+	}
+	wg.Wait()
+	return report
+}
+
+// LiveHandler serves Live, with 503 when a check fails.
+func (c *Checker) LiveHandler() http.Handler {
+	return reportHandler(c.Live)
+}
+
+// ReadyHandler serves Ready, with 503 when a check fails.
+func (c *Checker) ReadyHandler() http.Handler {
+	return reportHandler(c.Ready)
+}
+
+// Register serves the probes at GET /health/live and GET /health/ready.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.Handle("GET /health/live", c.LiveHandler())
+	mux.Handle("GET /health/ready", c.ReadyHandler())
+}
+
+func reportHandler(run func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
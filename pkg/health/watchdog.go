@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Watchdog detects work that never finishes, such as a batch stuck on a
+// connection without a deadline. Work is tracked by key from Begin to End;
+// an idle service is not stalled. The zero value is ready to use.
+type Watchdog struct {
+	mu      sync.Mutex
+	running map[any]time.Time
+}
+
+// Begin marks the work identified by key as started.
+func (w *Watchdog) Begin(key any) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running == nil {
+		w.running = make(map[any]time.Time)
+	}
+	w.running[key] = time.Now()
+}
+
+// End marks the work as finished. Ending work that is not running is a
+// no-op, so work can be ended early, e.g. when it starts waiting on purpose.
+func (w *Watchdog) End(key any) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.running, key)
+}
+
+// Check fails while any work has been running for longer than limit.
+func (w *Watchdog) Check(limit time.Duration) Check {
+	return func(ctx context.Context) error {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		var oldest time.Time
+		for _, started := range w.running {
+			if oldest.IsZero() || started.Before(oldest) {
+				oldest = started
+			}
+		}
+		if running := time.Since(oldest); !oldest.IsZero() && running > limit {
+			return fmt.Errorf("work running for %s, limit %s", running.Round(time.Second), limit)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/dzon2000/eda/pkg/logging
+
+go 1.25.5
+
+require go.opentelemetry.io/otel/trace v1.38.0
+
+require go.opentelemetry.io/otel v1.38.0 // indirect
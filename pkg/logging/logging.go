@@ -0,0 +1,174 @@
+// Package logging provides the services' structured slog loggers: JSON or
+// text output, a level per named logger, trace correlation and redaction of
+// personal data.
+//
+// Packages create their logger once with Logger and may do so before Setup
+// runs; the configuration applied by Setup takes effect for every logger,
+// including slog's and the standard log package's default logger.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats selectable with Config.Format.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the value of redacted attributes.
+const Redacted = "[REDACTED]"
+
+type Config struct {
+	Format string
+	Level  slog.Level
+	// Levels overrides Level for named loggers, e.g. "dlq" or "consumer".
+	Levels map[string]slog.Level
+	// Redact lists attribute keys whose values are never written, matched
+	// case-insensitively, including keys of map values such as a decoded
+	// Avro payload.
+	Redact []string
+}
+
+func (c Config) Validate() error {
+	switch c.Format {
+	case FormatJSON, FormatText:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", c.Format)
+	}
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// ParseLevels parses per-logger levels written as "name=level,...".
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for entry := range strings.SplitSeq(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid log level override %q, want name=level", entry)
+		}
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// state is the configuration every logger consults on each record.
+type state struct {
+	config Config
+	root   slog.Handler
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	current.Store(newState(Config{Format: FormatText, Level: slog.LevelInfo}, os.Stderr))
+}
+
+// Setup applies cfg to every logger, writes to w and makes the unnamed
+// logger slog's default, which the standard log package writes through too.
+func Setup(cfg Config, w io.Writer) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	current.Store(newState(cfg, w))
+	slog.SetDefault(Logger(""))
+	return nil
+}
+
+func newState(cfg Config, w io.Writer) *state {
+	// The root handler lets everything through, the named loggers filter.
+	minLevel := cfg.Level
+	for _, level := range cfg.Levels {
+		minLevel = min(minLevel, level)
+	}
+	opts := &slog.HandlerOptions{
+		Level:       minLevel,
+		ReplaceAttr: redactor(cfg.Redact),
+	}
+	var root slog.Handler = slog.NewJSONHandler(w, opts)
+	if cfg.Format == FormatText {
+		root = slog.NewTextHandler(w, opts)
+	}
+	return &state{config: cfg, root: root}
+}
+
+func (s *state) level(name string) slog.Level {
+	if level, ok := s.config.Levels[name]; ok {
+		return level
+	}
+	return s.config.Level
+}
+
+// Logger returns the logger for name, which is written as the "logger"
+// attribute and selects the level override. An empty name is the default
+// logger.
+func Logger(name string) *slog.Logger {
+	return slog.New(&handler{name: name})
+}
+
+// handler resolves the current state on every call, so loggers created
+// before Setup follow it.
+type handler struct {
+	name string
+	with []func(slog.Handler) slog.Handler // WithAttrs and WithGroup calls, in order
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= current.Load().level(h.name)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	next := current.Load().root
+	if h.name != "" {
+		next = next.WithAttrs([]slog.Attr{slog.String("logger", h.name)})
+	}
+	for _, with := range h.with {
+		next = with(next)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &handler{name: h.name, with: append(slices.Clone(h.with), with)}
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"log/slog"
+	"strings"
+)
+
+func redactor(keys []string) func(groups []string, a slog.Attr) slog.Attr {
+	if len(keys) == 0 {
+		return nil
+	}
+	redact := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		redact[strings.ToLower(key)] = true
+	}
+	return func(_ []string, a slog.Attr) slog.Attr {
+		if redact[strings.ToLower(a.Key)] {
+			return slog.String(a.Key, Redacted)
+		}
+		if a.Value.Kind() == slog.KindAny {
+			if m, ok := a.Value.Any().(map[string]any); ok {
+				return slog.Any(a.Key, redactMap(m, redact))
+			}
+		}
+		return a
+	}
+}
+
+// redactMap returns a copy of m with the values of redacted keys replaced,
+// at any depth.
+func redactMap(m map[string]any, redact map[string]bool) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if redact[strings.ToLower(k)] {
+			out[k] = Redacted
+			continue
+		}
+		if nested, ok := v.(map[string]any); ok {
+			v = redactMap(nested, redact)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Check verifies that the database is at the latest migration and that
+// Postgres accepts every query, keyed by name: preparing a statement
+// resolves its tables, columns and parameter types without running it.
+// Run in CI against a freshly migrated database, it catches code that
+// drifted from the schema.
+func (m *Migrator) Check(ctx context.Context, queries map[string]string) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, state := range states {
+		switch {
+		case state.AppliedAt.IsZero():
+			errs = append(errs, fmt.Errorf("migration %04d_%s is not applied", state.Version, state.Name))
+		case state.Name == "":
+			errs = append(errs, fmt.Errorf("migration %04d is applied but unknown to this build", state.Version))
+		}
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, name := range slices.Sorted(maps.Keys(queries)) {
+		stmt, err := tx.PrepareContext(ctx, queries[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", name, err))
+			continue
+		}
+		stmt.Close()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Usage describes the arguments Run accepts.
+const Usage = `migrate up            apply pending migrations
+migrate down [steps]  revert the latest steps migrations, 1 by default
+migrate status        list migrations and when they were applied
+migrate check         verify the schema is current and matches the queries`
+
+// SplitArgs separates the migrate command's words from the configuration
+// flags that follow them, e.g. "down 2 --db-host x".
+func SplitArgs(args []string) (command, flags []string) {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return args[:i], args[i:]
+		}
+	}
+	return args, nil
+}
+
+// parseCommand returns the action of a migrate command and, for down, the
+// number of steps.
+func parseCommand(command []string) (action string, steps int, err error) {
+	if len(command) == 0 {
+		return "", 0, fmt.Errorf("missing migrate command\n%s", Usage)
+	}
+	switch action, rest := command[0], command[1:]; {
+	case action == "down" && len(rest) <= 1:
+		steps = 1
+		if len(rest) == 1 {
+			steps, err = strconv.Atoi(rest[0])
+			if err != nil || steps <= 0 {
+				return "", 0, fmt.Errorf("migrate down: steps must be a positive number, got %q", rest[0])
+			}
+		}
+		return action, steps, nil
+	case (action == "up" || action == "status" || action == "check") && len(rest) == 0:
+		return action, 0, nil
+	default:
+		return "", 0, fmt.Errorf("unknown migrate command %q\n%s", strings.Join(command, " "), Usage)
+	}
+}
+
+// Run executes a migrate command and reports the outcome to out. queries
+// are the statements check prepares.
+func Run(ctx context.Context, db *sql.DB, command []string, queries map[string]string, out io.Writer) error {
+	action, steps, err := parseCommand(command)
+	if err != nil {
+		return err
+	}
+	m, err := New(db)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintf(out, "schema is up to date at %04d\n", m.Latest())
+		}
+		return err
+	case "down":
+		reverted, err := m.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(out, "reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		states, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if !state.AppliedAt.IsZero() {
+				applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			name := state.Name
+			if name == "" {
+				name = "(unknown to this build)"
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", state.Version, name, applied)
+		}
+		return nil
+	default: // check
+		if err := m.Check(ctx, queries); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "schema is at %04d and accepts all %d queries\n", m.Latest(), len(queries))
+		return nil
+	}
+}
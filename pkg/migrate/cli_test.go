@@ -0,0 +1,61 @@
+package migrate
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args           []string
+		command, flags []string
+	}{
+		{nil, nil, nil},
+		{[]string{"up"}, []string{"up"}, nil},
+		{[]string{"down", "2", "--db-host", "x"}, []string{"down", "2"}, []string{"--db-host", "x"}},
+		{[]string{"--config=c.yaml", "status"}, []string{}, []string{"--config=c.yaml", "status"}},
+		{[]string{"check", "-print-config"}, []string{"check"}, []string{"-print-config"}},
+	}
+	for _, tt := range tests {
+		command, flags := SplitArgs(tt.args)
+		if !slices.Equal(command, tt.command) || !slices.Equal(flags, tt.flags) {
+			t.Errorf("SplitArgs(%q) = %q, %q, want %q, %q", tt.args, command, flags, tt.command, tt.flags)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		command []string
+		action  string
+		steps   int
+		err     string
+	}{
+		{command: []string{"up"}, action: "up"},
+		{command: []string{"down"}, action: "down", steps: 1},
+		{command: []string{"down", "3"}, action: "down", steps: 3},
+		{command: []string{"status"}, action: "status"},
+		{command: []string{"check"}, action: "check"},
+		{command: nil, err: "missing migrate command"},
+		{command: []string{"down", "0"}, err: `steps must be a positive number, got "0"`},
+		{command: []string{"down", "-1"}, err: "steps must be a positive number"},
+		{command: []string{"down", "all"}, err: "steps must be a positive number"},
+		{command: []string{"down", "1", "2"}, err: `unknown migrate command "down 1 2"`},
+		{command: []string{"up", "1"}, err: `unknown migrate command "up 1"`},
+		{command: []string{"status", "all"}, err: "unknown migrate command"},
+		{command: []string{"redo"}, err: `unknown migrate command "redo"`},
+	}
+	for _, tt := range tests {
+		action, steps, err := parseCommand(tt.command)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseCommand(%q) = %v, want an error containing %q", tt.command, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || action != tt.action || steps != tt.steps {
+			t.Errorf("parseCommand(%q) = %q, %d, %v, want %q, %d", tt.command, action, steps, err, tt.action, tt.steps)
+		}
+	}
+}
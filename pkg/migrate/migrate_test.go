@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	pair := func(base string) []string { return []string{base + ".up.sql", base + ".down.sql"} }
+	tests := []struct {
+		name     string
+		files    []string
+		versions []int
+		err      string
+	}{
+		{
+			name:     "sorted by version with gaps",
+			files:    slices.Concat(pair("0010_later"), pair("0002_orders"), pair("1_first_one"), []string{"README.md"}),
+			versions: []int{1, 2, 10},
+		},
+		{name: "empty", files: nil, versions: []int{}},
+		{name: "same version padded differently", files: []string{"0001_a.up.sql", "1_a.down.sql"}, versions: []int{1}},
+		{name: "missing down", files: []string{"0001_a.up.sql"}, err: "migration 0001_a needs both an up and a down file"},
+		{name: "missing up", files: []string{"0001_a.down.sql"}, err: "migration 0001_a needs both an up and a down file"},
+		{name: "duplicate version", files: slices.Concat(pair("0001_a"), pair("0001_b")), err: "migration 1 is named both a and b"},
+		{name: "no name", files: []string{"0001.up.sql"}, err: "0001.up.sql must be named NNNN_name.up.sql"},
+		{name: "no direction", files: []string{"0001_a.sql"}, err: "must be named"},
+		{name: "unknown direction", files: []string{"0001_a.sideways.sql"}, err: "must be named"},
+		{name: "not a number", files: []string{"first_a.up.sql"}, err: "must be named"},
+		{name: "version zero", files: []string{"0000_a.up.sql"}, err: "must be named"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"migrations": &fstest.MapFile{Mode: fs.ModeDir | 0o755}}
+			for _, name := range tt.files {
+				content := "-- " + name
+				fsys["migrations/"+name] = &fstest.MapFile{Data: []byte(content)}
+			}
+			migrations, err := Load(fsys, "migrations")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Load = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load = %v", err)
+			}
+			versions := []int{}
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+				if !strings.HasSuffix(m.Up, ".up.sql") || !strings.HasSuffix(m.Down, ".down.sql") {
+					t.Errorf("migration %d has up %q and down %q", m.Version, m.Up, m.Down)
+				}
+			}
+			if !slices.Equal(versions, tt.versions) {
+				t.Errorf("versions = %v, want %v", versions, tt.versions)
+			}
+		})
+	}
+}
+
+// The embedded migrations are applied by every service on startup, so a
+// misnamed file must fail here rather than there.
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Latest() == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for _, migration := range m.migrations {
+		if strings.TrimSpace(migration.Up) == "" {
+			t.Errorf("migration %04d_%s has an empty up script", migration.Version, migration.Name)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS outbox_events_archive;
+DROP TABLE IF EXISTS outbox_events;
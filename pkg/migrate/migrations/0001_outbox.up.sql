@@ -0,0 +1,40 @@
+-- Baseline of the transactional outbox, as created by the former
+-- docker/postgres-init/schema.sql. IF NOT EXISTS lets databases created by
+-- that script adopt the migrations.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id              UUID PRIMARY KEY,
+    aggregate_type  TEXT NOT NULL,
+    aggregate_id    TEXT NOT NULL,
+    event_type      TEXT NOT NULL,
+    payload         JSONB NOT NULL,
+    schema_version  INT NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'PENDING',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    published_at    TIMESTAMPTZ,
+    error           TEXT,
+    traceparent     TEXT -- W3C trace context of the request that wrote the event
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_status_created
+    ON outbox_events (status, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_published_at
+    ON outbox_events (published_at)
+    WHERE status = 'PUBLISHED';
+
+-- Published events older than OUTBOX_RETENTION_MAX_AGE are moved here by the
+-- producer's retention job. Monthly partitions are created on demand.
+CREATE TABLE IF NOT EXISTS outbox_events_archive (
+    id              UUID NOT NULL,
+    aggregate_type  TEXT NOT NULL,
+    aggregate_id    TEXT NOT NULL,
+    event_type      TEXT NOT NULL,
+    payload         JSONB NOT NULL,
+    schema_version  INT NOT NULL,
+    status          TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL,
+    published_at    TIMESTAMPTZ NOT NULL,
+    traceparent     TEXT,
+    archived_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id, published_at)
+) PARTITION BY RANGE (published_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS orders (
+    id          UUID PRIMARY KEY,
+    customer_id UUID NOT NULL,
+    amount      NUMERIC(10, 2) NOT NULL,
+    status      TEXT NOT NULL,
+    discount    NUMERIC(5, 2) DEFAULT 0,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_customer_id
+    ON orders (customer_id);
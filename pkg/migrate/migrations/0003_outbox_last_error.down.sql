@@ -0,0 +1,2 @@
+ALTER TABLE outbox_events DROP COLUMN updated_at;
+ALTER TABLE outbox_events RENAME COLUMN last_error TO error;
@@ -0,0 +1,4 @@
+-- The publisher records why an event failed in last_error and when in
+-- updated_at; the baseline only had an unused error column.
+ALTER TABLE outbox_events RENAME COLUMN error TO last_error;
+ALTER TABLE outbox_events ADD COLUMN updated_at TIMESTAMPTZ;
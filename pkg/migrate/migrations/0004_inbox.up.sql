@@ -0,0 +1,15 @@
+-- Inbox of consumers that keep their state in Postgres: an event's ID is
+-- recorded in the same transaction as its effects, so a redelivered event
+-- is recognised as a duplicate regardless of the in-memory dedup window.
+CREATE TABLE inbox_events (
+    consumer        TEXT NOT NULL, -- consumer group
+    event_id        TEXT NOT NULL,
+    topic           TEXT NOT NULL,
+    kafka_partition INT NOT NULL,
+    kafka_offset    BIGINT NOT NULL,
+    processed_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (consumer, event_id)
+);
+
+CREATE INDEX idx_inbox_processed_at
+    ON inbox_events (processed_at);
@@ -0,0 +1,3 @@
+-- The publisher counts the failed attempts to publish an event and gives up
+-- on it, marking it ERROR, after PRODUCER_MAX_RETRIES of them.
+ALTER TABLE outbox_events ADD COLUMN attempts INT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+-- Nothing to revert: the columns belong to 0001 on every other database.
@@ -0,0 +1,4 @@
+-- 0001 creates the traceparent columns, but databases created by versions of
+-- docker/postgres-init/schema.sql from before tracing adopt 0001 without them.
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS traceparent TEXT;
+ALTER TABLE outbox_events_archive ADD COLUMN IF NOT EXISTS traceparent TEXT;
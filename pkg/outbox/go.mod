@@ -0,0 +1,3 @@
+module github.com/dzon2000/eda/pkg/outbox
+
+go 1.25.5
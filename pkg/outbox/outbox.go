@@ -0,0 +1,39 @@
+// Package outbox writes events into the transactional outbox the producer
+// publishes from. The order service writes an order's OrderCreated event in
+// the transaction that stores the order; tools writing events directly,
+// such as loadgen, share the statement so their rows look the same.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DefaultSchemaID is the registry ID of the OrderCreated schema the
+// development registry is seeded with. The producer encodes an event's
+// payload with the schema its schema_version names.
+const DefaultSchemaID = 1
+
+// InsertOrderCreatedQuery writes an OrderCreated event; an empty
+// traceparent is stored as NULL.
+const InsertOrderCreatedQuery = `
+	INSERT INTO outbox_events (
+		id, aggregate_type, aggregate_id,
+		event_type, payload, schema_version, traceparent
+	) VALUES (
+		$1, 'order', $2,
+		'OrderCreated', $3, $4, NULLIF($5, '')
+	)
+`
+
+// Execer is a *sql.DB or *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// InsertOrderCreated writes the OrderCreated event eventID of order orderID
+// with its JSON payload, to be encoded with the registry schema schemaID.
+func InsertOrderCreated(ctx context.Context, db Execer, eventID, orderID string, payload []byte, schemaID int, traceparent string) error {
+	_, err := db.ExecContext(ctx, InsertOrderCreatedQuery, eventID, orderID, payload, schemaID, traceparent)
+	return err
+}
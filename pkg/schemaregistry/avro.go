@@ -0,0 +1,222 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// avroType is a parsed Avro schema, as far as schema resolution needs it.
+// References to a named type point to its definition, so recursive types
+// form cycles.
+type avroType struct {
+	kind       string // a primitive type name, or record, enum, array, map, fixed or union
+	name       string // full name of records, enums and fixed
+	aliases    []string
+	fields     []avroField
+	symbols    []string
+	hasDefault bool // an enum with a default symbol
+	items      *avroType
+	values     *avroType
+	size       int
+	branches   []*avroType
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	typ        *avroType
+	hasDefault bool
+}
+
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvro parses the JSON of a schema.
+func parseAvro(schema string) (*avroType, error) {
+	var raw any
+	dec := json.NewDecoder(strings.NewReader(schema))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("schema is not JSON: %w", err)
+	}
+	p := &avroParser{named: make(map[string]*avroType)}
+	return p.parse(raw, "")
+}
+
+type avroParser struct {
+	named map[string]*avroType
+}
+
+func (p *avroParser) parse(raw any, namespace string) (*avroType, error) {
+	switch v := raw.(type) {
+	case string:
+		return p.reference(v, namespace)
+	case []any:
+		t := &avroType{kind: "union"}
+		for _, branch := range v {
+			b, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if b.kind == "union" {
+				return nil, fmt.Errorf("unions may not immediately contain unions")
+			}
+			t.branches = append(t.branches, b)
+		}
+		return t, nil
+	case map[string]any:
+		return p.parseComplex(v, namespace)
+	default:
+		return nil, fmt.Errorf("unexpected %T in schema", raw)
+	}
+}
+
+// reference resolves a primitive or a named type defined earlier.
+func (p *avroParser) reference(name, namespace string) (*avroType, error) {
+	if primitives[name] {
+		return &avroType{kind: name}, nil
+	}
+	if !strings.Contains(name, ".") && namespace != "" {
+		if t, ok := p.named[namespace+"."+name]; ok {
+			return t, nil
+		}
+	}
+	if t, ok := p.named[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+func (p *avroParser) parseComplex(v map[string]any, namespace string) (*avroType, error) {
+	kind, ok := v["type"].(string)
+	if !ok {
+		// {"type": {...}} and {"type": [...]} wrap another schema.
+		if inner, ok := v["type"]; ok {
+			return p.parse(inner, namespace)
+		}
+		return nil, fmt.Errorf("schema object without type")
+	}
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		return p.parseNamed(v, kind, namespace)
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		return &avroType{kind: "array", items: items}, nil
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		return &avroType{kind: "map", values: values}, nil
+	default:
+		// A primitive, possibly with a logical type.
+		return p.reference(kind, namespace)
+	}
+}
+
+func (p *avroParser) parseNamed(v map[string]any, kind, namespace string) (*avroType, error) {
+	name, _ := v["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%s without name", kind)
+	}
+	if ns, ok := v["namespace"].(string); ok && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	fullName := name
+	if !strings.Contains(name, ".") && namespace != "" {
+		fullName = namespace + "." + name
+	}
+	if i := strings.LastIndex(fullName, "."); i >= 0 {
+		namespace = fullName[:i]
+	}
+	if _, ok := p.named[fullName]; ok {
+		return nil, fmt.Errorf("%s is defined twice", fullName)
+	}
+	if kind == "error" {
+		kind = "record"
+	}
+	t := &avroType{kind: kind, name: fullName, aliases: qualify(stringList(v["aliases"]), namespace)}
+	// Registered before the fields are parsed so they can refer to it.
+	p.named[fullName] = t
+
+	switch kind {
+	case "record":
+		fields, ok := v["fields"].([]any)
+		if !ok {
+			return nil, fmt.Errorf("record %s without fields", fullName)
+		}
+		for _, f := range fields {
+			fm, ok := f.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("record %s has a field that is not an object", fullName)
+			}
+			fieldName, _ := fm["name"].(string)
+			if fieldName == "" {
+				return nil, fmt.Errorf("record %s has a field without name", fullName)
+			}
+			typ, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field %s.%s: %w", fullName, fieldName, err)
+			}
+			_, hasDefault := fm["default"]
+			t.fields = append(t.fields, avroField{
+				name:       fieldName,
+				aliases:    stringList(fm["aliases"]),
+				typ:        typ,
+				hasDefault: hasDefault,
+			})
+		}
+	case "enum":
+		t.symbols = stringList(v["symbols"])
+		if len(t.symbols) == 0 {
+			return nil, fmt.Errorf("enum %s without symbols", fullName)
+		}
+		_, t.hasDefault = v["default"]
+	case "fixed":
+		size, ok := v["size"].(json.Number)
+		n, err := size.Int64()
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("fixed %s without a valid size", fullName)
+		}
+		t.size = int(n)
+	}
+	return t, nil
+}
+
+// stringList returns the strings of a JSON array, ignoring anything else.
+func stringList(v any) []string {
+	items, _ := v.([]any)
+	var out []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func qualify(names []string, namespace string) []string {
+	for i, name := range names {
+		if !strings.Contains(name, ".") && namespace != "" {
+			names[i] = namespace + "." + name
+		}
+	}
+	return names
+}
+
+// canonical is schema as compact JSON, which identifies a schema when it is
+// registered again.
+func canonical(schema string) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(schema)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,177 @@
+// Command schemaregistry serves package schemaregistry over HTTP, a
+// lightweight stand-in for cp-schema-registry in development.
+//
+// It is configured like the services, see package conf:
+//
+//	HTTP_ADDR                      listen address, :8081
+//	SCHEMA_REGISTRY_DIR            where the state is kept, in memory only when empty
+//	SCHEMA_REGISTRY_COMPATIBILITY  global level of a new registry, BACKWARD
+//	SCHEMA_REGISTRY_SEED           schemas registered on start, as subject=file[@id],...
+//	SHUTDOWN_TIMEOUT               how long in-flight requests may take, 10s
+//	LOG_FORMAT, LOG_LEVEL          json | text, info
+//
+// A seed whose schema is already registered under its subject is left
+// alone, so seeding a persistent registry on every start is safe. Seeds with
+// an @id get that ID, which lets the registry hand out the IDs the services
+// are configured with.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/schemaregistry"
+)
+
+var logger = logging.Logger("main")
+
+// fatal logs err and exits; slog has no Fatal.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+type config struct {
+	HTTPAddr        string
+	Registry        schemaregistry.Config
+	Seeds           []seed
+	ShutdownTimeout time.Duration
+	Logging         logging.Config
+}
+
+// seed is a schema file registered under Subject on start.
+type seed struct {
+	Subject string
+	File    string
+	ID      int // 0 for the next free ID
+}
+
+func loadConfig(args []string) (*config, error) {
+	l, err := conf.New(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	level, err := logging.ParseLevel(l.String("LOG_LEVEL", "info"))
+	l.Check("LOG_LEVEL", err)
+	cfg := &config{
+		HTTPAddr: l.String("HTTP_ADDR", ":8081"),
+		Registry: schemaregistry.Config{
+			Dir:           l.String("SCHEMA_REGISTRY_DIR", ""),
+			Compatibility: l.String("SCHEMA_REGISTRY_COMPATIBILITY", schemaregistry.CompatibilityBackward),
+		},
+		ShutdownTimeout: l.Duration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		Logging: logging.Config{
+			Format: l.String("LOG_FORMAT", logging.FormatJSON),
+			Level:  level,
+		},
+	}
+	cfg.Seeds, err = parseSeeds(l.Strings("SCHEMA_REGISTRY_SEED", nil))
+	l.Check("SCHEMA_REGISTRY_SEED", err)
+
+	if err := l.Finish(cfg.validate); err != nil {
+		if errors.Is(err, conf.ErrPrinted) {
+			return cfg, err
+		}
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *config) validate() error {
+	if c.HTTPAddr == "" {
+		return fmt.Errorf("HTTP listen address is required")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive")
+	}
+	return c.Logging.Validate()
+}
+
+// parseSeeds parses subject=file[@id] entries.
+func parseSeeds(entries []string) ([]seed, error) {
+	var seeds []seed
+	for _, entry := range entries {
+		subject, file, ok := strings.Cut(entry, "=")
+		if !ok || subject == "" || file == "" {
+			return nil, fmt.Errorf("seed %q must be subject=file[@id]", entry)
+		}
+		s := seed{Subject: subject, File: file}
+		if file, id, ok := strings.Cut(file, "@"); ok {
+			n, err := strconv.Atoi(id)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("seed %q has an invalid ID", entry)
+			}
+			s.File, s.ID = file, n
+		}
+		seeds = append(seeds, s)
+	}
+	return seeds, nil
+}
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stdout); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	registry, err := schemaregistry.New(cfg.Registry)
+	if err != nil {
+		fatal("Failed to open registry", err)
+	}
+	for _, s := range cfg.Seeds {
+		schema, err := os.ReadFile(s.File)
+		if err != nil {
+			fatal("Failed to read seed schema", err)
+		}
+		id, err := registry.RegisterID(s.Subject, string(schema), s.ID)
+		if err != nil {
+			fatal("Failed to register seed schema", fmt.Errorf("%s: %w", s.File, err))
+		}
+		logger.Info("Seeded schema", "subject", s.Subject, "file", s.File, "id", id)
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.HTTPAddr,
+		Handler: registry.Handler(),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("Schema registry listening", "addr", cfg.HTTPAddr, "dir", cfg.Registry.Dir)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", err)
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error during shutdown", "error", err)
+	}
+	logger.Info("Shutdown complete")
+}
@@ -0,0 +1,198 @@
+package schemaregistry
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Compatibility levels decide which schemas may be registered as the next
+// version of a subject, as in the Confluent registry. BACKWARD means
+// consumers using the new schema can read data written with the previous
+// one, FORWARD the other way round, FULL both; the transitive levels check
+// against every earlier version instead of the latest.
+const (
+	CompatibilityNone               = "NONE"
+	CompatibilityBackward           = "BACKWARD"
+	CompatibilityBackwardTransitive = "BACKWARD_TRANSITIVE"
+	CompatibilityForward            = "FORWARD"
+	CompatibilityForwardTransitive  = "FORWARD_TRANSITIVE"
+	CompatibilityFull               = "FULL"
+	CompatibilityFullTransitive     = "FULL_TRANSITIVE"
+)
+
+func validCompatibility(level string) bool {
+	switch level {
+	case CompatibilityNone,
+		CompatibilityBackward, CompatibilityBackwardTransitive,
+		CompatibilityForward, CompatibilityForwardTransitive,
+		CompatibilityFull, CompatibilityFullTransitive:
+		return true
+	}
+	return false
+}
+
+// checkCompatibility returns why schema may not follow previous, the
+// subject's versions oldest first, under level. It returns nothing when the
+// schema is compatible.
+func checkCompatibility(level string, schema *avroType, previous []*avroType) []string {
+	if level == CompatibilityNone || len(previous) == 0 {
+		return nil
+	}
+	against := previous[len(previous)-1:]
+	if strings.HasSuffix(level, "_TRANSITIVE") {
+		against = previous
+	}
+	backward := strings.HasPrefix(level, CompatibilityBackward) || strings.HasPrefix(level, CompatibilityFull)
+	forward := strings.HasPrefix(level, CompatibilityForward) || strings.HasPrefix(level, CompatibilityFull)
+
+	var problems []string
+	for _, old := range against {
+		if backward {
+			problems = append(problems, canRead(schema, old)...)
+		}
+		if forward {
+			problems = append(problems, canRead(old, schema)...)
+		}
+	}
+	return problems
+}
+
+// canRead returns why data written with writer cannot be read with reader,
+// following the schema resolution rules of the Avro specification.
+func canRead(reader, writer *avroType) []string {
+	r := &resolver{seen: make(map[[2]*avroType]bool)}
+	r.check(reader, writer, "")
+	return r.problems
+}
+
+type resolver struct {
+	seen     map[[2]*avroType]bool // pairs being or already checked, for recursive types
+	problems []string
+}
+
+func (r *resolver) fail(path, format string, args ...any) {
+	if path == "" {
+		path = "/"
+	}
+	r.problems = append(r.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (r *resolver) check(reader, writer *avroType, path string) {
+	// Every branch the writer may have used must be readable.
+	if writer.kind == "union" {
+		for _, branch := range writer.branches {
+			r.check(reader, branch, path)
+		}
+		return
+	}
+	if reader.kind == "union" {
+		for _, branch := range reader.branches {
+			sub := &resolver{seen: maps.Clone(r.seen)}
+			if sub.check(branch, writer, path); len(sub.problems) == 0 {
+				return
+			}
+		}
+		r.fail(path, "reader union has no branch for writer type %s", describe(writer))
+		return
+	}
+
+	pair := [2]*avroType{reader, writer}
+	if r.seen[pair] {
+		return
+	}
+	r.seen[pair] = true
+
+	if !promotable(reader.kind, writer.kind) {
+		r.fail(path, "reader type %s cannot read writer type %s", describe(reader), describe(writer))
+		return
+	}
+	switch reader.kind {
+	case "record":
+		if !namesMatch(reader, writer) {
+			r.fail(path, "reader record %s does not match writer record %s", reader.name, writer.name)
+			return
+		}
+		for _, field := range reader.fields {
+			written := writerField(writer, field)
+			if written == nil {
+				if !field.hasDefault {
+					r.fail(path+"/"+field.name, "reader field has no default and is missing from the writer")
+				}
+				continue
+			}
+			r.check(field.typ, written.typ, path+"/"+field.name)
+		}
+	case "enum":
+		if !namesMatch(reader, writer) {
+			r.fail(path, "reader enum %s does not match writer enum %s", reader.name, writer.name)
+			return
+		}
+		if reader.hasDefault {
+			return
+		}
+		for _, symbol := range writer.symbols {
+			if !slices.Contains(reader.symbols, symbol) {
+				r.fail(path, "reader enum %s has no symbol %s and no default", reader.name, symbol)
+			}
+		}
+	case "fixed":
+		if !namesMatch(reader, writer) || reader.size != writer.size {
+			r.fail(path, "reader fixed %s(%d) does not match writer fixed %s(%d)", reader.name, reader.size, writer.name, writer.size)
+		}
+	case "array":
+		r.check(reader.items, writer.items, path+"/items")
+	case "map":
+		r.check(reader.values, writer.values, path+"/values")
+	}
+}
+
+// promotable reports whether a reader of one kind can read data written as
+// another, e.g. an int as a long.
+func promotable(reader, writer string) bool {
+	if reader == writer {
+		return true
+	}
+	switch writer {
+	case "int":
+		return reader == "long" || reader == "float" || reader == "double"
+	case "long":
+		return reader == "float" || reader == "double"
+	case "float":
+		return reader == "double"
+	case "string":
+		return reader == "bytes"
+	case "bytes":
+		return reader == "string"
+	}
+	return false
+}
+
+// namesMatch compares named types by their unqualified names, or the
+// writer's full name against the reader's aliases.
+func namesMatch(reader, writer *avroType) bool {
+	return simpleName(reader.name) == simpleName(writer.name) || slices.Contains(reader.aliases, writer.name)
+}
+
+func simpleName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// writerField finds the writer's field for field, by name or by one of the
+// reader field's aliases.
+func writerField(writer *avroType, field avroField) *avroField {
+	for i, f := range writer.fields {
+		if f.name == field.name || slices.Contains(field.aliases, f.name) {
+			return &writer.fields[i]
+		}
+	}
+	return nil
+}
+
+func describe(t *avroType) string {
+	if t.name != "" {
+		return t.kind + " " + t.name
+	}
+	return t.kind
+}
@@ -0,0 +1,145 @@
+package schemaregistry
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, schema string) *avroType {
+	t.Helper()
+	typ, err := parseAvro(schema)
+	if err != nil {
+		t.Fatalf("parseAvro(%s): %v", schema, err)
+	}
+	return typ
+}
+
+// order is an Order record with fields, each a JSON field object.
+func order(fields ...string) string {
+	return `{"type":"record","name":"Order","namespace":"eda","fields":[` + strings.Join(fields, ",") + `]}`
+}
+
+const (
+	idField           = `{"name":"id","type":"string"}`
+	amountField       = `{"name":"amount","type":"double"}`
+	amountWithDefault = `{"name":"amount","type":"double","default":0}`
+	noteField         = `{"name":"note","type":"string"}`
+	noteWithDefault   = `{"name":"note","type":"string","default":""}`
+	optionalNote      = `{"name":"note","type":["null","string"],"default":null}`
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	v1 := order(idField, amountField)
+	addOptional := order(idField, amountField, optionalNote)
+	addRequired := order(idField, amountField, noteField)
+	removeRequired := order(idField)
+	changeType := order(idField, `{"name":"amount","type":"string"}`)
+
+	tests := []struct {
+		level      string
+		previous   []string
+		schema     string
+		compatible bool
+	}{
+		{CompatibilityNone, []string{v1}, changeType, true},
+		{CompatibilityBackward, nil, changeType, true},
+
+		{CompatibilityBackward, []string{v1}, addOptional, true},
+		{CompatibilityBackward, []string{v1}, removeRequired, true},
+		{CompatibilityBackward, []string{v1}, addRequired, false},
+		{CompatibilityBackward, []string{v1}, changeType, false},
+
+		{CompatibilityForward, []string{v1}, addOptional, true},
+		{CompatibilityForward, []string{v1}, addRequired, true},
+		{CompatibilityForward, []string{v1}, removeRequired, false},
+		{CompatibilityForward, []string{v1}, changeType, false},
+
+		{CompatibilityFull, []string{v1}, addOptional, true},
+		{CompatibilityFull, []string{v1}, addRequired, false},
+		{CompatibilityFull, []string{v1}, removeRequired, false},
+
+		// The latest version has the default the first one lacks, so only the
+		// transitive levels see the break.
+		{CompatibilityBackward, []string{order(idField), order(idField, noteWithDefault)}, order(idField, noteField), true},
+		{CompatibilityBackwardTransitive, []string{order(idField), order(idField, noteWithDefault)}, order(idField, noteField), false},
+		{CompatibilityBackwardTransitive, []string{order(idField), order(idField, noteWithDefault)}, order(idField, optionalNote), true},
+
+		{CompatibilityForward, []string{order(idField, amountField), order(idField, amountWithDefault)}, order(idField), true},
+		{CompatibilityForwardTransitive, []string{order(idField, amountField), order(idField, amountWithDefault)}, order(idField), false},
+		{CompatibilityForwardTransitive, []string{order(idField), order(idField, amountWithDefault)}, order(idField), true},
+
+		{CompatibilityFull, []string{order(idField), order(idField, noteWithDefault)}, order(idField, noteField), true},
+		{CompatibilityFullTransitive, []string{order(idField), order(idField, noteWithDefault)}, order(idField, noteField), false},
+		{CompatibilityFullTransitive, []string{order(idField), order(idField, optionalNote)}, order(idField, optionalNote, amountWithDefault), true},
+	}
+	for _, tt := range tests {
+		var previous []*avroType
+		for _, schema := range tt.previous {
+			previous = append(previous, mustParse(t, schema))
+		}
+		problems := checkCompatibility(tt.level, mustParse(t, tt.schema), previous)
+		if compatible := len(problems) == 0; compatible != tt.compatible {
+			t.Errorf("%s: %s after %v: problems %q, want compatible=%v", tt.level, tt.schema, tt.previous, problems, tt.compatible)
+		}
+	}
+}
+
+func TestCanRead(t *testing.T) {
+	color := func(symbols, extra string) string {
+		return `{"type":"enum","name":"Color","symbols":[` + symbols + `]` + extra + `}`
+	}
+	list := `{"type":"record","name":"Node","fields":[{"name":"value","type":"int"},{"name":"next","type":["null","Node"]}]}`
+	listOfLongs := `{"type":"record","name":"Node","fields":[{"name":"value","type":"long"},{"name":"next","type":["null","Node"]}]}`
+
+	tests := []struct {
+		reader, writer string
+		problem        string // a problem reported, "" when the reader can read the writer
+	}{
+		{`"long"`, `"int"`, ""},
+		{`"double"`, `"float"`, ""},
+		{`"string"`, `"bytes"`, ""},
+		{`"bytes"`, `"string"`, ""},
+		{`"int"`, `"long"`, "reader type int cannot read writer type long"},
+		{`"string"`, `"int"`, "reader type string cannot read writer type int"},
+
+		{`["null","long"]`, `"int"`, ""},
+		{`"string"`, `["null","string"]`, "reader type string cannot read writer type null"},
+		{`["null","string"]`, `["string","null"]`, ""},
+		{`["null","string"]`, `"int"`, "reader union has no branch for writer type int"},
+
+		{color(`"RED","GREEN","BLUE"`, ""), color(`"RED","GREEN"`, ""), ""},
+		{color(`"RED","GREEN"`, ""), color(`"RED","GREEN","BLUE"`, ""), "has no symbol BLUE"},
+		{color(`"RED","GREEN"`, `,"default":"RED"`), color(`"RED","GREEN","BLUE"`, ""), ""},
+		{color(`"RED"`, ""), `{"type":"enum","name":"Shade","symbols":["RED"]}`, "does not match writer enum Shade"},
+
+		{`{"type":"fixed","name":"Hash","size":16}`, `{"type":"fixed","name":"Hash","size":16}`, ""},
+		{`{"type":"fixed","name":"Hash","size":32}`, `{"type":"fixed","name":"Hash","size":16}`, "does not match writer fixed Hash(16)"},
+
+		{`{"type":"array","items":"long"}`, `{"type":"array","items":"int"}`, ""},
+		{`{"type":"array","items":"int"}`, `{"type":"array","items":"string"}`, "/items: reader type int"},
+		{`{"type":"map","values":"double"}`, `{"type":"map","values":"int"}`, ""},
+		{`{"type":"map","values":"int"}`, `{"type":"map","values":"long"}`, "/values: reader type int"},
+
+		{order(idField), `{"type":"record","name":"Purchase","fields":[` + idField + `]}`, "does not match writer record Purchase"},
+		{`{"type":"record","name":"Order","namespace":"v2","fields":[` + idField + `]}`, order(idField), ""},
+		{`{"type":"record","name":"Purchase","aliases":["eda.Order"],"fields":[` + idField + `]}`, order(idField), ""},
+		{order(`{"name":"total","type":"double","aliases":["amount"]}`), order(idField, amountField), ""},
+		{order(`{"name":"total","type":"double"}`), order(idField, amountField), "/total: reader field has no default"},
+		{order(`{"name":"amount","type":"int"}`), order(amountField), "/amount: reader type int cannot read writer type double"},
+
+		{listOfLongs, list, ""},
+		{list, listOfLongs, "/value: reader type int cannot read writer type long"},
+	}
+	for _, tt := range tests {
+		problems := canRead(mustParse(t, tt.reader), mustParse(t, tt.writer))
+		if tt.problem == "" {
+			if len(problems) > 0 {
+				t.Errorf("canRead(%s, %s) = %q, want no problems", tt.reader, tt.writer, problems)
+			}
+			continue
+		}
+		if !strings.Contains(strings.Join(problems, "\n"), tt.problem) {
+			t.Errorf("canRead(%s, %s) = %q, want a problem containing %q", tt.reader, tt.writer, problems, tt.problem)
+		}
+	}
+}
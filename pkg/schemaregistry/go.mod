@@ -0,0 +1,20 @@
+module github.com/dzon2000/eda/pkg/schemaregistry
+
+go 1.25.5
+
+require (
+	github.com/dzon2000/eda/pkg/conf v0.0.0
+	github.com/dzon2000/eda/pkg/logging v0.0.0
+)
+
+require (
+	github.com/BurntSushi/toml v1.6.0 // indirect
+	go.opentelemetry.io/otel v1.38.0 // indirect
+	go.opentelemetry.io/otel/trace v1.38.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace (
+	github.com/dzon2000/eda/pkg/conf => ../conf
+	github.com/dzon2000/eda/pkg/logging => ../logging
+)
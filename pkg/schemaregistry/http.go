@@ -0,0 +1,229 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Handler serves the registry's REST API:
+//
+//	GET  /schemas/ids/{id}
+//	GET  /subjects
+//	GET  /subjects/{subject}/versions
+//	GET  /subjects/{subject}/versions/{version}
+//	GET  /subjects/{subject}/versions/{version}/schema
+//	POST /subjects/{subject}/versions
+//	POST /subjects/{subject}
+//	POST /compatibility/subjects/{subject}/versions[/{version}]
+//	GET  /config[/{subject}]
+//	PUT  /config[/{subject}]
+//
+// A version is a number or "latest". Errors are answered with the
+// registry's error_code and message.
+func (r *Registry) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /schemas/ids/{id}", r.getSchemaByID)
+	mux.HandleFunc("GET /subjects", r.listSubjects)
+	mux.HandleFunc("GET /subjects/{subject}/versions", r.listVersions)
+	mux.HandleFunc("GET /subjects/{subject}/versions/{version}", r.getVersion)
+	mux.HandleFunc("GET /subjects/{subject}/versions/{version}/schema", r.getVersionSchema)
+	mux.HandleFunc("POST /subjects/{subject}/versions", r.register)
+	mux.HandleFunc("POST /subjects/{subject}", r.lookup)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions", r.checkCompatibility)
+	mux.HandleFunc("POST /compatibility/subjects/{subject}/versions/{version}", r.checkCompatibility)
+	mux.HandleFunc("GET /config", r.getConfig)
+	mux.HandleFunc("GET /config/{subject}", r.getConfig)
+	mux.HandleFunc("PUT /config", r.setConfig)
+	mux.HandleFunc("PUT /config/{subject}", r.setConfig)
+	return mux
+}
+
+// schemaRequest is the body of registration, lookup and compatibility
+// requests.
+type schemaRequest struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+}
+
+func readSchema(req *http.Request) (string, error) {
+	var body schemaRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	if body.SchemaType != "" && body.SchemaType != "AVRO" {
+		return "", fmt.Errorf("%w: only AVRO schemas are supported, not %s", ErrInvalidSchema, body.SchemaType)
+	}
+	return body.Schema, nil
+}
+
+// parseVersion parses a version path value, "latest" being Latest.
+func parseVersion(value string) (int, error) {
+	if value == "" || value == "latest" {
+		return Latest, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || (version < 1 && version != Latest) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidVersion, value)
+	}
+	return version, nil
+}
+
+func (r *Registry) getSchemaByID(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: ID %q", ErrSchemaNotFound, req.PathValue("id")))
+		return
+	}
+	schema, err := r.SchemaByID(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"schema": schema})
+}
+
+func (r *Registry) listSubjects(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, r.Subjects())
+}
+
+func (r *Registry) listVersions(w http.ResponseWriter, req *http.Request) {
+	versions, err := r.Versions(req.PathValue("subject"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, versions)
+}
+
+func (r *Registry) version(req *http.Request) (Schema, error) {
+	version, err := parseVersion(req.PathValue("version"))
+	if err != nil {
+		return Schema{}, err
+	}
+	return r.Version(req.PathValue("subject"), version)
+}
+
+func (r *Registry) getVersion(w http.ResponseWriter, req *http.Request) {
+	schema, err := r.version(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, schema)
+}
+
+func (r *Registry) getVersionSchema(w http.ResponseWriter, req *http.Request) {
+	schema, err := r.version(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(schema.Schema))
+}
+
+func (r *Registry) register(w http.ResponseWriter, req *http.Request) {
+	schema, err := readSchema(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, err := r.Register(req.PathValue("subject"), schema)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]int{"id": id})
+}
+
+func (r *Registry) lookup(w http.ResponseWriter, req *http.Request) {
+	schema, err := readSchema(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	found, err := r.Lookup(req.PathValue("subject"), schema)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, found)
+}
+
+func (r *Registry) checkCompatibility(w http.ResponseWriter, req *http.Request) {
+	version, err := parseVersion(req.PathValue("version"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	schema, err := readSchema(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	problems, err := r.CheckCompatibility(req.PathValue("subject"), version, schema)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := map[string]any{"is_compatible": len(problems) == 0}
+	if req.URL.Query().Get("verbose") == "true" {
+		res["messages"] = append([]string{}, problems...)
+	}
+	writeJSON(w, res)
+}
+
+// getConfig answers for a subject without a level of its own with the
+// global level when defaultToGlobal=true, and with an error otherwise.
+func (r *Registry) getConfig(w http.ResponseWriter, req *http.Request) {
+	subject := req.PathValue("subject")
+	level, err := r.Compatibility(subject)
+	if errors.Is(err, ErrSubjectConfigNotFound) && req.URL.Query().Get("defaultToGlobal") == "true" {
+		level, err = r.Compatibility("")
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, map[string]string{"compatibilityLevel": level})
+}
+
+func (r *Registry) setConfig(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Compatibility string `json:"compatibility"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, fmt.Errorf("%w: %w", ErrInvalidCompatibility, err))
+		return
+	}
+	if err := r.SetCompatibility(req.PathValue("subject"), body.Compatibility); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, body)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", contentType)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with the Error err wraps, or a server error.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, 50001
+	var e *Error
+	if errors.As(err, &e) {
+		status, code = e.Status, e.Code
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error_code": code,
+		"message":    err.Error(),
+	})
+}
@@ -0,0 +1,389 @@
+// Package schemaregistry implements the part of the Confluent Schema
+// Registry the services use: Avro schemas registered under subjects, looked
+// up by ID or version, compatibility checks and compatibility config. It
+// stands in for cp-schema-registry in tests, as a library serving Handler,
+// and in lightweight development environments, as cmd/schemaregistry.
+//
+// The registry lives in memory. Given a directory, it also keeps its state
+// in a file there, rewritten after every change, so it survives restarts.
+package schemaregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Error is a registry error with the HTTP status and error code the
+// Confluent registry answers it with. The errors below are returned
+// wrapped with details.
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrSubjectNotFound       = &Error{http.StatusNotFound, 40401, "subject not found"}
+	ErrVersionNotFound       = &Error{http.StatusNotFound, 40402, "version not found"}
+	ErrSchemaNotFound        = &Error{http.StatusNotFound, 40403, "schema not found"}
+	ErrSubjectConfigNotFound = &Error{http.StatusNotFound, 40408, "subject has no compatibility level"}
+	ErrIncompatibleSchema    = &Error{http.StatusConflict, 409, "schema is incompatible with an earlier version"}
+	ErrInvalidSchema         = &Error{http.StatusUnprocessableEntity, 42201, "invalid schema"}
+	ErrInvalidVersion        = &Error{http.StatusUnprocessableEntity, 42202, "invalid version"}
+	ErrInvalidCompatibility  = &Error{http.StatusUnprocessableEntity, 42203, "invalid compatibility level"}
+)
+
+// Latest stands for the latest version of a subject.
+const Latest = -1
+
+// stateFile is the name of the state file in Config.Dir.
+const stateFile = "registry.json"
+
+type Config struct {
+	Dir string // where the state is kept, in memory only when empty
+	// Compatibility is the global level of a new registry, BACKWARD when
+	// empty. A registry loaded from Dir keeps its own.
+	Compatibility string
+}
+
+// Schema is a version of a subject.
+type Schema struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+	ID      int    `json:"id"`
+	Schema  string `json:"schema"`
+}
+
+// state is everything the registry knows, as stored in the state file.
+type state struct {
+	Schemas              map[int]string    `json:"schemas"`  // by ID, as compact JSON
+	Subjects             map[string][]int  `json:"subjects"` // schema ID of version i+1
+	Compatibility        string            `json:"compatibility"`
+	SubjectCompatibility map[string]string `json:"subjectCompatibility"`
+}
+
+func (s state) clone() state {
+	c := s
+	c.Schemas = maps.Clone(s.Schemas)
+	c.Subjects = make(map[string][]int, len(s.Subjects))
+	for subject, ids := range s.Subjects {
+		c.Subjects[subject] = slices.Clone(ids)
+	}
+	c.SubjectCompatibility = maps.Clone(s.SubjectCompatibility)
+	return c
+}
+
+type Registry struct {
+	path string // of the state file, "" in memory only
+
+	mu     sync.Mutex
+	state  state
+	parsed map[int]*avroType // by schema ID
+}
+
+// New returns an empty registry, or the one stored in cfg.Dir.
+func New(cfg Config) (*Registry, error) {
+	if cfg.Compatibility == "" {
+		cfg.Compatibility = CompatibilityBackward
+	}
+	if !validCompatibility(cfg.Compatibility) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCompatibility, cfg.Compatibility)
+	}
+	r := &Registry{
+		state: state{
+			Schemas:              make(map[int]string),
+			Subjects:             make(map[string][]int),
+			Compatibility:        cfg.Compatibility,
+			SubjectCompatibility: make(map[string]string),
+		},
+		parsed: make(map[int]*avroType),
+	}
+	if cfg.Dir == "" {
+		return r, nil
+	}
+	r.path = filepath.Join(cfg.Dir, stateFile)
+	b, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry state: %w", err)
+	}
+	if err := json.Unmarshal(b, &r.state); err != nil {
+		return nil, fmt.Errorf("failed to read registry state %s: %w", r.path, err)
+	}
+	if r.state.Schemas == nil || r.state.Subjects == nil || r.state.SubjectCompatibility == nil || !validCompatibility(r.state.Compatibility) {
+		return nil, fmt.Errorf("registry state %s is incomplete", r.path)
+	}
+	for id, schema := range r.state.Schemas {
+		t, err := parseAvro(schema)
+		if err != nil {
+			return nil, fmt.Errorf("schema %d in %s: %w", id, r.path, err)
+		}
+		r.parsed[id] = t
+	}
+	return r, nil
+}
+
+// update applies fn to a copy of the state and, once the copy is saved,
+// makes it the registry's state. The caller must hold mu.
+func (r *Registry) update(fn func(s *state) error) error {
+	next := r.state.clone()
+	if err := fn(&next); err != nil {
+		return err
+	}
+	if r.path != "" {
+		b, err := json.MarshalIndent(next, "", "  ")
+		if err != nil {
+			return err
+		}
+		// Written next to the file and renamed, so a crash never leaves
+		// half a state behind.
+		tmp := r.path + ".tmp"
+		if err := os.WriteFile(tmp, b, 0o644); err != nil {
+			return fmt.Errorf("failed to save registry state: %w", err)
+		}
+		if err := os.Rename(tmp, r.path); err != nil {
+			return fmt.Errorf("failed to save registry state: %w", err)
+		}
+	}
+	r.state = next
+	return nil
+}
+
+// Register adds schema as the next version of subject and returns its ID.
+// A schema already registered under the subject keeps its version, and a
+// schema registered under another subject keeps its ID. The schema must be
+// compatible with the subject's versions under its compatibility level.
+func (r *Registry) Register(subject, schema string) (int, error) {
+	return r.RegisterID(subject, schema, 0)
+}
+
+// RegisterID is Register with the ID the schema must get, or 0 for any. It
+// lets a development registry hand out the IDs configured in the services.
+func (r *Registry) RegisterID(subject, schema string, id int) (int, error) {
+	schema, parsed, err := parse(schema)
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing := r.idOf(schema)
+	if existing != 0 && slices.Contains(r.state.Subjects[subject], existing) {
+		if id != 0 && id != existing {
+			return 0, fmt.Errorf("%w: already registered with ID %d", ErrInvalidSchema, existing)
+		}
+		return existing, nil
+	}
+	if problems := r.incompatibilities(subject, parsed, r.state.Subjects[subject]); len(problems) > 0 {
+		return 0, fmt.Errorf("%w: %s", ErrIncompatibleSchema, strings.Join(problems, "; "))
+	}
+
+	switch {
+	case existing != 0 && id != 0 && id != existing:
+		return 0, fmt.Errorf("%w: already registered with ID %d", ErrInvalidSchema, existing)
+	case existing != 0:
+		id = existing
+	case id != 0:
+		if _, taken := r.state.Schemas[id]; taken {
+			return 0, fmt.Errorf("%w: ID %d belongs to another schema", ErrInvalidSchema, id)
+		}
+	default:
+		id = 1
+		for used := range r.state.Schemas {
+			id = max(id, used+1)
+		}
+	}
+	err = r.update(func(s *state) error {
+		s.Schemas[id] = schema
+		s.Subjects[subject] = append(s.Subjects[subject], id)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	r.parsed[id] = parsed
+	return id, nil
+}
+
+// parse validates schema and returns it as compact JSON.
+func parse(schema string) (string, *avroType, error) {
+	compact, err := canonical(schema)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	parsed, err := parseAvro(compact)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	return compact, parsed, nil
+}
+
+// idOf returns the ID of a compact schema, or 0. The caller must hold mu.
+func (r *Registry) idOf(schema string) int {
+	for id, s := range r.state.Schemas {
+		if s == schema {
+			return id
+		}
+	}
+	return 0
+}
+
+// incompatibilities checks schema against the versions ids of subject
+// under the subject's level. The caller must hold mu.
+func (r *Registry) incompatibilities(subject string, schema *avroType, ids []int) []string {
+	previous := make([]*avroType, len(ids))
+	for i, id := range ids {
+		previous[i] = r.parsed[id]
+	}
+	return checkCompatibility(r.levelLocked(subject), schema, previous)
+}
+
+// SchemaByID returns the schema with the given ID.
+func (r *Registry) SchemaByID(id int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schema, ok := r.state.Schemas[id]
+	if !ok {
+		return "", fmt.Errorf("%w: ID %d", ErrSchemaNotFound, id)
+	}
+	return schema, nil
+}
+
+// Subjects returns the registered subjects in order.
+func (r *Registry) Subjects() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Sorted(maps.Keys(r.state.Subjects))
+}
+
+// Versions returns the versions of subject, 1 to the latest.
+func (r *Registry) Versions(subject string) ([]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids, ok := r.state.Subjects[subject]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	versions := make([]int, len(ids))
+	for i := range ids {
+		versions[i] = i + 1
+	}
+	return versions, nil
+}
+
+// Version returns a version of subject, or its latest for Latest.
+func (r *Registry) Version(subject string, version int) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids, ok := r.state.Subjects[subject]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	if version == Latest {
+		version = len(ids)
+	}
+	if version < 1 || version > len(ids) {
+		return Schema{}, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, subject, version)
+	}
+	id := ids[version-1]
+	return Schema{Subject: subject, Version: version, ID: id, Schema: r.state.Schemas[id]}, nil
+}
+
+// Lookup returns the version of subject that is schema.
+func (r *Registry) Lookup(subject, schema string) (Schema, error) {
+	schema, _, err := parse(schema)
+	if err != nil {
+		return Schema{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids, ok := r.state.Subjects[subject]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	id := r.idOf(schema)
+	if i := slices.Index(ids, id); id != 0 && i >= 0 {
+		return Schema{Subject: subject, Version: i + 1, ID: id, Schema: schema}, nil
+	}
+	return Schema{}, fmt.Errorf("%w: not registered under %s", ErrSchemaNotFound, subject)
+}
+
+// CheckCompatibility returns why schema could not be registered after a
+// version of subject under the subject's level. For Latest it is checked
+// against the versions registering would check, otherwise against the
+// given version only. A compatible schema has no problems.
+func (r *Registry) CheckCompatibility(subject string, version int, schema string) ([]string, error) {
+	_, parsed, err := parse(schema)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids, ok := r.state.Subjects[subject]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSubjectNotFound, subject)
+	}
+	if version != Latest {
+		if version < 1 || version > len(ids) {
+			return nil, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, subject, version)
+		}
+		ids = ids[version-1 : version]
+	}
+	return r.incompatibilities(subject, parsed, ids), nil
+}
+
+// Compatibility returns the global level for subject "", and otherwise
+// the level set for subject.
+func (r *Registry) Compatibility(subject string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if subject == "" {
+		return r.state.Compatibility, nil
+	}
+	level, ok := r.state.SubjectCompatibility[subject]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSubjectConfigNotFound, subject)
+	}
+	return level, nil
+}
+
+// SetCompatibility sets the global level for subject "", and otherwise
+// the level of subject, which overrides the global one.
+func (r *Registry) SetCompatibility(subject, level string) error {
+	if !validCompatibility(level) {
+		return fmt.Errorf("%w: %q", ErrInvalidCompatibility, level)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(func(s *state) error {
+		if subject == "" {
+			s.Compatibility = level
+		} else {
+			s.SubjectCompatibility[subject] = level
+		}
+		return nil
+	})
+}
+
+// levelLocked returns the level subject is checked with. The caller must
+// hold mu.
+func (r *Registry) levelLocked(subject string) string {
+	if level, ok := r.state.SubjectCompatibility[subject]; ok {
+		return level
+	}
+	return r.state.Compatibility
+}
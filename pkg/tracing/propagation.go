@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/dzon2000/eda/pkg/broker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const traceparentKey = "traceparent"
+
+// Traceparent returns the W3C traceparent of the span in ctx, to be stored
+// next to work that continues the trace later, e.g. an outbox row. It is
+// empty when ctx carries no sampled or unsampled span.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get(traceparentKey)
+}
+
+// WithTraceparent returns ctx continuing the trace of a stored traceparent.
+// An empty or malformed traceparent leaves ctx unchanged.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{traceparentKey: traceparent})
+}
+
+// Inject writes the trace context of ctx into the message headers,
+// replacing any trace headers the message already has.
+func Inject(ctx context.Context, msg *broker.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{msg})
+}
+
+// Extract returns ctx continuing the trace whose context msg carries.
+func Extract(ctx context.Context, msg broker.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{&msg})
+}
+
+// headerCarrier adapts broker message headers to the propagation API.
+type headerCarrier struct {
+	msg *broker.Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c.msg.Header(key)
+	return string(value)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, broker.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Applies the migrations to the database named by the DB_* variables,
+# checks that every query of the producer and the order service is accepted
+# by the resulting schema, then reverts and reapplies all migrations to
+# exercise the down files. Meant for CI against a throwaway Postgres.
+set -eu
+cd "$(dirname "$0")/.."
+
+migrations=$(ls pkg/migrate/migrations/*.down.sql | wc -l)
+
+(cd services/producer && go run . migrate up && go run . migrate check)
+(cd services/order && go run . migrate check)
+(cd services/producer && go run . migrate down "$migrations" && go run . migrate up)
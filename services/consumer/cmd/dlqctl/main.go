@@ -0,0 +1,355 @@
+// Command dlqctl inspects the dead letter topic and replays records to
+// their original topic.
+//
+//	dlqctl list   [filters] [-limit n] [-- config flags]
+//	dlqctl show   [filters] [-limit n] [-- config flags]
+//	dlqctl replay [filters|-all] [-limit n] [-topic t] [-dry-run] [-- config flags]
+//
+// Filters: -error-type, -event-id, -since, -until (RFC 3339) and -offsets
+// (partition:offset,...). replay needs at least one of them, or -all to
+// replay the whole DLQ; -limit alone only replays the oldest records, so it
+// does not count. The DLQ is read from the beginning up to its end when
+// dlqctl started, by a throwaway consumer group that never commits, so
+// dlqctl does not disturb any other reader. Configuration comes from the
+// same config file, environment and flags as the consumer's, the flags
+// following a --, e.g. dlqctl list -- --config consumer.yaml.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/consumer/internal/dlq"
+	"github.com/dzon2000/eda/consumer/internal/events"
+	"github.com/dzon2000/eda/consumer/internal/schema"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/joho/godotenv"
+)
+
+type filter struct {
+	errorType string
+	eventID   string
+	since     time.Time
+	until     time.Time
+	offsets   map[string]bool // "partition:offset" of the DLQ record
+	limit     int
+}
+
+// empty reports whether f matches every record. The limit is not a filter:
+// it picks the oldest records, whatever they are.
+func (f filter) empty() bool {
+	return f.errorType == "" && f.eventID == "" && f.since.IsZero() && f.until.IsZero() &&
+		len(f.offsets) == 0
+}
+
+func (f filter) match(msg broker.Message, event *events.OrderDLQEvent) bool {
+	if f.errorType != "" && event.ErrorType != f.errorType {
+		return false
+	}
+	if f.eventID != "" && event.EventID != f.eventID {
+		return false
+	}
+	if len(f.offsets) > 0 && !f.offsets[fmt.Sprintf("%d:%d", msg.Partition, msg.Offset)] {
+		return false
+	}
+	if !f.since.IsZero() || !f.until.IsZero() {
+		failedAt, err := time.Parse(time.RFC3339, event.FailedAt)
+		if err != nil {
+			return false
+		}
+		if !f.since.IsZero() && failedAt.Before(f.since) {
+			return false
+		}
+		if !f.until.IsZero() && !failedAt.Before(f.until) {
+			return false
+		}
+	}
+	return true
+}
+
+type record struct {
+	msg   broker.Message
+	event *events.OrderDLQEvent
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command := os.Args[1]
+	switch command {
+	case "list", "show", "replay":
+	default:
+		usage()
+	}
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var (
+		errorType = flags.String("error-type", "", "only records with this errorType")
+		eventID   = flags.String("event-id", "", "only records with this eventId")
+		since     = flags.String("since", "", "only records that failed at or after this time (RFC 3339)")
+		until     = flags.String("until", "", "only records that failed before this time (RFC 3339)")
+		offsets   = flags.String("offsets", "", "only these DLQ records, as partition:offset,...")
+		limit     = flags.Int("limit", 0, "stop after this many matching records, 0 for all")
+		idle      = flags.Duration("idle", 10*time.Second, "stop reading once no record arrived for this long, before the end was reached")
+		topic     = flags.String("topic", "", "replay: publish to this topic instead of the original one")
+		dryRun    = flags.Bool("dry-run", false, "replay: print what would be replayed without publishing")
+		all       = flags.Bool("all", false, "replay: replay every record, no filter is required")
+	)
+	flags.Parse(os.Args[2:])
+
+	f := filter{
+		errorType: *errorType,
+		eventID:   *eventID,
+		limit:     *limit,
+	}
+	var err error
+	if f.since, err = parseTime(*since); err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+	if f.until, err = parseTime(*until); err != nil {
+		log.Fatalf("Invalid -until: %v", err)
+	}
+	if *offsets != "" {
+		f.offsets = make(map[string]bool)
+		for _, o := range strings.Split(*offsets, ",") {
+			f.offsets[strings.TrimSpace(o)] = true
+		}
+	}
+	if command == "replay" && f.empty() && !*all {
+		log.Fatal("replay needs a filter, or -all to replay every record")
+	}
+
+	_ = godotenv.Load(".env.development")
+	// The configuration flags are the ones left after the subcommand's.
+	cfg, err := config.Load(flags.Args())
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	registry := schema.New(cfg.SchemaRegistry, nil)
+	kafkaBroker := broker.NewKafka(broker.KafkaConfig{
+		Brokers:    cfg.Kafka.Brokers,
+		MaxRetries: cfg.Kafka.MaxRetries,
+	})
+
+	ctx := context.Background()
+	records, err := readDLQ(ctx, kafkaBroker, registry, cfg.Kafka.DLQTopic, f, *idle)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", cfg.Kafka.DLQTopic, err)
+	}
+
+	switch command {
+	case "list":
+		list(records)
+	case "show":
+		show(records, registry)
+	case "replay":
+		if err := replay(ctx, kafkaBroker, records, *topic, *dryRun); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlqctl list|show|replay [flags] [-- config flags]")
+	os.Exit(2)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// endOfTime is later than any message time, so OffsetForTime returns the
+// end offset for it.
+var endOfTime = time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// readDLQ reads the DLQ from the beginning up to the end offsets its
+// partitions had when they were assigned, and returns the records matching
+// f. Reading also stops once no record arrived for idle, as the last
+// offsets of a partition may be transaction markers that are never fetched.
+func readDLQ(ctx context.Context, b broker.Broker, registry *schema.Registry, topic string, f filter, idle time.Duration) ([]record, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// remaining holds the partitions that were not read up to their end
+	// offset yet, ends their end offsets.
+	var (
+		subscriber broker.Subscriber
+		remaining  = make(map[broker.TopicPartition]bool)
+		ends       = make(map[broker.TopicPartition]int64)
+		endsErr    error
+	)
+	subscriber, err := b.Subscribe(broker.SubscriberConfig{
+		Topics:  []string{topic},
+		GroupID: fmt.Sprintf("dlqctl-%d", time.Now().UnixNano()),
+		OnAssigned: func(ctx context.Context, partitions []broker.TopicPartition) {
+			for _, tp := range partitions {
+				end, err := subscriber.OffsetForTime(ctx, tp, endOfTime)
+				if err != nil {
+					endsErr = err
+					cancel()
+					return
+				}
+				ends[tp] = end
+				if end > 0 {
+					remaining[tp] = true
+				}
+			}
+			if len(remaining) == 0 {
+				cancel() // the DLQ is empty
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer subscriber.Close()
+
+	var records []record
+	for f.limit == 0 || len(records) < f.limit {
+		fetchCtx, cancelFetch := context.WithTimeout(ctx, idle)
+		msg, err := subscriber.Fetch(fetchCtx)
+		cancelFetch()
+		if endsErr != nil {
+			return nil, fmt.Errorf("failed to get the end offsets: %w", endsErr)
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+		if msg.Offset >= ends[tp] {
+			continue // arrived after dlqctl started
+		}
+		if msg.Offset == ends[tp]-1 {
+			delete(remaining, tp)
+		}
+
+		if data, err := registry.Decode(msg.Value); err != nil {
+			log.Printf("Skipping undecodable record %d:%d: %v", msg.Partition, msg.Offset, err)
+		} else if event := events.ParseOrderDLQ(data); f.match(msg, event) {
+			records = append(records, record{msg: msg, event: event})
+		}
+		if len(remaining) == 0 {
+			break
+		}
+	}
+	return records, nil
+}
+
+func list(records []record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DLQ\tFAILED AT\tERROR TYPE\tEVENT ID\tORIGIN\tERROR")
+	for _, r := range records {
+		fmt.Fprintf(w, "%d:%d\t%s\t%s\t%s\t%s[%d]@%d\t%s\n",
+			r.msg.Partition, r.msg.Offset,
+			r.event.FailedAt,
+			r.event.ErrorType,
+			r.event.EventID,
+			r.event.OriginalTopic, r.event.Partition, r.event.Offset,
+			r.event.ErrorMessage,
+		)
+	}
+	w.Flush()
+}
+
+func show(records []record, registry *schema.Registry) {
+	for _, r := range records {
+		fmt.Printf("DLQ record %d:%d\n", r.msg.Partition, r.msg.Offset)
+		fmt.Printf("  failed at:  %s\n", r.event.FailedAt)
+		fmt.Printf("  error type: %s\n", r.event.ErrorType)
+		fmt.Printf("  error:      %s\n", r.event.ErrorMessage)
+		fmt.Printf("  event id:   %s\n", r.event.EventID)
+		fmt.Printf("  origin:     %s[%d]@%d\n", r.event.OriginalTopic, r.event.Partition, r.event.Offset)
+		fmt.Printf("  timestamp:  %s\n", r.event.OriginalTimestamp)
+		fmt.Printf("  group:      %s (attempt %d, host %s)\n", r.event.ConsumerGroup, r.event.Attempt, r.event.Host)
+		fmt.Printf("  key:        %q\n", r.event.Key)
+		for _, h := range r.event.Headers {
+			fmt.Printf("  header:     %s=%q\n", h.Key, h.Value)
+		}
+
+		payload, err := registry.Decode(r.event.Payload)
+		if err != nil {
+			fmt.Printf("  payload:    %d undecodable bytes (%v)\n  %q\n\n", len(r.event.Payload), err, r.event.Payload)
+			continue
+		}
+		pretty, _ := json.MarshalIndent(payload, "  ", "  ")
+		fmt.Printf("  payload:\n  %s\n\n", pretty)
+	}
+}
+
+// replay publishes the original payload, key and headers of every record to
+// its original topic, or to topic when set, tagged with the replay headers.
+// Retry headers are dropped so the replayed message starts with a fresh
+// retry budget.
+func replay(ctx context.Context, b broker.Broker, records []record, topic string, dryRun bool) error {
+	var publisher broker.Publisher
+	if !dryRun {
+		var err error
+		publisher, err = b.Publisher(broker.PublisherConfig{Idempotent: true})
+		if err != nil {
+			return err
+		}
+		defer publisher.Close()
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range records {
+		target := r.event.OriginalTopic
+		if topic != "" {
+			target = topic
+		}
+		if dryRun {
+			fmt.Printf("would replay %d:%d (event %s) to %s\n", r.msg.Partition, r.msg.Offset, r.event.EventID, target)
+			continue
+		}
+
+		key := r.event.Key
+		if key == nil {
+			key = r.msg.Key // records written before the key was stored
+		}
+		var headers []broker.Header
+		for _, h := range r.event.Headers {
+			if !strings.HasPrefix(h.Key, "x-retry-") && !strings.HasPrefix(h.Key, "x-original-") && !strings.HasPrefix(h.Key, "x-dlq-") {
+				headers = append(headers, broker.Header{Key: h.Key, Value: h.Value})
+			}
+		}
+		headers = append(headers,
+			broker.Header{Key: dlq.HeaderReplayedFrom, Value: []byte(r.msg.Topic + "[" + strconv.Itoa(r.msg.Partition) + "]@" + strconv.FormatInt(r.msg.Offset, 10))},
+			broker.Header{Key: dlq.HeaderReplayedAt, Value: []byte(now)},
+		)
+
+		err := publisher.Publish(ctx, broker.Message{
+			Topic:   target,
+			Key:     key,
+			Value:   r.event.Payload,
+			Headers: headers,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to replay %d:%d: %w", r.msg.Partition, r.msg.Offset, err)
+		}
+		fmt.Printf("replayed %d:%d (event %s) to %s\n", r.msg.Partition, r.msg.Offset, r.event.EventID, target)
+	}
+	return nil
+}
@@ -3,10 +3,62 @@ module github.com/dzon2000/eda/consumer
 go 1.25.5
 
 require (
+	github.com/dzon2000/eda/pkg/broker v0.0.0
+	github.com/dzon2000/eda/pkg/chaos v0.0.0
+	github.com/dzon2000/eda/pkg/conf v0.0.0
+	github.com/dzon2000/eda/pkg/health v0.0.0
+	github.com/dzon2000/eda/pkg/logging v0.0.0
+	github.com/dzon2000/eda/pkg/tracing v0.0.0
+	github.com/joho/godotenv v1.5.1
+	github.com/linkedin/goavro/v2 v2.14.1
+	github.com/prometheus/client_golang v1.24.1
+	go.opentelemetry.io/otel v1.38.0
+	go.opentelemetry.io/otel/trace v1.38.0
+)
+
+require (
+	github.com/BurntSushi/toml v1.6.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cenkalti/backoff/v5 v5.0.3 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/dzon2000/eda/pkg/schemaregistry v0.0.0
+	github.com/go-logr/logr v1.4.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/klauspost/compress v1.15.9 // indirect
-	github.com/linkedin/goavro/v2 v2.14.1 // indirect
-	github.com/pierrec/lz4/v4 v4.1.15 // indirect
-	github.com/segmentio/kafka-go v0.4.49 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.27.2 // indirect
+	github.com/klauspost/compress v1.19.2 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/pierrec/lz4/v4 v4.1.26 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.70.1 // indirect
+	github.com/prometheus/procfs v0.21.1 // indirect
+	github.com/twmb/franz-go v1.21.7 // indirect
+	github.com/twmb/franz-go/pkg/kmsg v1.13.1 // indirect
+	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.38.0 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.38.0 // indirect
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.38.0 // indirect
+	go.opentelemetry.io/otel/metric v1.38.0 // indirect
+	go.opentelemetry.io/otel/sdk v1.38.0 // indirect
+	go.opentelemetry.io/proto/otlp v1.7.1 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20250825161204-c5933d9347a5 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250825161204-c5933d9347a5 // indirect
+	google.golang.org/grpc v1.75.0 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace (
+	github.com/dzon2000/eda/pkg/broker => ../../pkg/broker
+	github.com/dzon2000/eda/pkg/chaos => ../../pkg/chaos
+	github.com/dzon2000/eda/pkg/conf => ../../pkg/conf
+	github.com/dzon2000/eda/pkg/health => ../../pkg/health
+	github.com/dzon2000/eda/pkg/logging => ../../pkg/logging
+	github.com/dzon2000/eda/pkg/tracing => ../../pkg/tracing
+)
+
+replace github.com/dzon2000/eda/pkg/schemaregistry => ../../pkg/schemaregistry
@@ -0,0 +1,180 @@
+// Package admin is the consumer's operator HTTP API: pausing and resuming
+// partitions, quarantining poison messages into the DLQ and seeking
+// partitions, so a stuck consumer can be fixed without the Kafka CLI tools.
+// It shares its listener with the metrics and health probes, so the
+// endpoints that change anything require the ADMIN_TOKEN bearer token:
+//
+//	curl -X POST -H "Authorization: Bearer $ADMIN_TOKEN" .../admin/partitions/orders.v1/0/pause
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/consumer"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Handler struct {
+	consumer *consumer.Consumer
+	health   *health.Checker
+	token    string // required by the mutating endpoints, which are disabled without one
+}
+
+func NewHandler(c *consumer.Consumer, checker *health.Checker, token string) *Handler {
+	return &Handler{consumer: c, health: checker, token: token}
+}
+
+func (h *Handler) Router() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", h.Status)
+	mux.HandleFunc("POST /admin/partitions/{topic}/{partition}/pause", h.authorized(h.Pause))
+	mux.HandleFunc("POST /admin/partitions/{topic}/{partition}/resume", h.authorized(h.Resume))
+	mux.HandleFunc("POST /admin/partitions/{topic}/{partition}/quarantine", h.authorized(h.Quarantine))
+	mux.HandleFunc("POST /admin/partitions/{topic}/{partition}/seek", h.authorized(h.Seek))
+	mux.Handle("GET /metrics", promhttp.Handler())
+	h.health.Register(mux)
+	return mux
+}
+
+// authorized lets requests carrying the admin token through to next.
+func (h *Handler) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.token == "" {
+			http.Error(w, "admin API is disabled, set ADMIN_TOKEN to enable it", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+type QuarantineRequest struct {
+	Offset *int64 `json:"offset"`
+}
+
+// SeekRequest takes either an offset or an RFC 3339 timestamp.
+type SeekRequest struct {
+	Offset    *int64     `json:"offset,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+type SeekResponse struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.consumer.Status())
+}
+
+func (h *Handler) Pause(w http.ResponseWriter, r *http.Request) {
+	tp, ok := topicPartition(w, r)
+	if !ok {
+		return
+	}
+	if err := h.consumer.PausePartition(tp); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Resume(w http.ResponseWriter, r *http.Request) {
+	tp, ok := topicPartition(w, r)
+	if !ok {
+		return
+	}
+	if err := h.consumer.ResumePartition(tp); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) Quarantine(w http.ResponseWriter, r *http.Request) {
+	tp, ok := topicPartition(w, r)
+	if !ok {
+		return
+	}
+	var req QuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Offset == nil || *req.Offset < 0 {
+		http.Error(w, "offset is required", http.StatusBadRequest)
+		return
+	}
+	h.consumer.Quarantine(tp, *req.Offset)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) Seek(w http.ResponseWriter, r *http.Request) {
+	tp, ok := topicPartition(w, r)
+	if !ok {
+		return
+	}
+	var req SeekRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var offset int64
+	var err error
+	switch {
+	case req.Offset != nil && req.Timestamp == nil:
+		offset = *req.Offset
+		err = h.consumer.Seek(r.Context(), tp, offset)
+	case req.Timestamp != nil && req.Offset == nil:
+		offset, err = h.consumer.SeekToTime(r.Context(), tp, *req.Timestamp)
+	default:
+		http.Error(w, "exactly one of offset and timestamp is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, SeekResponse{Topic: tp.Topic, Partition: tp.Partition, Offset: offset})
+}
+
+func topicPartition(w http.ResponseWriter, r *http.Request) (broker.TopicPartition, bool) {
+	partition, err := strconv.Atoi(r.PathValue("partition"))
+	if err != nil || partition < 0 {
+		http.Error(w, "invalid partition", http.StatusBadRequest)
+		return broker.TopicPartition{}, false
+	}
+	return broker.TopicPartition{Topic: r.PathValue("topic"), Partition: partition}, true
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, broker.ErrNotAssigned):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, consumer.ErrNotStarted):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func respondJSON(w http.ResponseWriter, httpStatus int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	json.NewEncoder(w).Encode(body)
+}
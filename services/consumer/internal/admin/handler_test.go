@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/consumer/internal/consumer"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/health"
+)
+
+func TestMutatingEndpointsRequireToken(t *testing.T) {
+	c, err := consumer.New(config.KafkaConfig{}, config.ProcessingConfig{}, broker.NewMemory(1), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := func(token string) http.Handler {
+		return NewHandler(c, health.New(time.Second), token).Router()
+	}
+	tests := []struct {
+		name          string
+		token         string
+		method, path  string
+		authorization string
+		want          int
+	}{
+		{"disabled without a token", "", "POST", "/admin/partitions/orders.v1/0/pause", "Bearer ", http.StatusForbidden},
+		{"missing token", "secret", "POST", "/admin/partitions/orders.v1/0/pause", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "POST", "/admin/partitions/orders.v1/0/seek", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", "secret", "POST", "/admin/partitions/orders.v1/0/resume", "secret", http.StatusUnauthorized},
+		{"quarantine", "secret", "POST", "/admin/partitions/orders.v1/0/quarantine", "", http.StatusUnauthorized},
+		// The consumer is not started, so an authorized pause gets as far
+		// as the consumer.
+		{"authorized", "secret", "POST", "/admin/partitions/orders.v1/0/pause", "Bearer secret", http.StatusServiceUnavailable},
+		{"status is open", "secret", "GET", "/admin/status", "", http.StatusOK},
+		{"metrics are open", "secret", "GET", "/metrics", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"offset": 0}`))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			router(tt.token).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
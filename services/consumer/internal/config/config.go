@@ -1,17 +1,70 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 	"time"
+
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
 )
 
 type Config struct {
 	Kafka          KafkaConfig
 	SchemaRegistry SchemaRegistryConfig
-	Environment    string
+	Processing     ProcessingConfig
+	AdminAddr      string // listen address of the admin HTTP API, metrics and health probes
+	// AdminToken is the bearer token the admin API's mutating endpoints
+	// require. They are disabled when it is empty.
+	AdminToken      string
+	Health          HealthConfig
+	Environment     string
+	JournalFile     string // JSON lines of the processed events, see package journal; off when empty
+	Chaos           chaos.Config
+	ShutdownTimeout time.Duration // how long in-flight messages may take to drain
+	Tracing         tracing.Config
+	Logging         logging.Config
+}
+
+// Ordering decides which messages must be processed one after another.
+const (
+	OrderingPartition = "partition" // one partition is processed by one worker at a time
+	OrderingKey       = "key"       // messages with the same key are processed in order
+)
+
+// Commit policies decide when completed offsets are committed. Pending
+// offsets are always committed on partition revocation and on shutdown.
+const (
+	CommitPolicyMessage   = "message"   // after every completed message
+	CommitPolicyCount     = "count"     // after every CommitEvery completed messages
+	CommitPolicyInterval  = "interval"  // every CommitInterval
+	CommitPolicyRebalance = "rebalance" // only on revocation and shutdown
+)
+
+// DLQ failure modes decide what happens to a message that could be neither
+// processed nor dead-lettered.
+const (
+	DLQFailureSkip  = "skip"  // log it and move on, its offset is committed with later ones
+	DLQFailureBlock = "block" // pause its partition and retry the DLQ write until it succeeds
+)
+
+type ProcessingConfig struct {
+	Workers        int
+	Ordering       string
+	QueueSize      int // messages buffered per worker before Fetch blocks
+	CommitPolicy   string
+	CommitEvery    int
+	CommitInterval time.Duration
+
+	DLQFailureMode     string
+	DLQRetryBackoff    time.Duration // first delay between DLQ write attempts in block mode
+	DLQMaxRetryBackoff time.Duration
+
+	DedupWindow        int           // event IDs remembered per partition
+	DedupWarmupTimeout time.Duration // how long rebuilding the window of an assigned partition may take
 }
 
 type KafkaConfig struct {
@@ -21,7 +74,31 @@ type KafkaConfig struct {
 	MinBytes   int
 	MaxBytes   int
 	DLQTopic   string
-	MaxRetries int
+	MaxRetries int // produce retries, and retry topic attempts before a message is dead-lettered
+
+	RetryTopics []RetryTier // in the order a failing message goes through them
+
+	Assignment string
+}
+
+// Assignment strategies for the consumer group.
+const (
+	AssignmentEager       = "eager"              // range/round-robin, every rebalance revokes everything
+	AssignmentCooperative = "cooperative-sticky" // incremental, only moved partitions are revoked
+)
+
+// RetryTier is a retry topic whose messages are processed no earlier than
+// Delay after they failed.
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration // per dependency check
+	// StallTimeout is how long one message may be processed before the
+	// consumer is reported as wedged and fails its liveness probe.
+	StallTimeout time.Duration
 }
 
 type SchemaRegistryConfig struct {
@@ -30,29 +107,70 @@ type SchemaRegistryConfig struct {
 	DLQSchemaID int
 }
 
-func Load() (*Config, error) {
+// Load reads the configuration from the config file, the environment and
+// the command-line arguments args, see package conf. It returns
+// conf.ErrPrinted after --print-config.
+func Load(args []string) (*Config, error) {
+	l, err := conf.New(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 	cfg := &Config{
-		Environment: getEnv("ENVIRONMENT", "development"),
+		Environment:     l.String("ENVIRONMENT", "development"),
+		ShutdownTimeout: l.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		AdminAddr:       l.String("ADMIN_HTTP_ADDR", ":8082"),
+		AdminToken:      l.Secret("ADMIN_TOKEN", ""),
+		JournalFile:     l.String("CONSUMER_JOURNAL_FILE", ""),
+		Health: HealthConfig{
+			CheckTimeout: l.Duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			StallTimeout: l.Duration("HEALTH_STALL_TIMEOUT", 5*time.Minute),
+		},
+		Tracing: tracing.Config{
+			ServiceName: "consumer",
+			Exporter:    l.String("OTEL_TRACES_EXPORTER", tracing.ExporterNone),
+			File:        l.String("OTEL_TRACES_FILE", "traces-consumer.jsonl"),
+		},
 		Kafka: KafkaConfig{
-			Brokers:    getBrokersFromEnv(),
-			Topic:      getEnv("KAFKA_TOPIC", "orders.v1"),
-			GroupID:    getEnv("KAFKA_GROUP_ID", "orders-consumer"),
-			MinBytes:   getEnvAsInt("KAFKA_MIN_BYTES", 1000),
-			MaxBytes:   getEnvAsInt("KAFKA_MAX_BYTES", 10000000),
-			DLQTopic:   getEnv("KAFKA_DLQ_TOPIC", "orders.dlq"),
-			MaxRetries: getEnvAsInt("KAFKA_MAX_RETRIES", 5),
+			Brokers:    l.Strings("KAFKA_BROKERS", []string{"kafka:9092"}),
+			Topic:      l.String("KAFKA_TOPIC", "orders.v1"),
+			GroupID:    l.String("KAFKA_GROUP_ID", "orders-consumer"),
+			MinBytes:   l.Int("KAFKA_MIN_BYTES", 1000),
+			MaxBytes:   l.Int("KAFKA_MAX_BYTES", 10000000),
+			DLQTopic:   l.String("KAFKA_DLQ_TOPIC", "orders.dlq"),
+			MaxRetries: l.Int("KAFKA_MAX_RETRIES", 5),
+			Assignment: l.String("KAFKA_ASSIGNMENT", AssignmentCooperative),
+		},
+		Processing: ProcessingConfig{
+			Workers:            l.Int("CONSUMER_WORKERS", 8),
+			Ordering:           l.String("CONSUMER_ORDERING", OrderingKey),
+			QueueSize:          l.Int("CONSUMER_WORKER_QUEUE_SIZE", 64),
+			CommitPolicy:       l.String("CONSUMER_COMMIT_POLICY", CommitPolicyInterval),
+			CommitEvery:        l.Int("CONSUMER_COMMIT_EVERY", 100),
+			CommitInterval:     l.Duration("CONSUMER_COMMIT_INTERVAL", time.Second),
+			DLQFailureMode:     l.String("CONSUMER_DLQ_FAILURE_MODE", DLQFailureBlock),
+			DLQRetryBackoff:    l.Duration("CONSUMER_DLQ_RETRY_BACKOFF", time.Second),
+			DLQMaxRetryBackoff: l.Duration("CONSUMER_DLQ_MAX_RETRY_BACKOFF", time.Minute),
+			DedupWindow:        l.Int("CONSUMER_DEDUP_WINDOW", 10000),
+			DedupWarmupTimeout: l.Duration("CONSUMER_DEDUP_WARMUP_TIMEOUT", 30*time.Second),
 		},
 		SchemaRegistry: SchemaRegistryConfig{
-			URL:         getEnv("SCHEMA_REGISTRY_URL", "http://schema-registry:8081"),
-			Timeout:     getEnvAsDuration("SCHEMA_REGISTRY_TIMEOUT", 10*time.Second),
-			DLQSchemaID: getEnvAsInt("SCHEMA_REGISTRY_DLQ_SCHEMA_ID", 67),
+			URL:         l.String("SCHEMA_REGISTRY_URL", "http://schema-registry:8081"),
+			Timeout:     l.Duration("SCHEMA_REGISTRY_TIMEOUT", 10*time.Second),
+			DLQSchemaID: l.Int("SCHEMA_REGISTRY_DLQ_SCHEMA_ID", 67),
 		},
 	}
 
-	if err := cfg.Validate(); err != nil {
+	cfg.Kafka.RetryTopics, err = parseRetryTiers(l.String("KAFKA_RETRY_TOPICS", "orders.retry.5s=5s,orders.retry.1m=1m,orders.retry.10m=10m"))
+	l.Check("KAFKA_RETRY_TOPICS", err)
+	cfg.Logging = loadLogging(l)
+	cfg.Chaos = loadChaos(l)
+
+	if err := l.Finish(cfg.Validate); err != nil {
+		if errors.Is(err, conf.ErrPrinted) {
+			return cfg, err
+		}
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
-
 	return cfg, nil
 }
 
@@ -63,38 +181,107 @@ func (c *Config) Validate() error {
 	if c.Kafka.Topic == "" {
 		return fmt.Errorf("Kafka topic is required")
 	}
+	switch c.Kafka.Assignment {
+	case AssignmentEager, AssignmentCooperative:
+	default:
+		return fmt.Errorf("unknown Kafka assignment %q", c.Kafka.Assignment)
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if c.Processing.DedupWindow <= 0 {
+		return fmt.Errorf("consumer dedup window must be positive")
+	}
+	if len(c.Kafka.RetryTopics) > 0 && c.Kafka.MaxRetries <= 0 {
+		return fmt.Errorf("Kafka max retries must be positive when retry topics are configured")
+	}
+	if c.Processing.Workers <= 0 {
+		return fmt.Errorf("consumer workers must be positive")
+	}
+	if c.Processing.QueueSize <= 0 {
+		return fmt.Errorf("consumer worker queue size must be positive")
+	}
+	switch c.Processing.Ordering {
+	case OrderingPartition, OrderingKey:
+	default:
+		return fmt.Errorf("unknown consumer ordering %q", c.Processing.Ordering)
+	}
+	switch c.Processing.CommitPolicy {
+	case CommitPolicyMessage, CommitPolicyRebalance:
+	case CommitPolicyCount:
+		if c.Processing.CommitEvery <= 0 {
+			return fmt.Errorf("consumer commit count must be positive")
+		}
+	case CommitPolicyInterval:
+		if c.Processing.CommitInterval <= 0 {
+			return fmt.Errorf("consumer commit interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown consumer commit policy %q", c.Processing.CommitPolicy)
+	}
+	switch c.Processing.DLQFailureMode {
+	case DLQFailureSkip:
+	case DLQFailureBlock:
+		if c.Processing.DLQRetryBackoff <= 0 || c.Processing.DLQMaxRetryBackoff < c.Processing.DLQRetryBackoff {
+			return fmt.Errorf("consumer DLQ retry backoff must be positive and not exceed the max DLQ retry backoff")
+		}
+	default:
+		return fmt.Errorf("unknown consumer DLQ failure mode %q", c.Processing.DLQFailureMode)
+	}
 	if c.SchemaRegistry.URL == "" {
 		return fmt.Errorf("Schema Registry URL is required")
 	}
-	return nil
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	if c.Health.CheckTimeout <= 0 || c.Health.StallTimeout <= 0 {
+		return fmt.Errorf("health check and stall timeouts must be positive")
 	}
-	return defaultValue
+	return nil
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+// parseRetryTiers parses a comma separated list of topic=delay pairs.
+func parseRetryTiers(value string) ([]RetryTier, error) {
+	var tiers []RetryTier
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		topic, delay, ok := strings.Cut(entry, "=")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("retry topic %q must be topic=delay", entry)
 		}
+		d, err := time.ParseDuration(delay)
+		if err != nil {
+			return nil, fmt.Errorf("retry topic %q: %w", entry, err)
+		}
+		tiers = append(tiers, RetryTier{Topic: topic, Delay: d})
 	}
-	return defaultValue
+	return tiers, nil
 }
 
-func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
-		}
+// loadChaos reads CHAOS_FAULTS, see chaos.ParseRules, and CHAOS_SEED.
+func loadChaos(l *conf.Loader) chaos.Config {
+	rules, err := chaos.ParseRules(l.String("CHAOS_FAULTS", ""))
+	l.Check("CHAOS_FAULTS", err)
+	return chaos.Config{
+		Rules: rules,
+		Seed:  uint64(l.Int("CHAOS_SEED", 0)),
 	}
-	return defaultValue
 }
 
-func getBrokersFromEnv() []string {
-	brokers := getEnv("KAFKA_BROKERS", "kafka:9092")
-	return strings.Split(brokers, ",")
+// loadLogging reads LOG_FORMAT, LOG_LEVEL, LOG_LEVELS (per-logger
+// overrides such as "consumer=debug,dlq=warn") and LOG_REDACT.
+func loadLogging(l *conf.Loader) logging.Config {
+	level, err := logging.ParseLevel(l.String("LOG_LEVEL", "info"))
+	l.Check("LOG_LEVEL", err)
+	levels, err := logging.ParseLevels(l.String("LOG_LEVELS", ""))
+	l.Check("LOG_LEVELS", err)
+	return logging.Config{
+		Format: l.String("LOG_FORMAT", logging.FormatJSON),
+		Level:  level,
+		Levels: levels,
+		Redact: l.Strings("LOG_REDACT", []string{"customerId", "customer_id"}),
+	}
 }
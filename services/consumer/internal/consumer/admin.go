@@ -0,0 +1,169 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/failure"
+	"github.com/dzon2000/eda/consumer/internal/retry"
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// Operator controls behind the admin HTTP API. They act on this instance
+// only, so a partition must be assigned here to be seeked.
+
+var ErrNotStarted = errors.New("consumer is not started")
+
+type Status struct {
+	Paused      []PausedPartition   `json:"paused"`
+	Quarantined []QuarantinedOffset `json:"quarantined"`
+}
+
+type PausedPartition struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Manual    bool   `json:"manual"` // paused through the admin API rather than by a blocked DLQ write
+}
+
+type QuarantinedOffset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+// PausePartition stops consuming the partition until ResumePartition. It
+// does not lift a pause caused by a blocked DLQ write.
+func (c *Consumer) PausePartition(tp broker.TopicPartition) error {
+	if !c.started() {
+		return ErrNotStarted
+	}
+	c.pauseMu.Lock()
+	manual := c.manualPauses[tp]
+	c.manualPauses[tp] = true
+	c.pauseMu.Unlock()
+	if !manual {
+		c.pause(tp)
+	}
+	logger.Info("Partition paused by operator", "topic", tp.Topic, "partition", tp.Partition)
+	return nil
+}
+
+func (c *Consumer) ResumePartition(tp broker.TopicPartition) error {
+	if !c.started() {
+		return ErrNotStarted
+	}
+	c.pauseMu.Lock()
+	manual := c.manualPauses[tp]
+	delete(c.manualPauses, tp)
+	c.pauseMu.Unlock()
+	if manual {
+		c.resume(tp)
+	}
+	logger.Info("Partition resumed by operator", "topic", tp.Topic, "partition", tp.Partition)
+	return nil
+}
+
+// Quarantine sends the message at offset to the DLQ without processing it
+// the next time it is consumed, which skips a poison message. For retried
+// messages offset is their position in the source topic. Quarantines are
+// kept in memory only.
+func (c *Consumer) Quarantine(tp broker.TopicPartition, offset int64) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	q := QuarantinedOffset{Topic: tp.Topic, Partition: tp.Partition, Offset: offset}
+	if !slices.Contains(c.quarantined, q) {
+		c.quarantined = append(c.quarantined, q)
+	}
+	logger.Info("Offset quarantined by operator", "topic", tp.Topic, "partition", tp.Partition, "offset", offset)
+}
+
+// takeQuarantined reports whether msg is quarantined and lifts the
+// quarantine, which only applies once.
+func (c *Consumer) takeQuarantined(msg broker.Message) bool {
+	topic, partition, offset := retry.Origin(msg)
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	before := len(c.quarantined)
+	c.quarantined = slices.DeleteFunc(c.quarantined, func(q QuarantinedOffset) bool {
+		return (q.Topic == msg.Topic && q.Partition == msg.Partition && q.Offset == msg.Offset) ||
+			(q.Topic == topic && q.Partition == partition && q.Offset == offset)
+	})
+	return len(c.quarantined) < before
+}
+
+func (c *Consumer) deadLetterQuarantined(ctx context.Context, msg broker.Message) error {
+	logger.InfoContext(ctx, "Dead-lettering quarantined message", messageAttrs(msg)...)
+	return c.handleProcessingError(ctx, msg, failure.Permanent(failure.ErrQuarantined, nil))
+}
+
+// Seek moves an assigned partition to offset. Messages of the partition in
+// flight are finished, or abandoned when blocked on the DLQ, and committed
+// first; the new position is committed once the next message completes.
+// The partition's dedup window is cleared, so seeking back processes the
+// events again. Retried messages are deduplicated in the window of the
+// partition they were first read from, which seeking a retry topic leaves
+// alone: events processed before are skipped. Seeks run one at a time;
+// messages fetched before are dropped, see fetchedBeforeSeek.
+func (c *Consumer) Seek(ctx context.Context, tp broker.TopicPartition, offset int64) (err error) {
+	if !c.started() {
+		return ErrNotStarted
+	}
+	c.seeks.begin(tp)
+	defer func() { c.seeks.end(tp, offset, err == nil) }()
+	c.pause(tp)
+	defer c.resume(tp)
+
+	partitions := []broker.TopicPartition{tp}
+	c.offsets.Revoke(partitions)
+	if err := c.offsets.Wait(ctx, partitions); err != nil {
+		return fmt.Errorf("failed waiting for in-flight messages: %w", err)
+	}
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	c.commitReadyLocked(ctx)
+	c.offsets.Forget(partitions)
+	if err := c.subscriberFor(tp.Topic).Seek(ctx, tp, offset); err != nil {
+		return err
+	}
+	c.dedup.Forget(partitions)
+	logger.InfoContext(ctx, "Partition seeked by operator", "topic", tp.Topic, "partition", tp.Partition, "offset", offset)
+	return nil
+}
+
+// SeekToTime moves an assigned partition to the first message at or after t
+// and returns the offset it moved to.
+func (c *Consumer) SeekToTime(ctx context.Context, tp broker.TopicPartition, t time.Time) (int64, error) {
+	if !c.started() {
+		return 0, ErrNotStarted
+	}
+	offset, err := c.subscriberFor(tp.Topic).OffsetForTime(ctx, tp, t)
+	if err != nil {
+		return 0, err
+	}
+	return offset, c.Seek(ctx, tp, offset)
+}
+
+func (c *Consumer) Status() Status {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	status := Status{
+		Paused:      make([]PausedPartition, 0, len(c.pauses)),
+		Quarantined: slices.Clone(c.quarantined),
+	}
+	for tp := range c.pauses {
+		status.Paused = append(status.Paused, PausedPartition{Topic: tp.Topic, Partition: tp.Partition, Manual: c.manualPauses[tp]})
+	}
+	return status
+}
+
+func (c *Consumer) started() bool {
+	select {
+	case <-c.ready:
+		return true
+	default:
+		return false
+	}
+}
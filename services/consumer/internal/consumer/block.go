@@ -0,0 +1,124 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/metrics"
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// errNotDeadLettered is returned for a message that was neither processed
+// nor dead-lettered. Its offset must not be committed.
+var errNotDeadLettered = errors.New("message was not dead-lettered")
+
+// dlqBlockedError is returned in block mode for a message whose processing
+// and DLQ write both failed. The caller keeps retrying the DLQ write: a
+// worker parks the message, a retry tier blocks on it.
+type dlqBlockedError struct {
+	cause  error // why processing failed
+	dlqErr error
+}
+
+func (e *dlqBlockedError) Error() string {
+	return fmt.Sprintf("DLQ write failed: %v, processing failed: %v", e.dlqErr, e.cause)
+}
+
+func (e *dlqBlockedError) Unwrap() []error {
+	return []error{e.dlqErr, e.cause}
+}
+
+// park hands a message that could not be dead-lettered over to a goroutine
+// of its own, so its worker goes on with the other partitions. The
+// message's partition is paused until the DLQ write succeeds, and its
+// offset is not committed before that, so no later offset overtakes it.
+// Messages of the partition already queued are still processed. The
+// message is abandoned when the partition is revoked or the consumer is
+// stopped; the next owner then receives it again.
+func (c *Consumer) park(msg broker.Message, blocked *dlqBlockedError) {
+	tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	logger.Error("DLQ write failed, pausing partition until it succeeds",
+		append(messageAttrs(msg), "alert", true, "error", blocked.dlqErr)...)
+	metrics.DLQWriteFailures.Inc()
+	c.dlqBlocked.Add(1)
+	c.pause(tp)
+	c.parked.Go(func() {
+		defer c.dlqBlocked.Add(-1)
+		defer c.resume(tp)
+		c.finish(msg, c.retryDeadLetter(c.offsets.Context(msg), msg, blocked))
+	})
+}
+
+// blockUntilDeadLettered retries the DLQ write of a retry tier's message
+// until it succeeds, keeping its partition paused. A retry tier handles
+// one message at a time and commits each, so it cannot go on past it.
+func (c *Consumer) blockUntilDeadLettered(ctx context.Context, msg broker.Message, blocked *dlqBlockedError) error {
+	tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	logger.Error("DLQ write failed, pausing partition until it succeeds",
+		append(messageAttrs(msg), "alert", true, "error", blocked.dlqErr)...)
+	metrics.DLQWriteFailures.Inc()
+	c.dlqBlocked.Add(1)
+	defer c.dlqBlocked.Add(-1)
+	c.pause(tp)
+	defer c.resume(tp)
+	return c.retryDeadLetter(ctx, msg, blocked)
+}
+
+// retryDeadLetter retries the DLQ write with backoff until it succeeds or
+// ctx is done.
+func (c *Consumer) retryDeadLetter(ctx context.Context, msg broker.Message, blocked *dlqBlockedError) error {
+	log := logger.With(messageAttrs(msg)...)
+	delay := c.processing.DLQRetryBackoff
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s[%d]@%d: %w", errNotDeadLettered, msg.Topic, msg.Partition, msg.Offset, ctx.Err())
+		case <-time.After(delay):
+		}
+
+		dlqErr := c.dlqProducer.Send(ctx, msg, blocked.cause)
+		if dlqErr == nil {
+			log.Info("Dead-lettered after retrying, resuming partition", "attempts", attempt)
+			return nil
+		}
+		metrics.DLQWriteFailures.Inc()
+		log.Warn("DLQ write retry failed", "attempt", attempt, "error", dlqErr)
+		delay = min(delay*2, c.processing.DLQMaxRetryBackoff)
+	}
+}
+
+// pause stops fetching from tp. Several messages of one partition can be
+// blocked at once, so pauses are counted and the partition resumes when
+// the last of them is resolved.
+func (c *Consumer) pause(tp broker.TopicPartition) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	c.pauses[tp]++
+	if c.pauses[tp] == 1 {
+		c.subscriberFor(tp.Topic).Pause(tp)
+		metrics.PausedPartitions.Inc()
+	}
+}
+
+func (c *Consumer) resume(tp broker.TopicPartition) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	c.pauses[tp]--
+	if c.pauses[tp] == 0 {
+		delete(c.pauses, tp)
+		c.subscriberFor(tp.Topic).Resume(tp)
+		metrics.PausedPartitions.Dec()
+	}
+}
+
+// subscriberFor returns the subscriber a topic is consumed by.
+func (c *Consumer) subscriberFor(topic string) broker.Subscriber {
+	for i, tier := range c.kafkaConfig.RetryTopics {
+		if tier.Topic == topic {
+			return c.retrySubscribers[i]
+		}
+	}
+	return c.subscriber
+}
@@ -0,0 +1,43 @@
+package consumer
+
+import (
+	"github.com/dzon2000/eda/consumer/internal/config"
+)
+
+// commitPolicy decides when the completed offsets tracked by offsetTracker
+// are committed. Committing after every message is the safest choice but
+// costs a broker round trip per message; the other policies trade a larger
+// window of redelivered messages after a crash for throughput. The
+// deduplicator absorbs the redeliveries.
+type commitPolicy struct {
+	config  config.ProcessingConfig
+	pending int // completed messages since the last commit, guarded by Consumer.commitMu
+}
+
+func newCommitPolicy(cfg config.ProcessingConfig) *commitPolicy {
+	return &commitPolicy{config: cfg}
+}
+
+// Completed records a finished message and reports whether the pending
+// offsets should be committed now.
+func (p *commitPolicy) Completed() bool {
+	p.pending++
+	switch p.config.CommitPolicy {
+	case config.CommitPolicyMessage:
+		return true
+	case config.CommitPolicyCount:
+		return p.pending >= p.config.CommitEvery
+	default:
+		return false
+	}
+}
+
+// Periodic reports whether pending offsets are committed on a timer.
+func (p *commitPolicy) Periodic() bool {
+	return p.config.CommitPolicy == config.CommitPolicyInterval
+}
+
+// Committed resets the count of pending messages after a commit.
+func (p *commitPolicy) Committed() {
+	p.pending = 0
+}
@@ -3,104 +3,392 @@ package consumer
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dzon2000/eda/consumer/internal/config"
 	"github.com/dzon2000/eda/consumer/internal/deduplicator"
 	"github.com/dzon2000/eda/consumer/internal/dlq"
 	"github.com/dzon2000/eda/consumer/internal/events"
+	"github.com/dzon2000/eda/consumer/internal/failure"
+	"github.com/dzon2000/eda/consumer/internal/metrics"
 	"github.com/dzon2000/eda/consumer/internal/schema"
-	"github.com/segmentio/kafka-go"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var (
+	tracer = otel.Tracer("github.com/dzon2000/eda/consumer/internal/consumer")
+	logger = logging.Logger("consumer")
+)
+
+// messageAttrs are the fields every log record about a message carries.
+func messageAttrs(msg broker.Message) []any {
+	attrs := []any{"topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset}
+	if id, ok := msg.Header("event_id"); ok {
+		attrs = append(attrs, "event_id", string(id))
+	}
+	return attrs
+}
+
 type Consumer struct {
 	kafkaConfig config.KafkaConfig
-	reader      *kafka.Reader
+	processing  config.ProcessingConfig
+	broker      broker.Broker
+	subscriber  broker.Subscriber
 	dedup       *deduplicator.Deduplicator
 	dlqProducer dlq.DLQProducer
 	registry    *schema.Registry
+
+	retryPublisher   broker.Publisher
+	retrySubscribers []broker.Subscriber
+
+	workers  *workerPool
+	offsets  *offsetTracker
+	seeks    *seekTracker
+	policy   *commitPolicy
+	commitMu sync.Mutex // serializes commits so a partition's offset never moves backwards
+
+	pauseMu      sync.Mutex
+	pauses       map[broker.TopicPartition]int // pause holders per paused partition
+	manualPauses map[broker.TopicPartition]bool
+	quarantined  []QuarantinedOffset
+
+	watchdog   health.Watchdog // messages in flight
+	dlqBlocked atomic.Int64    // messages waiting for a DLQ write to succeed
+	parked     sync.WaitGroup  // messages parked off their worker, see park
+
+	assignedHooks  []RebalanceHook
+	revokedHooks   []RebalanceHook
+	processedHooks []ProcessedHook
+
+	// workCtx outlives the context passed to Start so the messages in flight
+	// can finish and commit during shutdown. Stop cancels it once the drain
+	// timeout expires.
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	ready      chan struct{} // closed once Start subscribed
+	done       chan struct{}
+	stopOnce   sync.Once
+	stopErr    error
 }
 
-func New(kafkaConfig config.KafkaConfig, registry *schema.Registry, dlqProducer dlq.DLQProducer) (*Consumer, error) {
+func New(kafkaConfig config.KafkaConfig, processing config.ProcessingConfig, b broker.Broker, registry *schema.Registry, dlqProducer dlq.DLQProducer) (*Consumer, error) {
+	retryPublisher, err := b.Publisher(broker.PublisherConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retry publisher: %w", err)
+	}
+	workCtx, cancelWork := context.WithCancel(context.Background())
 	return &Consumer{
-		kafkaConfig: kafkaConfig,
-		dedup:       deduplicator.New(),
-		dlqProducer: dlqProducer,
-		registry:    registry,
+		kafkaConfig:    kafkaConfig,
+		processing:     processing,
+		broker:         b,
+		offsets:        newOffsetTracker(workCtx),
+		seeks:          newSeekTracker(),
+		pauses:         make(map[broker.TopicPartition]int),
+		manualPauses:   make(map[broker.TopicPartition]bool),
+		policy:         newCommitPolicy(processing),
+		dedup:          deduplicator.New(processing.DedupWindow),
+		dlqProducer:    dlqProducer,
+		registry:       registry,
+		retryPublisher: retryPublisher,
+		workCtx:        workCtx,
+		cancelWork:     cancelWork,
+		ready:          make(chan struct{}),
+		done:           make(chan struct{}),
 	}, nil
 }
 
-func (c *Consumer) Start() error {
-	c.reader = kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        c.kafkaConfig.Brokers,
-		Topic:          c.kafkaConfig.Topic,
-		GroupID:        c.kafkaConfig.GroupID,
-		MinBytes:       c.kafkaConfig.MinBytes,
-		MaxBytes:       c.kafkaConfig.MaxBytes,
-		CommitInterval: 0, // manual commits
+// Start consumes until ctx is cancelled. Messages already handed to the
+// workers when that happens are finished and committed before Start returns.
+func (c *Consumer) Start(ctx context.Context) error {
+	defer close(c.done)
+
+	subscriber, err := c.broker.Subscribe(broker.SubscriberConfig{
+		Topics:      []string{c.kafkaConfig.Topic},
+		GroupID:     c.kafkaConfig.GroupID,
+		Cooperative: c.kafkaConfig.Assignment == config.AssignmentCooperative,
+		OnAssigned:  c.onAssigned,
+		OnRevoked:   c.onRevoked,
 	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", c.kafkaConfig.Topic, err)
+	}
+	c.subscriber = subscriber
+
+	// Each retry tier is its own consumer group so a long delay on one tier
+	// never holds back another.
+	var retries sync.WaitGroup
+	defer retries.Wait()
+	retryCtx, cancelRetries := context.WithCancel(ctx)
+	defer cancelRetries()
+	for _, tier := range c.kafkaConfig.RetryTopics {
+		retrySubscriber, err := c.broker.Subscribe(broker.SubscriberConfig{
+			Topics:    []string{tier.Topic},
+			GroupID:   c.kafkaConfig.GroupID + "." + tier.Topic,
+			OnRevoked: c.onRetryRevoked,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", tier.Topic, err)
+		}
+		c.retrySubscribers = append(c.retrySubscribers, retrySubscriber)
+		retries.Go(func() { c.consumeRetryTier(retryCtx, retrySubscriber, tier) })
+	}
+
+	c.workers = newWorkerPool(c.processing, c.process)
+	defer c.drain()
+	close(c.ready)
+	if c.policy.Periodic() {
+		stopTicker := c.commitPeriodically(c.processing.CommitInterval)
+		defer stopTicker()
+	}
+
 	for {
-		ctx := context.Background()
-		msg, err := c.reader.FetchMessage(ctx)
+		gen := c.seeks.generation()
+		msg, err := c.subscriber.Fetch(ctx)
+		if ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+			return nil
+		}
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
+			logger.ErrorContext(ctx, "Error reading message", "error", err)
 			continue // Don't fatal, keep running
 		}
-		if err := c.processMessage(ctx, msg); err != nil {
-			log.Printf("Failed to process message: %v", err)
+		if c.fetchedBeforeSeek(ctx, c.subscriber, msg, gen) {
+			continue
 		}
+		metrics.ObserveLag(msg)
+		c.offsets.Track(msg)
+		c.workers.Dispatch(msg)
 	}
 }
 
-func (c *Consumer) processMessage(ctx context.Context, msg kafka.Message) error {
-	orderEvent, err := c.handleMessage(msg.Value)
+// process runs on a worker goroutine.
+func (c *Consumer) process(msg broker.Message) {
+	start := time.Now()
+	c.watchdog.Begin(keyOf(msg))
+	err := c.processMessage(c.workCtx, msg)
+	c.watchdog.End(keyOf(msg))
+	metrics.ProcessingDuration.WithLabelValues(msg.Topic).Observe(time.Since(start).Seconds())
+	var blocked *dlqBlockedError
+	if errors.As(err, &blocked) {
+		c.park(msg, blocked)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to process message", append(messageAttrs(msg), "error", err)...)
+	}
+	c.finish(msg, err)
+}
+
+// finish completes a message, or abandons it when it was neither processed
+// nor dead-lettered, and commits according to the commit policy.
+func (c *Consumer) finish(msg broker.Message, err error) {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	if errors.Is(err, errNotDeadLettered) {
+		c.offsets.Abandon(msg)
+		return
+	}
+	// In skip mode a message that failed even the DLQ counts as done: the
+	// consumer moves past it.
+	c.offsets.Done(msg)
+	if c.policy.Completed() {
+		c.commitReadyLocked(c.workCtx)
+	}
+}
+
+// commitPeriodically commits the completed offsets on every tick until the
+// returned function is called.
+func (c *Consumer) commitPeriodically(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				c.commitMu.Lock()
+				c.commitReadyLocked(c.workCtx)
+				c.commitMu.Unlock()
+			}
+		}
+	})
+	return func() {
+		ticker.Stop()
+		close(quit)
+		wg.Wait()
+	}
+}
+
+// drain waits for the workers to finish the queued messages, and for the
+// parked ones to be dead-lettered or abandoned, and commits them, whatever
+// the commit policy.
+func (c *Consumer) drain() {
+	c.workers.Close()
+	c.parked.Wait()
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	c.commitReadyLocked(c.workCtx)
+}
+
+// ProcessedHook is called with every event the consumer processed, after
+// duplicates were dropped. An error fails the message like any processing
+// error, see failure.Handler; the hooks run again on its retry.
+type ProcessedHook func(ctx context.Context, event *events.OrderCreatedEvent, msg broker.Message) error
+
+// OnProcessed registers a hook that runs after an event was processed, e.g.
+// to record it for an external check. It must be called before Start.
+func (c *Consumer) OnProcessed(hook ProcessedHook) {
+	c.processedHooks = append(c.processedHooks, hook)
+}
+
+// processMessage continues the trace whose context the producer put in the
+// message headers.
+func (c *Consumer) processMessage(ctx context.Context, msg broker.Message) (err error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, msg), "orders.process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", msg.Topic),
+			attribute.Int("messaging.destination.partition.id", msg.Partition),
+			attribute.Int64("messaging.kafka.message.offset", msg.Offset),
+		))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	if c.takeQuarantined(msg) {
+		return c.deadLetterQuarantined(ctx, msg)
+	}
+	log := logger.With(messageAttrs(msg)...)
+	if from, ok := msg.Header(dlq.HeaderReplayedFrom); ok {
+		log.InfoContext(ctx, "Processing message replayed from DLQ", "dlq_record", string(from))
+	}
+	orderEvent, err := c.handleMessage(ctx, msg.Value)
 	if err != nil {
 		return c.handleProcessingError(ctx, msg, err)
 	}
 
-	if c.dedup.Seen(orderEvent.EventID) {
-		log.Printf("Duplicate event detected: %s", orderEvent.EventID)
-		return c.commitMessage(ctx, msg)
+	span.SetAttributes(attribute.String("event.id", orderEvent.EventID))
+	log = log.With("order_id", orderEvent.OrderID)
+	if c.seen(msg, orderEvent.EventID) {
+		span.SetAttributes(attribute.Bool("event.duplicate", true))
+		metrics.DedupHits.Inc()
+		log.InfoContext(ctx, "Duplicate event skipped")
+		return nil
 	}
 
-	log.Printf("Processed OrderCreated event: %+v", orderEvent)
-	return c.commitMessage(ctx, msg)
+	for _, hook := range c.processedHooks {
+		if err := hook(ctx, orderEvent, msg); err != nil {
+			// The retry must not be skipped as a duplicate.
+			c.dedup.Remove(dedupPartition(msg), orderEvent.EventID)
+			return c.handleProcessingError(ctx, msg, failure.Handler(err))
+		}
+	}
+	log.InfoContext(ctx, "Processed OrderCreated event")
+	return nil
 }
 
-func (c *Consumer) handleProcessingError(ctx context.Context, msg kafka.Message, err error) error {
-	log.Printf("Processing error: %v", err)
+func (c *Consumer) handleProcessingError(ctx context.Context, msg broker.Message, err error) error {
+	log := logger.With(messageAttrs(msg)...)
+	log.WarnContext(ctx, "Processing failed", "error", err, "error_type", failure.Classify(err), "retryable", failure.IsRetryable(err))
+	// Recorded without failing the span: a retried or dead-lettered message
+	// is handled.
+	trace.SpanFromContext(ctx).RecordError(err)
+
+	scheduled, retryErr := c.scheduleRetry(ctx, msg, err)
+	if retryErr != nil {
+		log.ErrorContext(ctx, "Failed to schedule retry, dead-lettering instead", "error", retryErr)
+	}
+	if scheduled {
+		return nil
+	}
 
 	if dlqErr := c.dlqProducer.Send(ctx, msg, err); dlqErr != nil {
-		log.Printf("Failed to send to DLQ: %v", dlqErr)
+		if c.processing.DLQFailureMode == config.DLQFailureBlock {
+			return &dlqBlockedError{cause: err, dlqErr: dlqErr}
+		}
+		log.ErrorContext(ctx, "Failed to send to DLQ", "error", dlqErr)
 		return fmt.Errorf("both processing and DLQ failed: %w", err)
 	}
 
 	// Successfully sent to DLQ, commit to avoid reprocessing
-	return c.commitMessage(ctx, msg)
+	return nil
 }
 
-func (c *Consumer) commitMessage(ctx context.Context, msg kafka.Message) error {
-	if err := c.reader.CommitMessages(ctx, msg); err != nil {
-		log.Printf("Failed to commit message: %v", err)
-		return err
+// commitReadyLocked commits the completed prefix of every partition, in one
+// request per group. The caller must hold commitMu.
+func (c *Consumer) commitReadyLocked(ctx context.Context) {
+	msgs := c.offsets.Ready()
+	c.policy.Committed()
+	if len(msgs) == 0 {
+		return
+	}
+	ctx, span := tracer.Start(ctx, "kafka.commit", trace.WithAttributes(attribute.Int("messaging.batch.message_count", len(msgs))))
+	defer span.End()
+	bySubscriber := make(map[broker.Subscriber][]broker.Message)
+	for _, msg := range msgs {
+		subscriber := c.subscriberFor(msg.Topic)
+		bySubscriber[subscriber] = append(bySubscriber[subscriber], msg)
+	}
+	var errs []error
+	for subscriber, msgs := range bySubscriber {
+		errs = append(errs, subscriber.Commit(ctx, msgs...))
+	}
+	if err := tracing.Fail(span, errors.Join(errs...)); err != nil {
+		logger.ErrorContext(ctx, "Failed to commit messages", "error", err)
 	}
-	return nil
 }
 
-func (c *Consumer) Stop() error {
-	c.dlqProducer.Close()
-	return c.reader.Close()
+// Stop waits for Start to return, cancelling the messages in flight if ctx
+// expires first, then leaves the groups and flushes the retry and DLQ
+// producers. It must be called after the context passed to Start is
+// cancelled and is safe to call more than once.
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			logger.Warn("Drain timeout exceeded, cancelling in-flight messages")
+			c.cancelWork()
+			<-c.done
+		}
+		c.cancelWork()
+
+		var errs []error
+		if c.subscriber != nil {
+			errs = append(errs, c.subscriber.Close())
+		}
+		for _, retrySubscriber := range c.retrySubscribers {
+			errs = append(errs, retrySubscriber.Close())
+		}
+		errs = append(errs, c.retryPublisher.Close(), c.dlqProducer.Close())
+		c.stopErr = errors.Join(errs...)
+	})
+	return c.stopErr
 }
 
-func (c *Consumer) handleMessage(value []byte) (*events.OrderCreatedEvent, error) {
+// handleMessage decodes and validates an OrderCreated event. Every error it
+// returns belongs to the failure taxonomy.
+func (c *Consumer) handleMessage(ctx context.Context, value []byte) (*events.OrderCreatedEvent, error) {
 	if len(value) < 5 {
-		return nil, fmt.Errorf("invalid message")
+		return nil, failure.Permanent(failure.ErrInvalidFrame, fmt.Errorf("message is %d bytes long", len(value)))
 	}
 
 	// 1. Magic byte
 	if value[0] != 0 {
-		return nil, fmt.Errorf("unknown magic byte")
+		return nil, failure.Permanent(failure.ErrInvalidFrame, fmt.Errorf("unknown magic byte %d", value[0]))
 	}
 
 	// 2. Schema ID
@@ -109,18 +397,35 @@ func (c *Consumer) handleMessage(value []byte) (*events.OrderCreatedEvent, error
 	// 3. Avro payload
 	avroPayload := value[5:]
 
+	_, schemaSpan := tracer.Start(ctx, "schema.get_codec", trace.WithAttributes(attribute.Int("schema.id", schemaID)))
 	codec, err := c.registry.GetCodec(schemaID)
+	tracing.Fail(schemaSpan, err)
+	schemaSpan.End()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get codec for schema ID %d: %w", schemaID, err)
+		err = fmt.Errorf("failed to get codec for schema ID %d: %w", schemaID, err)
+		if errors.Is(err, schema.ErrSchemaNotFound) {
+			return nil, failure.Permanent(failure.ErrUnknownSchema, err)
+		}
+		// The registry being unreachable says nothing about the message.
+		return nil, failure.Transient(failure.ErrUnknownSchema, err)
 	}
 
 	encoder, _ := schema.NewEncoder(codec, schemaID)
 
+	_, decodeSpan := tracer.Start(ctx, "avro.decode")
 	payload, err := encoder.Decode(schemaID, avroPayload)
+	tracing.Fail(decodeSpan, err)
+	decodeSpan.End()
+	if err != nil {
+		return nil, failure.Permanent(failure.ErrDeserialize, err)
+	}
+	logger.DebugContext(ctx, "Decoded message", "schema_id", schemaID, "payload", payload)
+	orderEvent, err := events.ParseOrderCreated(payload.(map[string]interface{}))
 	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize message: %w", err)
+		return nil, failure.Permanent(failure.ErrDeserialize, err)
+	}
+	if err := orderEvent.Validate(); err != nil {
+		return nil, failure.Permanent(failure.ErrValidation, err)
 	}
-	log.Printf("Received message: %v", payload)
-	orderEvent := events.ParseOrderCreated(payload.(map[string]interface{}))
 	return orderEvent, nil
 }
@@ -0,0 +1,555 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/consumer/internal/dlq"
+	"github.com/dzon2000/eda/consumer/internal/events"
+	"github.com/dzon2000/eda/consumer/internal/failure"
+	"github.com/dzon2000/eda/consumer/internal/schema"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/schemaregistry"
+	"github.com/google/uuid"
+	"github.com/linkedin/goavro/v2"
+)
+
+const (
+	ordersTopic = "orders.v1"
+	dlqTopic    = "orders.dlq"
+	retryTopic  = "orders.retry"
+)
+
+// testEnv runs consumers against the in-memory broker and an in-process
+// schema registry, so the flow from the orders topic to the DLQ runs under
+// plain go test.
+type testEnv struct {
+	t            *testing.T
+	broker       *broker.Memory
+	registryURL  string
+	registryDown atomic.Bool // the registry answers 503 while set
+	dlqDown      atomic.Bool // DLQ writes fail while set
+	orderSchema  int
+	dlqSchema    int
+	kafka        config.KafkaConfig
+	processing   config.ProcessingConfig
+	// handlerErr, when set, is called before an event is recorded as
+	// processed and fails its processing by returning an error.
+	handlerErr func(event *events.OrderCreatedEvent) error
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	registry, err := schemaregistry.New(schemaregistry.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &testEnv{
+		t:      t,
+		broker: broker.NewMemory(3),
+		kafka: config.KafkaConfig{
+			Topic:       ordersTopic,
+			GroupID:     "orders-consumer",
+			DLQTopic:    dlqTopic,
+			MaxRetries:  3,
+			RetryTopics: []config.RetryTier{{Topic: retryTopic, Delay: 200 * time.Millisecond}},
+			Assignment:  config.AssignmentCooperative,
+		},
+		processing: config.ProcessingConfig{
+			Workers:            4,
+			Ordering:           config.OrderingKey,
+			QueueSize:          8,
+			CommitPolicy:       config.CommitPolicyMessage,
+			DLQFailureMode:     config.DLQFailureBlock,
+			DLQRetryBackoff:    10 * time.Millisecond,
+			DLQMaxRetryBackoff: 50 * time.Millisecond,
+			DedupWindow:        100,
+			DedupWarmupTimeout: time.Second,
+		},
+	}
+	handler := registry.Handler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e.registryDown.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	e.registryURL = server.URL
+	e.orderSchema = e.register(registry, ordersTopic+"-value", "order-created.avsc")
+	e.dlqSchema = e.register(registry, dlqTopic+"-value", "order-dlq-event.avsc")
+	return e
+}
+
+func (e *testEnv) register(registry *schemaregistry.Registry, subject, file string) int {
+	e.t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "schemas", file))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	id, err := registry.Register(subject, string(b))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return id
+}
+
+// order is an OrderCreated event; a discount above the amount fails the
+// consumer's validation.
+type order struct {
+	eventID, orderID string
+	amount           float64
+	discount         float64
+}
+
+func newOrder() order {
+	return order{eventID: uuid.NewString(), orderID: uuid.NewString(), amount: 100}
+}
+
+// publish encodes the orders with the OrderCreated schema and publishes
+// them keyed by order ID, as the outbox producer does.
+func (e *testEnv) publish(orders ...order) {
+	e.t.Helper()
+	b, err := os.ReadFile(filepath.Join("..", "..", "..", "..", "schemas", "order-created.avsc"))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	codec, err := goavro.NewCodec(string(b))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	encoder, _ := schema.NewEncoder(codec, e.orderSchema)
+	var msgs []broker.Message
+	for _, o := range orders {
+		record := map[string]any{
+			"eventId":    o.eventID,
+			"orderId":    o.orderID,
+			"customerId": uuid.NewString(),
+			"amount":     o.amount,
+			"createdAt":  time.Now().UTC().Format(time.RFC3339),
+			"discount":   goavro.Union("double", o.discount),
+		}
+		value, err := encoder.Encode(record)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		msgs = append(msgs, broker.Message{
+			Key:     []byte(o.orderID),
+			Value:   value,
+			Headers: []broker.Header{{Key: "event_id", Value: []byte(o.eventID)}},
+		})
+	}
+	e.publishRaw(msgs...)
+}
+
+func (e *testEnv) publishRaw(msgs ...broker.Message) {
+	e.t.Helper()
+	p, err := e.broker.Publisher(broker.PublisherConfig{Topic: ordersTopic})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish(context.Background(), msgs...); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// running is a started consumer.
+type running struct {
+	*Consumer
+	cancel context.CancelFunc
+	errc   chan error
+
+	mu        sync.Mutex
+	processed []string // event IDs
+}
+
+func (r *running) processedIDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.processed)
+}
+
+// start runs a consumer, with a schema cache of its own, until the test
+// ends or stop is called.
+func (e *testEnv) start() *running {
+	e.t.Helper()
+	registry := schema.New(config.SchemaRegistryConfig{URL: e.registryURL, Timeout: time.Second}, nil)
+	codec, err := registry.GetCodec(e.dlqSchema)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	encoder, _ := schema.NewEncoder(codec, e.dlqSchema)
+	publisher, err := e.broker.Publisher(broker.PublisherConfig{Topic: dlqTopic})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	dlqProducer := &flakyDLQ{DLQProducer: dlq.NewProducer(publisher, encoder, registry, e.kafka.GroupID), down: &e.dlqDown}
+	c, err := New(e.kafka, e.processing, e.broker, registry, dlqProducer)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	r := &running{Consumer: c, errc: make(chan error, 1)}
+	c.OnProcessed(func(ctx context.Context, event *events.OrderCreatedEvent, msg broker.Message) error {
+		if e.handlerErr != nil {
+			if err := e.handlerErr(event); err != nil {
+				return err
+			}
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.processed = append(r.processed, event.EventID)
+		return nil
+	})
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	go func() { r.errc <- c.Start(ctx) }()
+	<-c.ready
+	e.t.Cleanup(r.stop)
+	return r
+}
+
+// flakyDLQ fails DLQ writes while down is set.
+type flakyDLQ struct {
+	dlq.DLQProducer
+	down *atomic.Bool
+}
+
+func (d *flakyDLQ) Send(ctx context.Context, msg broker.Message, err error) error {
+	if d.down.Load() {
+		return errors.New("DLQ unavailable")
+	}
+	return d.DLQProducer.Send(ctx, msg, err)
+}
+
+func (r *running) stop() {
+	r.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.Stop(ctx)
+}
+
+// dlq decodes the records in the DLQ.
+func (e *testEnv) dlq() []map[string]any {
+	e.t.Helper()
+	registry := schema.New(config.SchemaRegistryConfig{URL: e.registryURL, Timeout: time.Second}, nil)
+	var records []map[string]any
+	for _, msg := range e.broker.Messages(dlqTopic) {
+		record, err := registry.Decode(msg.Value)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// waitFor polls cond until it holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// committedAll reports whether the group committed every message of the
+// orders topic.
+func (e *testEnv) committedAll() bool {
+	end := make(map[int]int64)
+	for _, msg := range e.broker.Messages(ordersTopic) {
+		end[msg.Partition] = msg.Offset + 1
+	}
+	for partition, offset := range end {
+		if committed, _ := e.broker.Committed(e.kafka.GroupID, ordersTopic, partition); committed != offset {
+			return false
+		}
+	}
+	return true
+}
+
+func TestOrdersAreProcessedOrDeadLettered(t *testing.T) {
+	e := newTestEnv(t)
+	var valid []order
+	for range 30 {
+		valid = append(valid, newOrder())
+	}
+	invalid := newOrder()
+	invalid.discount = invalid.amount + 1
+	e.publish(valid...)
+	e.publish(invalid)
+	e.publishRaw(broker.Message{Key: []byte("poison"), Value: []byte("not an avro record")})
+	e.publish(valid[0]) // redelivered by the outbox producer
+
+	c := e.start()
+	waitFor(t, "every message to be committed", e.committedAll)
+	waitFor(t, "two DLQ records", func() bool { return len(e.broker.Messages(dlqTopic)) == 2 })
+
+	processed := c.processedIDs()
+	if len(processed) != len(valid) {
+		t.Errorf("processed %d events, want %d", len(processed), len(valid))
+	}
+	for _, o := range valid {
+		if !slices.Contains(processed, o.eventID) {
+			t.Errorf("event %s was not processed", o.eventID)
+		}
+	}
+
+	errorTypes := make(map[string]any)
+	for _, record := range e.dlq() {
+		errorTypes[record["errorType"].(string)] = record["eventId"]
+	}
+	if got := errorTypes["validation_error"]; got == nil || got.(map[string]any)["string"] != invalid.eventID {
+		t.Errorf("validation_error record has eventId %v, want %s", got, invalid.eventID)
+	}
+	if _, ok := errorTypes["invalid_message"]; !ok {
+		t.Errorf("DLQ records %v, want an invalid_message one", errorTypes)
+	}
+}
+
+// A transient failure, the registry being down, goes through the retry
+// topic and is processed once the registry is back.
+func TestTransientFailureIsRetried(t *testing.T) {
+	e := newTestEnv(t)
+	c := e.start()
+	e.registryDown.Store(true)
+	o := newOrder()
+	e.publish(o)
+	waitFor(t, "the retry to be scheduled", func() bool { return len(e.broker.Messages(retryTopic)) == 1 })
+	e.registryDown.Store(false)
+
+	waitFor(t, "the retried event to be processed", func() bool { return len(c.processedIDs()) == 1 })
+	if n := len(e.broker.Messages(dlqTopic)); n != 0 {
+		t.Errorf("%d DLQ records, want none", n)
+	}
+}
+
+// A failing handler is retried, and its event is not skipped as a duplicate
+// on the retry.
+func TestHandlerFailureIsRetried(t *testing.T) {
+	e := newTestEnv(t)
+	var failures atomic.Int32
+	e.handlerErr = func(event *events.OrderCreatedEvent) error {
+		if failures.Add(1) == 1 {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}
+	c := e.start()
+	o := newOrder()
+	e.publish(o)
+
+	waitFor(t, "the retried event to be processed", func() bool { return len(c.processedIDs()) == 1 })
+	if got := c.processedIDs()[0]; got != o.eventID {
+		t.Errorf("processed %s, want %s", got, o.eventID)
+	}
+	if n := len(e.broker.Messages(retryTopic)); n != 1 {
+		t.Errorf("%d retries scheduled, want 1", n)
+	}
+}
+
+// A message that cannot be dead-lettered holds back its own partition only:
+// the single worker goes on with the other partitions meanwhile.
+func TestDLQOutageBlocksOnlyItsPartition(t *testing.T) {
+	e := newTestEnv(t)
+	e.processing.Workers = 1
+	e.processing.Ordering = config.OrderingPartition
+	e.dlqDown.Store(true)
+	c := e.start()
+
+	invalid := newOrder()
+	invalid.discount = invalid.amount + 1
+	e.publish(invalid)
+	waitFor(t, "the message to be parked", func() bool { return c.CheckDLQ(context.Background()) != nil })
+	blocked := e.broker.Messages(ordersTopic)[0].Partition
+
+	var valid []order
+	for range 20 {
+		valid = append(valid, newOrder())
+	}
+	e.publish(valid...)
+	var others []string
+	for _, msg := range e.broker.Messages(ordersTopic) {
+		if id, _ := msg.Header("event_id"); msg.Partition != blocked {
+			others = append(others, string(id))
+		}
+	}
+	waitFor(t, "the other partitions to be processed", func() bool {
+		processed := c.processedIDs()
+		for _, id := range others {
+			if !slices.Contains(processed, id) {
+				return false
+			}
+		}
+		return true
+	})
+	if committed, ok := e.broker.Committed(e.kafka.GroupID, ordersTopic, blocked); ok && committed > 0 {
+		t.Errorf("blocked partition committed up to %d, want nothing", committed)
+	}
+
+	e.dlqDown.Store(false)
+	waitFor(t, "every message to be committed", e.committedAll)
+	waitFor(t, "every valid event to be processed", func() bool { return len(c.processedIDs()) == len(valid) })
+	if n := len(e.broker.Messages(dlqTopic)); n != 1 {
+		t.Errorf("%d DLQ records, want 1", n)
+	}
+	if err := c.CheckDLQ(context.Background()); err != nil {
+		t.Errorf("CheckDLQ = %v after the DLQ recovered", err)
+	}
+}
+
+// Revoking a retry tier's partition abandons its message blocked on the DLQ
+// instead of waiting for the DLQ, and leaves it uncommitted for the next
+// owner. Kafka revokes partitions while the message is processed; the
+// in-memory broker only from Fetch, so the revoke is called directly.
+func TestRevokeAbandonsBlockedRetry(t *testing.T) {
+	e := newTestEnv(t)
+	e.kafka.MaxRetries = 1
+	e.kafka.RetryTopics[0].Delay = 0
+	c := e.start()
+	e.registryDown.Store(true)
+	e.dlqDown.Store(true)
+	e.publish(newOrder())
+	waitFor(t, "the retry to block on the DLQ", func() bool { return c.dlqBlocked.Load() == 1 })
+
+	retried := e.broker.Messages(retryTopic)[0]
+	tp := broker.TopicPartition{Topic: retryTopic, Partition: retried.Partition}
+	revoked := make(chan struct{})
+	go func() {
+		c.onRetryRevoked(context.Background(), []broker.TopicPartition{tp})
+		close(revoked)
+	}()
+	select {
+	case <-revoked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("revoke waited for the DLQ")
+	}
+	waitFor(t, "the retry to be abandoned", func() bool { return c.dlqBlocked.Load() == 0 })
+	if committed, ok := e.broker.Committed(e.kafka.GroupID+"."+retryTopic, retryTopic, tp.Partition); ok && committed > retried.Offset {
+		t.Errorf("abandoned retry committed up to %d", committed)
+	}
+	if n := len(e.broker.Messages(dlqTopic)); n != 0 {
+		t.Errorf("%d DLQ records, want none", n)
+	}
+}
+
+// Seeking a partition back processes its events again instead of skipping
+// them as duplicates.
+func TestSeekBackReprocesses(t *testing.T) {
+	e := newTestEnv(t)
+	c := e.start()
+	o := newOrder()
+	e.publish(o)
+	waitFor(t, "the event to be processed", func() bool { return len(c.processedIDs()) == 1 })
+	waitFor(t, "the event to be committed", e.committedAll)
+
+	msg := e.broker.Messages(ordersTopic)[0]
+	tp := broker.TopicPartition{Topic: ordersTopic, Partition: msg.Partition}
+	if err := c.Seek(context.Background(), tp, msg.Offset); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the event to be processed again", func() bool { return len(c.processedIDs()) == 2 })
+}
+
+// A message fetched before its partition was seeked is dropped rather than
+// tracked: its partition's offsets were forgotten, so committing it would
+// move the partition past the seek target.
+func TestSeekDropsMessagesFetchedBefore(t *testing.T) {
+	e := newTestEnv(t)
+	c := e.start()
+	e.publish(newOrder())
+	waitFor(t, "the event to be processed", func() bool { return len(c.processedIDs()) == 1 })
+	waitFor(t, "the event to be committed", e.committedAll)
+
+	msg := e.broker.Messages(ordersTopic)[0]
+	tp := broker.TopicPartition{Topic: ordersTopic, Partition: msg.Partition}
+	before := c.seeks.generation()
+	if c.fetchedBeforeSeek(context.Background(), c.subscriber, msg, before) {
+		t.Error("message dropped without a seek")
+	}
+	if err := c.Seek(context.Background(), tp, msg.Offset); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the event to be processed again", func() bool { return len(c.processedIDs()) == 2 })
+	if !c.fetchedBeforeSeek(context.Background(), c.subscriber, msg, before) {
+		t.Error("message fetched before the seek was not dropped")
+	}
+	if c.fetchedBeforeSeek(context.Background(), c.subscriber, msg, c.seeks.generation()) {
+		t.Error("message fetched after the seek was dropped")
+	}
+	other := broker.Message{Topic: ordersTopic, Partition: msg.Partition + 1, Offset: msg.Offset}
+	if c.fetchedBeforeSeek(context.Background(), c.subscriber, other, before) {
+		t.Error("message of another partition was dropped")
+	}
+	waitFor(t, "the seek target to be committed", e.committedAll)
+}
+
+// The dedup warm-up of a restarted consumer preloads the IDs of messages
+// that failed too. Their retries and DLQ replays must still be processed.
+func TestRestartWithPendingRetry(t *testing.T) {
+	e := newTestEnv(t)
+	e.kafka.RetryTopics[0].Delay = time.Second
+	first := e.start()
+	e.registryDown.Store(true)
+	o := newOrder()
+	e.publish(o)
+	waitFor(t, "the retry to be scheduled", func() bool { return len(e.broker.Messages(retryTopic)) == 1 })
+	waitFor(t, "the failed message to be committed", e.committedAll)
+	first.stop()
+
+	e.registryDown.Store(false)
+	second := e.start()
+	waitFor(t, "the retried event to be processed", func() bool { return len(second.processedIDs()) == 1 })
+	if processed := first.processedIDs(); len(processed) != 0 {
+		t.Errorf("first consumer processed %v before stopping", processed)
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	e := newTestEnv(t)
+	e.handlerErr = func(event *events.OrderCreatedEvent) error {
+		return failure.Permanent(failure.ErrHandler, errors.New("rejected"))
+	}
+	first := e.start()
+	o := newOrder()
+	e.publish(o)
+	waitFor(t, "the event to be dead-lettered", func() bool { return len(e.broker.Messages(dlqTopic)) == 1 })
+	waitFor(t, "the failed message to be committed", e.committedAll)
+	first.stop()
+
+	e.handlerErr = nil
+	second := e.start()
+	original := e.broker.Messages(ordersTopic)[0]
+	e.publishRaw(broker.Message{
+		Key:   original.Key,
+		Value: original.Value,
+		Headers: append(slices.Clone(original.Headers),
+			broker.Header{Key: dlq.HeaderReplayedFrom, Value: []byte(dlqTopic + "[0]@0")}),
+	})
+	waitFor(t, "the replayed event to be processed", func() bool { return len(second.processedIDs()) == 1 })
+
+	// A second replay of the now processed event is a duplicate.
+	e.publishRaw(broker.Message{
+		Key:     original.Key,
+		Value:   original.Value,
+		Headers: []broker.Header{{Key: dlq.HeaderReplayedFrom, Value: []byte(dlqTopic + "[0]@0")}},
+	})
+	waitFor(t, "every message to be committed", e.committedAll)
+	if processed := second.processedIDs(); len(processed) != 1 {
+		t.Errorf("processed %v, want the event once", processed)
+	}
+}
@@ -0,0 +1,59 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/health"
+)
+
+// Checks behind the health probes.
+
+// messageKey identifies a message in flight to the watchdog.
+type messageKey struct {
+	broker.TopicPartition
+	offset int64
+}
+
+func keyOf(msg broker.Message) messageKey {
+	return messageKey{broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}, msg.Offset}
+}
+
+// CheckStalled fails while a message has been processing for longer than
+// limit, which only a restart fixes. Time a message spends blocked on DLQ
+// writes does not count, CheckDLQ reports it.
+func (c *Consumer) CheckStalled(limit time.Duration) health.Check {
+	return c.watchdog.Check(limit)
+}
+
+// Ping checks that the Kafka cluster is reachable.
+func (c *Consumer) Ping(ctx context.Context) error {
+	if !c.started() {
+		return ErrNotStarted
+	}
+	return c.subscriber.Ping(ctx)
+}
+
+// CheckMembership fails while the consumer is not a member of its group,
+// e.g. before the first join or after being kicked out for missing
+// heartbeats.
+func (c *Consumer) CheckMembership(ctx context.Context) error {
+	if !c.started() {
+		return ErrNotStarted
+	}
+	if _, ok := c.subscriber.Member(); !ok {
+		return errors.New("not a member of group " + c.kafkaConfig.GroupID)
+	}
+	return nil
+}
+
+// CheckDLQ fails while messages are blocked on a failing DLQ write.
+func (c *Consumer) CheckDLQ(ctx context.Context) error {
+	if n := c.dlqBlocked.Load(); n > 0 {
+		return fmt.Errorf("%d messages blocked on DLQ writes", n)
+	}
+	return nil
+}
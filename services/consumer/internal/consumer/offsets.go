@@ -0,0 +1,182 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// offsetTracker follows every fetched message until a worker has finished
+// it. Workers complete messages out of order, so committing whatever
+// finished last could skip a message still in flight on another worker and
+// lose it on a crash. Only the contiguous prefix of completed offsets of a
+// partition is ever handed out for committing.
+type offsetTracker struct {
+	parent     context.Context
+	mu         sync.Mutex
+	changed    chan struct{} // closed and replaced whenever a message completes
+	partitions map[broker.TopicPartition]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	inflight  []broker.Message // in fetch order, so offsets are ascending
+	done      map[int64]bool
+	abandoned map[int64]bool  // given up without completing, the prefix never moves past them
+	ready     *broker.Message // last message of the completed prefix, not yet committed
+
+	// ctx is cancelled once the partition is revoked, so work that waits on
+	// behalf of the partition can give up.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newOffsetTracker creates a tracker whose partition contexts derive from
+// parent.
+func newOffsetTracker(parent context.Context) *offsetTracker {
+	return &offsetTracker{
+		parent:     parent,
+		changed:    make(chan struct{}),
+		partitions: make(map[broker.TopicPartition]*partitionOffsets),
+	}
+}
+
+// Track registers a fetched message before it is handed to a worker.
+func (t *offsetTracker) Track(msg broker.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = &partitionOffsets{
+			done:      make(map[int64]bool),
+			abandoned: make(map[int64]bool),
+		}
+		p.ctx, p.cancel = context.WithCancel(t.parent)
+		t.partitions[tp] = p
+	}
+	p.inflight = append(p.inflight, msg)
+}
+
+// Done marks a message as finished and advances its partition's completed
+// prefix as far as possible.
+func (t *offsetTracker) Done(msg broker.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}]
+	if !ok {
+		return // partition was revoked and forgotten
+	}
+	p.done[msg.Offset] = true
+	for len(p.inflight) > 0 && p.done[p.inflight[0].Offset] {
+		head := p.inflight[0]
+		delete(p.done, head.Offset)
+		p.ready = &head
+		p.inflight = p.inflight[1:]
+	}
+	t.broadcastLocked()
+}
+
+// Abandon gives up on a message without completing it. Its partition is not
+// committed past it again, the message is redelivered to whoever owns the
+// partition next.
+func (t *offsetTracker) Abandon(msg broker.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.partitions[broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}]
+	if !ok {
+		return
+	}
+	p.abandoned[msg.Offset] = true
+	t.broadcastLocked()
+}
+
+func (t *offsetTracker) broadcastLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// Context returns a context that is cancelled when the message's partition
+// is revoked.
+func (t *offsetTracker) Context(msg broker.Message) context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.partitions[broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}]; ok {
+		return p.ctx
+	}
+	return t.parent
+}
+
+// Revoke cancels the contexts of the partitions.
+func (t *offsetTracker) Revoke(partitions []broker.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range partitions {
+		if p, ok := t.partitions[tp]; ok {
+			p.cancel()
+		}
+	}
+}
+
+// Ready returns, per partition, the last message of the completed prefix
+// that has not been returned before.
+func (t *offsetTracker) Ready() []broker.Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var msgs []broker.Message
+	for _, p := range t.partitions {
+		if p.ready != nil {
+			msgs = append(msgs, *p.ready)
+			p.ready = nil
+		}
+	}
+	return msgs
+}
+
+// Wait blocks until every message of the given partitions in flight is
+// either done or abandoned, or ctx is done.
+func (t *offsetTracker) Wait(ctx context.Context, partitions []broker.TopicPartition) error {
+	for {
+		t.mu.Lock()
+		busy := false
+		for _, tp := range partitions {
+			if p, ok := t.partitions[tp]; ok && p.busy() {
+				busy = true
+				break
+			}
+		}
+		changed := t.changed
+		t.mu.Unlock()
+		if !busy {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Forget drops the state of partitions that are no longer assigned. A later
+// assignment starts again from the committed offset.
+func (t *offsetTracker) Forget(partitions []broker.TopicPartition) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tp := range partitions {
+		if p, ok := t.partitions[tp]; ok {
+			p.cancel()
+			delete(t.partitions, tp)
+		}
+	}
+}
+
+func (p *partitionOffsets) busy() bool {
+	for _, msg := range p.inflight {
+		if !p.done[msg.Offset] && !p.abandoned[msg.Offset] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package consumer
+
+import (
+	"context"
+
+	"github.com/dzon2000/eda/consumer/internal/dlq"
+	"github.com/dzon2000/eda/consumer/internal/metrics"
+	"github.com/dzon2000/eda/consumer/internal/retry"
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// RebalanceHook is called with the partitions a rebalance assigned to or
+// revoked from this consumer.
+type RebalanceHook func(ctx context.Context, partitions []broker.TopicPartition)
+
+// OnAssigned registers a hook that runs after the deduplication window of
+// newly assigned partitions is warmed up and before their first message is
+// processed, e.g. to preload caches. It must be called before Start.
+func (c *Consumer) OnAssigned(hook RebalanceHook) {
+	c.assignedHooks = append(c.assignedHooks, hook)
+}
+
+// OnRevoked registers a hook that runs after the messages of revoked
+// partitions are finished and committed and before the partitions move to
+// another group member, e.g. to flush state kept for them. It must be
+// called before Start.
+func (c *Consumer) OnRevoked(hook RebalanceHook) {
+	c.revokedHooks = append(c.revokedHooks, hook)
+}
+
+// onAssigned rebuilds the deduplication window of the assigned partitions,
+// so events redelivered from before the committed offset, e.g. replayed by
+// the producer after the previous owner committed them, are still caught.
+func (c *Consumer) onAssigned(ctx context.Context, partitions []broker.TopicPartition) {
+	// With a real cluster the first assignment may race Start storing the
+	// subscriber.
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+		return
+	}
+	c.warmDedup(ctx, partitions)
+	for _, hook := range c.assignedHooks {
+		hook(ctx, partitions)
+	}
+}
+
+// onRevoked lets the workers finish the messages of revoked partitions and
+// commits them, whatever the commit policy, before the partitions move to
+// another group member, so the new owner neither reprocesses nor skips them.
+func (c *Consumer) onRevoked(ctx context.Context, partitions []broker.TopicPartition) {
+	c.release(ctx, partitions)
+	for _, hook := range c.revokedHooks {
+		hook(ctx, partitions)
+	}
+	c.dedup.Forget(partitions)
+	metrics.ForgetLag(partitions)
+}
+
+// onRetryRevoked is onRevoked for the partitions of a retry tier. Their
+// events are deduplicated in the partitions they were first read from,
+// which stay assigned.
+func (c *Consumer) onRetryRevoked(ctx context.Context, partitions []broker.TopicPartition) {
+	c.release(ctx, partitions)
+	metrics.ForgetLag(partitions)
+}
+
+// release waits for the messages of revoked partitions in flight, commits
+// them and forgets the partitions. Messages blocked on the DLQ, or waiting
+// for their retry to be due, are abandoned to the next owner.
+func (c *Consumer) release(ctx context.Context, partitions []broker.TopicPartition) {
+	c.offsets.Revoke(partitions)
+	if err := c.offsets.Wait(ctx, partitions); err != nil {
+		logger.WarnContext(ctx, "Gave up waiting for in-flight messages of revoked partitions", "error", err)
+	}
+	c.commitMu.Lock()
+	c.commitReadyLocked(ctx)
+	c.offsets.Forget(partitions)
+	c.commitMu.Unlock()
+}
+
+// warmDedup reads the last DedupWindow messages before each partition's
+// committed offset and preloads their event IDs. Failing to warm up only
+// weakens deduplication, so errors are logged and consuming goes on.
+func (c *Consumer) warmDedup(ctx context.Context, partitions []broker.TopicPartition) {
+	ctx, cancel := context.WithTimeout(ctx, c.processing.DedupWarmupTimeout)
+	defer cancel()
+
+	committed, err := c.subscriber.Committed(ctx, partitions)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to fetch committed offsets, skipping dedup warm-up", "error", err)
+		return
+	}
+	for tp, offset := range committed {
+		from := max(offset-int64(c.dedup.Window()), 0)
+		if from >= offset {
+			continue
+		}
+		msgs, err := c.broker.Read(ctx, tp, from, offset)
+		if err != nil {
+			logger.WarnContext(ctx, "Failed to warm up dedup window", "topic", tp.Topic, "partition", tp.Partition, "error", err)
+			continue
+		}
+		ids := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			if id := c.eventID(msg); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		c.dedup.Preload(tp, ids)
+		logger.InfoContext(ctx, "Warmed up dedup window", "topic", tp.Topic, "partition", tp.Partition, "events", len(ids))
+	}
+}
+
+// eventID prefers the eventId of the decoded payload, the ID deduplication
+// uses, and falls back to the producer's event_id header.
+func (c *Consumer) eventID(msg broker.Message) string {
+	if data, err := c.registry.Decode(msg.Value); err == nil {
+		if id, ok := data["eventId"].(string); ok && id != "" {
+			return id
+		}
+	}
+	if id, ok := msg.Header("event_id"); ok {
+		return string(id)
+	}
+	return ""
+}
+
+// seen reports whether the message's event is a duplicate and records it.
+// Retried and replayed messages are copies of a message that was consumed
+// but failed, so the warm-up preloaded their event ID; they are duplicates
+// only of an event processed since.
+func (c *Consumer) seen(msg broker.Message, eventID string) bool {
+	_, replayed := msg.Header(dlq.HeaderReplayedFrom)
+	if replayed || retry.Attempt(msg) > 0 {
+		return c.dedup.SeenProcessed(dedupPartition(msg), eventID)
+	}
+	return c.dedup.Seen(dedupPartition(msg), eventID)
+}
+
+// dedupPartition is the partition a message's event is deduplicated in:
+// retried messages count against the partition they were first read from.
+func dedupPartition(msg broker.Message) broker.TopicPartition {
+	topic, partition, _ := retry.Origin(msg)
+	return broker.TopicPartition{Topic: topic, Partition: partition}
+}
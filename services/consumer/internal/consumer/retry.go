@@ -0,0 +1,108 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/consumer/internal/failure"
+	"github.com/dzon2000/eda/consumer/internal/metrics"
+	"github.com/dzon2000/eda/consumer/internal/retry"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/tracing"
+)
+
+// scheduleRetry moves a failed message to the next retry tier, or reports
+// false when the message belongs in the DLQ: the failure is not retryable
+// or KafkaConfig.MaxRetries attempts are used up.
+func (c *Consumer) scheduleRetry(ctx context.Context, msg broker.Message, cause error) (bool, error) {
+	if !failure.IsRetryable(cause) {
+		return false, nil
+	}
+	tiers := c.kafkaConfig.RetryTopics
+	attempt := retry.Attempt(msg)
+	if len(tiers) == 0 || attempt >= c.kafkaConfig.MaxRetries {
+		return false, nil
+	}
+
+	// Attempts beyond the last tier stay on the last tier.
+	tier := tiers[min(attempt, len(tiers)-1)]
+	next := retry.Schedule(msg, tier.Topic, tier.Delay, cause, time.Now())
+	tracing.Inject(ctx, &next) // the retry continues the trace of this attempt
+	if err := c.retryPublisher.Publish(ctx, next); err != nil {
+		return false, fmt.Errorf("failed to publish to retry topic %s: %w", tier.Topic, err)
+	}
+	logger.InfoContext(ctx, "Scheduled retry", append(messageAttrs(msg), "attempt", attempt+1, "retry_topic", tier.Topic)...)
+	return true, nil
+}
+
+// consumeRetryTier processes one retry topic. Every message on it was
+// delayed by the same amount, so waiting for each message's not-before time
+// in order never holds back a message that is already due.
+func (c *Consumer) consumeRetryTier(ctx context.Context, subscriber broker.Subscriber, tier config.RetryTier) {
+	for {
+		gen := c.seeks.generation()
+		msg, err := subscriber.Fetch(ctx)
+		if ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.ErrorContext(ctx, "Error reading message", "topic", tier.Topic, "error", err)
+			continue
+		}
+		if c.fetchedBeforeSeek(ctx, subscriber, msg, gen) {
+			continue
+		}
+
+		metrics.ObserveLag(msg)
+		// Tracked like the messages of the main topic, so a revoke waits
+		// for it and commits it, or abandons it while it waits.
+		c.offsets.Track(msg)
+		err = c.processRetried(ctx, msg)
+		if err != nil {
+			logger.Error("Failed to process retried message", append(messageAttrs(msg), "error", err)...)
+		}
+		c.finishRetried(msg, err)
+	}
+}
+
+// processRetried waits until msg is due and processes it. It gives up with
+// errNotDeadLettered when the consumer stops or the partition is revoked
+// before.
+func (c *Consumer) processRetried(ctx context.Context, msg broker.Message) error {
+	revoked := c.offsets.Context(msg)
+	if wait := time.Until(retry.NotBefore(msg)); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", errNotDeadLettered, ctx.Err())
+		case <-revoked.Done():
+			return fmt.Errorf("%w: %w", errNotDeadLettered, revoked.Err())
+		case <-time.After(wait):
+		}
+	}
+
+	start := time.Now()
+	err := c.processMessage(c.workCtx, msg)
+	metrics.ProcessingDuration.WithLabelValues(msg.Topic).Observe(time.Since(start).Seconds())
+	var blocked *dlqBlockedError
+	if errors.As(err, &blocked) {
+		return c.blockUntilDeadLettered(revoked, msg, blocked)
+	}
+	return err
+}
+
+// finishRetried commits a retried message, whatever the commit policy, or
+// abandons it to be redelivered after a restart or to the next owner of
+// its partition.
+func (c *Consumer) finishRetried(msg broker.Message, err error) {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+	if errors.Is(err, errNotDeadLettered) {
+		c.offsets.Abandon(msg)
+		return
+	}
+	c.offsets.Done(msg)
+	c.commitReadyLocked(c.workCtx)
+}
@@ -0,0 +1,103 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// seekTracker lets the fetch loops tell a message fetched before an
+// operator seeked its partition from one fetched after. Seek forgets the
+// partition's offsets, so tracking such a message would commit the old
+// position, past the offset the partition was moved to.
+//
+// Seeks run one at a time. A fetch loop reads the generation before each
+// Fetch; a seek bumps it once the partition was moved, after which Fetch
+// no longer returns messages from the old position.
+type seekTracker struct {
+	running sync.Mutex // held by the seek in progress
+
+	mu      sync.Mutex
+	current *broker.TopicPartition // being seeked
+	gen     uint64                 // seeks that moved their partition
+	last    map[broker.TopicPartition]seeked
+}
+
+type seeked struct {
+	gen    uint64
+	offset int64
+}
+
+func newSeekTracker() *seekTracker {
+	return &seekTracker{last: make(map[broker.TopicPartition]seeked)}
+}
+
+// generation is read by a fetch loop before it calls Fetch.
+func (s *seekTracker) generation() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gen
+}
+
+// begin waits for the seek in progress, if any, and starts seeking tp.
+func (s *seekTracker) begin(tp broker.TopicPartition) {
+	s.running.Lock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = &tp
+}
+
+// end finishes the seek begun last. moved reports whether tp was moved to
+// offset.
+func (s *seekTracker) end(tp broker.TopicPartition, offset int64, moved bool) {
+	s.mu.Lock()
+	s.current = nil
+	if moved {
+		s.gen++
+		s.last[tp] = seeked{gen: s.gen, offset: offset}
+	}
+	s.mu.Unlock()
+	s.running.Unlock()
+}
+
+// since returns the last seek of tp if it moved the partition after
+// generation gen.
+func (s *seekTracker) since(tp broker.TopicPartition, gen uint64) (seeked, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.last[tp]
+	return last, ok && last.gen > gen
+}
+
+func (s *seekTracker) seeking(tp broker.TopicPartition) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current != nil && *s.current == tp
+}
+
+// fetchedBeforeSeek reports whether msg, returned by a Fetch that started at
+// seek generation gen, may have been fetched before its partition was
+// seeked, in which case the caller drops it. A message of a partition being
+// seeked waits for the seek to finish. Fetch may have returned the first
+// message from the new position too, so the partition is seeked to the same
+// offset once more: whichever message was dropped is fetched again if it
+// belongs there.
+func (c *Consumer) fetchedBeforeSeek(ctx context.Context, subscriber broker.Subscriber, msg broker.Message, gen uint64) bool {
+	tp := broker.TopicPartition{Topic: msg.Topic, Partition: msg.Partition}
+	if _, ok := c.seeks.since(tp, gen); !ok && !c.seeks.seeking(tp) {
+		return false
+	}
+	// No seek runs while the partition is seeked again.
+	c.seeks.running.Lock()
+	defer c.seeks.running.Unlock()
+	last, ok := c.seeks.since(tp, gen)
+	if !ok {
+		return false // the seek failed, the partition did not move
+	}
+	logger.InfoContext(ctx, "Dropping message fetched before its partition was seeked", messageAttrs(msg)...)
+	if err := subscriber.Seek(ctx, tp, last.offset); err != nil {
+		logger.WarnContext(ctx, "Failed to seek partition again", "topic", tp.Topic, "partition", tp.Partition, "offset", last.offset, "error", err)
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// workerPool processes messages concurrently while keeping the order Kafka
+// guarantees where it matters: every message is routed to a worker by its
+// partition, or by partition and key, and each worker handles its queue one
+// message at a time.
+type workerPool struct {
+	ordering string
+	queues   []chan broker.Message
+	wg       sync.WaitGroup
+}
+
+func newWorkerPool(cfg config.ProcessingConfig, handle func(broker.Message)) *workerPool {
+	p := &workerPool{
+		ordering: cfg.Ordering,
+		queues:   make([]chan broker.Message, cfg.Workers),
+	}
+	for i := range p.queues {
+		queue := make(chan broker.Message, cfg.QueueSize)
+		p.queues[i] = queue
+		p.wg.Go(func() {
+			for msg := range queue {
+				handle(msg)
+			}
+		})
+	}
+	return p
+}
+
+// Dispatch queues msg on its worker, blocking while that worker's queue is
+// full so a slow key applies backpressure to Fetch.
+func (p *workerPool) Dispatch(msg broker.Message) {
+	p.queues[p.route(msg)] <- msg
+}
+
+func (p *workerPool) route(msg broker.Message) int {
+	h := fnv.New32a()
+	h.Write([]byte(msg.Topic))
+	h.Write([]byte(strconv.Itoa(msg.Partition)))
+	if p.ordering == config.OrderingKey {
+		h.Write(msg.Key)
+	}
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Close waits for every queued message to be handled.
+func (p *workerPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
@@ -1,16 +1,135 @@
 package deduplicator
 
-import "sync"
+import (
+	"slices"
+	"sync"
 
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+// Deduplicator remembers the event IDs of the last window messages of every
+// partition. A redelivery after a crash or rebalance starts at most at the
+// committed offset, so a window covering the uncommitted tail is enough;
+// keeping it per partition lets a revoked partition's IDs be dropped and a
+// newly assigned partition's IDs be preloaded.
+//
+// A preloaded ID only says the message was consumed, not that it was
+// processed: it may have failed and been retried or dead-lettered. So a
+// retry or DLQ replay of an event is only a duplicate once the event was
+// processed since the window was preloaded, see SeenProcessed.
 type Deduplicator struct {
-	seen sync.Map
+	window int
+
+	mu         sync.Mutex
+	partitions map[broker.TopicPartition]*partitionWindow
+}
+
+type partitionWindow struct {
+	ids  map[string]bool // true once processed here, false while only preloaded
+	ring []string        // insertion order, the oldest ID is at next once full
+	next int
+}
+
+func New(window int) *Deduplicator {
+	return &Deduplicator{
+		window:     window,
+		partitions: make(map[broker.TopicPartition]*partitionWindow),
+	}
+}
+
+// Seen records eventID as processed for the partition and reports whether
+// it was seen before, processed or preloaded.
+func (d *Deduplicator) Seen(tp broker.TopicPartition, eventID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.windowLocked(tp)
+	if _, ok := w.ids[eventID]; ok {
+		w.ids[eventID] = true
+		return true
+	}
+	d.addLocked(w, eventID, true)
+	return false
+}
+
+// SeenProcessed records eventID as processed for the partition and reports
+// whether it was processed before. It is for retries and replays of events
+// whose first attempt is in the window even though it failed.
+func (d *Deduplicator) SeenProcessed(tp broker.TopicPartition, eventID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.windowLocked(tp)
+	processed, ok := w.ids[eventID]
+	if !ok {
+		d.addLocked(w, eventID, true)
+	}
+	w.ids[eventID] = true
+	return processed
+}
+
+// Preload fills the partition's window with IDs of already processed
+// messages, oldest first.
+func (d *Deduplicator) Preload(tp broker.TopicPartition, eventIDs []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.windowLocked(tp)
+	for _, id := range eventIDs {
+		if _, ok := w.ids[id]; !ok {
+			d.addLocked(w, id, false)
+		}
+	}
+}
+
+// Remove drops eventID from the partition's window, e.g. when processing
+// the event failed after Seen recorded it.
+func (d *Deduplicator) Remove(tp broker.TopicPartition, eventID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.partitions[tp]
+	if !ok {
+		return
+	}
+	if _, ok := w.ids[eventID]; !ok {
+		return
+	}
+	delete(w.ids, eventID)
+	// Blank its slot so evicting it later cannot drop the ID once it was
+	// recorded again.
+	if i := slices.Index(w.ring, eventID); i >= 0 {
+		w.ring[i] = ""
+	}
+}
+
+// Forget drops the windows of partitions this consumer no longer owns.
+func (d *Deduplicator) Forget(partitions []broker.TopicPartition) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, tp := range partitions {
+		delete(d.partitions, tp)
+	}
+}
+
+func (d *Deduplicator) Window() int {
+	return d.window
 }
 
-func New() *Deduplicator {
-	return &Deduplicator{}
+func (d *Deduplicator) windowLocked(tp broker.TopicPartition) *partitionWindow {
+	w, ok := d.partitions[tp]
+	if !ok {
+		w = &partitionWindow{ids: make(map[string]bool)}
+		d.partitions[tp] = w
+	}
+	return w
 }
 
-func (d *Deduplicator) Seen(eventID string) bool {
-	_, exists := d.seen.LoadOrStore(eventID, struct{}{})
-	return exists
+func (d *Deduplicator) addLocked(w *partitionWindow, eventID string, processed bool) {
+	if len(w.ring) < d.window {
+		w.ring = append(w.ring, eventID)
+	} else {
+		if evicted := w.ring[w.next]; evicted != "" {
+			delete(w.ids, evicted)
+		}
+		w.ring[w.next] = eventID
+		w.next = (w.next + 1) % d.window
+	}
+	w.ids[eventID] = processed
 }
@@ -0,0 +1,51 @@
+package deduplicator
+
+import (
+	"testing"
+
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+func TestDeduplicator(t *testing.T) {
+	tp := broker.TopicPartition{Topic: "orders", Partition: 0}
+	other := broker.TopicPartition{Topic: "orders", Partition: 1}
+	d := New(3)
+	d.Preload(tp, []string{"preloaded", "retried"})
+
+	steps := []struct {
+		name      string
+		processed bool // SeenProcessed instead of Seen
+		tp        broker.TopicPartition
+		id        string
+		want      bool
+	}{
+		{"new event", false, tp, "a", false},
+		{"redelivered event", false, tp, "a", true},
+		{"other partition", false, other, "a", false},
+		{"redelivered preloaded event", false, tp, "preloaded", true},
+		{"retry of a preloaded event", true, tp, "retried", false},
+		{"second retry", true, tp, "retried", true},
+		{"retry of a processed event", true, tp, "a", true},
+		{"retry of a new event", true, tp, "b", false},
+		// The window holds 3 IDs: preloaded was evicted.
+		{"evicted event", false, tp, "preloaded", false},
+	}
+	for _, step := range steps {
+		seen := d.Seen
+		if step.processed {
+			seen = d.SeenProcessed
+		}
+		if got := seen(step.tp, step.id); got != step.want {
+			t.Errorf("%s: seen(%s) = %v, want %v", step.name, step.id, got, step.want)
+		}
+	}
+
+	d.Remove(tp, "b")
+	if d.Seen(tp, "b") {
+		t.Error("removed event was seen")
+	}
+	d.Forget([]broker.TopicPartition{tp})
+	if d.Seen(tp, "a") {
+		t.Error("event of a forgotten partition was seen")
+	}
+}
@@ -2,85 +2,132 @@ package dlq
 
 import (
 	"context"
-	"strings"
+	"os"
+	"time"
 
-	"github.com/dzon2000/eda/consumer/internal/config"
 	"github.com/dzon2000/eda/consumer/internal/events"
+	"github.com/dzon2000/eda/consumer/internal/failure"
+	"github.com/dzon2000/eda/consumer/internal/metrics"
+	"github.com/dzon2000/eda/consumer/internal/retry"
 	"github.com/dzon2000/eda/consumer/internal/schema"
-	"github.com/segmentio/kafka-go"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer = otel.Tracer("github.com/dzon2000/eda/consumer/internal/dlq")
+	logger = logging.Logger("dlq")
+)
+
+// Headers set on records replayed from the DLQ, so consumers can tell a
+// replay from the original delivery.
+const (
+	HeaderReplayedFrom = "x-dlq-replayed-from" // DLQ record as topic[partition]@offset
+	HeaderReplayedAt   = "x-dlq-replayed-at"   // RFC 3339
 )
 
 type DLQProducer interface {
-	Send(ctx context.Context, msg kafka.Message, err error) error
+	Send(ctx context.Context, msg broker.Message, err error) error
 	Close() error
 }
 
-type KafkaDLQProducer struct {
-	writer  *kafka.Writer
-	encoder *schema.Encoder
+// Producer writes OrderDLQEvent records through a broker publisher whose
+// default topic is the DLQ topic.
+type Producer struct {
+	publisher broker.Publisher
+	encoder   *schema.Encoder
+	registry  *schema.Registry // decodes failed payloads to find their eventId
+	groupID   string
+	host      string
 }
 
-func NewKafkaDLQProducer(kafkaConfig config.KafkaConfig, encoder *schema.Encoder) *KafkaDLQProducer {
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      kafkaConfig.Brokers,
-		Topic:        kafkaConfig.DLQTopic,
-		Balancer:     &kafka.LeastBytes{},
-		RequiredAcks: int(kafka.RequireAll),
-		MaxAttempts:  kafkaConfig.MaxRetries,
-	})
-	return &KafkaDLQProducer{
-		writer:  writer,
-		encoder: encoder,
+func NewProducer(publisher broker.Publisher, encoder *schema.Encoder, registry *schema.Registry, groupID string) *Producer {
+	host, err := os.Hostname()
+	if err != nil {
+		logger.Warn("Failed to get hostname for DLQ records", "error", err)
+	}
+	return &Producer{
+		publisher: publisher,
+		encoder:   encoder,
+		registry:  registry,
+		groupID:   groupID,
+		host:      host,
 	}
 }
 
-func (p *KafkaDLQProducer) Send(
+// Send dead-letters msg as part of the trace in ctx, which the DLQ record
+// carries on so a replay continues it too.
+func (p *Producer) Send(
 	ctx context.Context,
-	msg kafka.Message,
+	msg broker.Message,
 	cause error,
-) error {
+) (err error) {
+	// Retried messages are reported at their position in the source topic.
+	topic, partition, offset := retry.Origin(msg)
+	ctx, span := tracer.Start(ctx, "dlq.send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("error.type", failure.Classify(cause))))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
 
 	event := events.NewOrderDLQEvent(
-		extractEventID(msg.Value),
-		msg.Topic,
-		msg.Partition,
-		msg.Offset,
-		classifyError(cause),
+		p.extractEventID(msg),
+		topic,
+		partition,
+		offset,
+		failure.Classify(cause),
 		cause.Error(),
 		msg.Value,
 	)
+	event.Key = msg.Key
+	for _, h := range msg.Headers {
+		event.Headers = append(event.Headers, events.Header{Key: h.Key, Value: h.Value})
+	}
+	if !msg.Time.IsZero() {
+		event.OriginalTimestamp = msg.Time.UTC().Format(time.RFC3339Nano)
+	}
+	event.ConsumerGroup = p.groupID
+	event.Attempt = retry.Attempt(msg)
+	event.Host = p.host
 
 	value, err := p.encoder.Encode(event.ToMap())
 	if err != nil {
 		return err
 	}
 
-	return p.writer.WriteMessages(ctx, kafka.Message{
+	record := broker.Message{
 		Key:   msg.Key,
 		Value: value,
-	})
+	}
+	tracing.Inject(ctx, &record)
+	if err := p.publisher.Publish(ctx, record); err != nil {
+		return err
+	}
+	metrics.DLQSends.WithLabelValues(event.ErrorType).Inc()
+	return nil
 }
 
-func (p *KafkaDLQProducer) Close() error {
-	return p.writer.Close()
+func (p *Producer) Close() error {
+	return p.publisher.Close()
 }
 
-func extractEventID(value []byte) string {
-	if len(value) < 5 {
-		return "malformed-message"
+// extractEventID prefers the eventId of the decoded payload and falls back
+// to the producer's event_id header, which is also present when the payload
+// cannot be decoded. It returns "" when neither is available.
+func (p *Producer) extractEventID(msg broker.Message) string {
+	if data, err := p.registry.Decode(msg.Value); err == nil {
+		if id, ok := data["eventId"].(string); ok && id != "" {
+			return id
+		}
 	}
-	return "unknown-event-id"
-}
-
-func classifyError(err error) string {
-	switch {
-	case strings.Contains(err.Error(), "schema"):
-		return "schema_error"
-	case strings.Contains(err.Error(), "deserialize"):
-		return "deserialization_error"
-	case strings.Contains(err.Error(), "invalid message"):
-		return "invalid_message"
-	default:
-		return "processing_error"
+	if id, ok := msg.Header("event_id"); ok {
+		return string(id)
 	}
+	return ""
 }
@@ -1,5 +1,7 @@
 package events
 
+import "fmt"
+
 type OrderCreatedEvent struct {
 	EventID    string
 	OrderID    string
@@ -8,19 +10,48 @@ type OrderCreatedEvent struct {
 	Discount   *float64
 }
 
-// Parse from Avro deserialized map
-func ParseOrderCreated(data map[string]interface{}) *OrderCreatedEvent {
-	event := &OrderCreatedEvent{
-		EventID:    data["eventId"].(string),
-		OrderID:    data["orderId"].(string),
-		CustomerID: data["customerId"].(string),
-		Amount:     data["amount"].(float64),
+// Parse from Avro deserialized map. A record written with a different
+// schema than OrderCreated is reported as an error.
+func ParseOrderCreated(data map[string]interface{}) (*OrderCreatedEvent, error) {
+	event := &OrderCreatedEvent{}
+	var ok bool
+	if event.EventID, ok = data["eventId"].(string); !ok {
+		return nil, fmt.Errorf("eventId is missing or not a string")
+	}
+	if event.OrderID, ok = data["orderId"].(string); !ok {
+		return nil, fmt.Errorf("orderId is missing or not a string")
+	}
+	if event.CustomerID, ok = data["customerId"].(string); !ok {
+		return nil, fmt.Errorf("customerId is missing or not a string")
+	}
+	if event.Amount, ok = data["amount"].(float64); !ok {
+		return nil, fmt.Errorf("amount is missing or not a double")
 	}
 
 	if d, ok := data["discount"].(map[string]interface{}); ok {
-		val := d["double"].(float64)
+		val, ok := d["double"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("discount is not a double")
+		}
 		event.Discount = &val
 	}
 
-	return event
+	return event, nil
+}
+
+// Validate checks the business rules a well-formed event must satisfy.
+func (e *OrderCreatedEvent) Validate() error {
+	if e.OrderID == "" {
+		return fmt.Errorf("orderId is empty")
+	}
+	if e.CustomerID == "" {
+		return fmt.Errorf("customerId is empty")
+	}
+	if e.Amount <= 0 {
+		return fmt.Errorf("amount %v is not positive", e.Amount)
+	}
+	if e.Discount != nil && (*e.Discount < 0 || *e.Discount > e.Amount) {
+		return fmt.Errorf("discount %v is outside [0, amount]", *e.Discount)
+	}
+	return nil
 }
@@ -11,6 +11,19 @@ type OrderDLQEvent struct {
 	ErrorMessage  string
 	Payload       []byte
 	FailedAt      string
+
+	// Original record context, see docs/adr/0003.
+	Key               []byte
+	Headers           []Header
+	OriginalTimestamp string
+	ConsumerGroup     string
+	Attempt           int
+	Host              string
+}
+
+type Header struct {
+	Key   string
+	Value []byte
 }
 
 func NewOrderDLQEvent(
@@ -36,15 +49,80 @@ func NewOrderDLQEvent(
 
 // ToMap converts to format expected by Avro encoder
 func (e *OrderDLQEvent) ToMap() map[string]interface{} {
+	headers := make([]interface{}, 0, len(e.Headers))
+	for _, h := range e.Headers {
+		headers = append(headers, map[string]interface{}{"key": h.Key, "value": h.Value})
+	}
+	var key interface{}
+	if e.Key != nil {
+		key = map[string]interface{}{"bytes": e.Key}
+	}
 	return map[string]interface{}{
-		// eventId is a nullable union, so we must wrap it
-		"eventId":       map[string]interface{}{"string": e.EventID},
-		"originalTopic": e.OriginalTopic,
-		"partition":     e.Partition,
-		"offset":        e.Offset,
-		"errorType":     e.ErrorType,
-		"errorMessage":  e.ErrorMessage,
-		"payload":       e.Payload,
-		"failedAt":      e.FailedAt,
+		// Nullable unions must be wrapped
+		"eventId":           nullableString(e.EventID),
+		"originalTopic":     e.OriginalTopic,
+		"partition":         e.Partition,
+		"offset":            e.Offset,
+		"errorType":         e.ErrorType,
+		"errorMessage":      e.ErrorMessage,
+		"payload":           e.Payload,
+		"failedAt":          e.FailedAt,
+		"key":               key,
+		"headers":           headers,
+		"originalTimestamp": nullableString(e.OriginalTimestamp),
+		"consumerGroup":     nullableString(e.ConsumerGroup),
+		"attempt":           e.Attempt,
+		"host":              nullableString(e.Host),
+	}
+}
+
+// ParseOrderDLQ builds the event from an Avro deserialized map. Fields added
+// by docs/adr/0003 are missing from records written with the older schema.
+func ParseOrderDLQ(data map[string]interface{}) *OrderDLQEvent {
+	event := &OrderDLQEvent{
+		EventID:           unionString(data["eventId"]),
+		OriginalTopic:     data["originalTopic"].(string),
+		Partition:         int(data["partition"].(int32)),
+		Offset:            data["offset"].(int64),
+		ErrorType:         data["errorType"].(string),
+		ErrorMessage:      data["errorMessage"].(string),
+		Payload:           data["payload"].([]byte),
+		FailedAt:          data["failedAt"].(string),
+		OriginalTimestamp: unionString(data["originalTimestamp"]),
+		ConsumerGroup:     unionString(data["consumerGroup"]),
+		Host:              unionString(data["host"]),
+	}
+
+	if key, ok := data["key"].(map[string]interface{}); ok {
+		event.Key, _ = key["bytes"].([]byte)
+	}
+	if headers, ok := data["headers"].([]interface{}); ok {
+		for _, h := range headers {
+			header := h.(map[string]interface{})
+			event.Headers = append(event.Headers, Header{
+				Key:   header["key"].(string),
+				Value: header["value"].([]byte),
+			})
+		}
+	}
+	if attempt, ok := data["attempt"].(int32); ok {
+		event.Attempt = int(attempt)
+	}
+
+	return event
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return map[string]interface{}{"string": s}
+}
+
+func unionString(v interface{}) string {
+	if m, ok := v.(map[string]interface{}); ok {
+		s, _ := m["string"].(string)
+		return s
 	}
+	return ""
 }
@@ -0,0 +1,91 @@
+// Package failure is the taxonomy of message processing errors. Every error
+// the consumer produces wraps one of the sentinels below, so the DLQ can
+// classify it with errors.Is and the retry flow can tell whether another
+// attempt could succeed.
+package failure
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFrame  = errors.New("invalid message frame")
+	ErrUnknownSchema = errors.New("unknown schema")
+	ErrDeserialize   = errors.New("deserialization failed")
+	ErrValidation    = errors.New("validation failed")
+	ErrHandler       = errors.New("handler failed")
+	ErrQuarantined   = errors.New("quarantined by operator")
+)
+
+// Error is a processing error of a given kind.
+type Error struct {
+	Kind      error // one of the sentinels
+	Retryable bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// Permanent wraps err as a failure no retry can fix: the message itself is
+// broken.
+func Permanent(kind error, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// Transient wraps err as a failure that may go away, e.g. a dependency being
+// down.
+func Transient(kind error, err error) error {
+	return &Error{Kind: kind, Retryable: true, Err: err}
+}
+
+// Handler wraps an error returned by the code handling a valid event as
+// transient, unless the handler already chose a kind with Permanent or
+// Transient.
+func Handler(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return Transient(ErrHandler, err)
+}
+
+// IsRetryable reports whether another attempt at the message could succeed.
+// Errors outside the taxonomy are assumed to be transient, and so are
+// handler errors that were not marked permanent.
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+	return true
+}
+
+// Classify returns the errorType recorded in the DLQ.
+func Classify(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidFrame):
+		return "invalid_message"
+	case errors.Is(err, ErrUnknownSchema):
+		return "schema_error"
+	case errors.Is(err, ErrDeserialize):
+		return "deserialization_error"
+	case errors.Is(err, ErrValidation):
+		return "validation_error"
+	case errors.Is(err, ErrQuarantined):
+		return "quarantined"
+	case errors.Is(err, ErrHandler):
+		return "handler_error"
+	default:
+		return "processing_error"
+	}
+}
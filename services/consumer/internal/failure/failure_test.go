@@ -0,0 +1,37 @@
+package failure
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	cause := errors.New("cause")
+	tests := []struct {
+		err       error
+		errorType string
+		retryable bool
+	}{
+		{Permanent(ErrInvalidFrame, cause), "invalid_message", false},
+		{Permanent(ErrUnknownSchema, cause), "schema_error", false},
+		{Transient(ErrUnknownSchema, cause), "schema_error", true},
+		{Permanent(ErrDeserialize, cause), "deserialization_error", false},
+		{Permanent(ErrValidation, cause), "validation_error", false},
+		{Permanent(ErrQuarantined, nil), "quarantined", false},
+		{Handler(cause), "handler_error", true},
+		{Handler(fmt.Errorf("wrapped: %w", cause)), "handler_error", true},
+		{Handler(Permanent(ErrHandler, cause)), "handler_error", false},
+		{Handler(Permanent(ErrValidation, cause)), "validation_error", false},
+		{fmt.Errorf("scheduling: %w", Handler(cause)), "handler_error", true},
+		{cause, "processing_error", true},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.errorType {
+			t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.errorType)
+		}
+		if got := IsRetryable(tt.err); got != tt.retryable {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package journal appends every event the consumer processed to a file, one
+// JSON object per line:
+//
+//	{"event_id":"…","order_id":"…","topic":"orders.v1","partition":0,"offset":42,"processed_at":"…"}
+//
+// Deduplicated redeliveries are not recorded, so an event ID appearing twice
+// means the event was processed twice. It is how tools outside the process,
+// like the chaos verifier, check the delivery guarantees; the consumer keeps
+// no other record of what it processed.
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dzon2000/eda/consumer/internal/events"
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+type Entry struct {
+	EventID     string    `json:"event_id"`
+	OrderID     string    `json:"order_id"`
+	Topic       string    `json:"topic"`
+	Partition   int       `json:"partition"`
+	Offset      int64     `json:"offset"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+type Journal struct {
+	mu   sync.Mutex // one line per write
+	file *os.File
+}
+
+// Open appends to the journal at path, creating it if needed.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &Journal{file: f}, nil
+}
+
+// Record appends the processed event, it is a consumer.ProcessedHook. A
+// failed write fails the message, whose retry writes the line again.
+func (j *Journal) Record(ctx context.Context, event *events.OrderCreatedEvent, msg broker.Message) error {
+	line, err := json.Marshal(Entry{
+		EventID:     event.EventID,
+		OrderID:     event.OrderID,
+		Topic:       msg.Topic,
+		Partition:   msg.Partition,
+		Offset:      msg.Offset,
+		ProcessedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,71 @@
+// Package metrics defines the consumer's Prometheus metrics. They are
+// registered with the default registry and served on the admin API's
+// /metrics.
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "consumer"
+
+var (
+	ProcessingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_duration_seconds",
+		Help:      "Time to process one message, including retry scheduling and dead-lettering.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic"})
+	Lag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "lag_messages",
+		Help:      "Messages between the last fetched offset and the partition's high watermark.",
+	}, []string{"topic", "partition"})
+	DedupHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dedup_hits_total",
+		Help:      "Messages skipped because their event ID was already processed.",
+	})
+	DLQSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dlq_sends_total",
+		Help:      "Messages written to the DLQ, by error type.",
+	}, []string{"error_type"})
+	DLQWriteFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dlq_write_failures_total",
+		Help:      "Failed DLQ writes.",
+	})
+	// A non-zero PausedPartitions means messages pile up behind a DLQ that
+	// cannot be written and someone should look.
+	PausedPartitions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "paused_partitions",
+		Help:      "Partitions paused by a blocked DLQ write or the admin API.",
+	})
+	SchemaCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_registry_cache_requests_total",
+		Help:      "Codec lookups by result, hit or miss. A miss calls the schema registry.",
+	}, []string{"result"})
+)
+
+// ObserveLag records how far the subscriber was behind when it fetched msg.
+func ObserveLag(msg broker.Message) {
+	if msg.HighWatermark == 0 {
+		return
+	}
+	Lag.WithLabelValues(msg.Topic, strconv.Itoa(msg.Partition)).Set(float64(msg.HighWatermark - msg.Offset - 1))
+}
+
+// ForgetLag drops the lag of partitions this consumer no longer owns, so a
+// stale value does not linger after a rebalance.
+func ForgetLag(partitions []broker.TopicPartition) {
+	for _, tp := range partitions {
+		Lag.DeleteLabelValues(tp.Topic, strconv.Itoa(tp.Partition))
+	}
+}
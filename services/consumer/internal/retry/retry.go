@@ -0,0 +1,97 @@
+// Package retry describes the headers a message carries while it moves
+// through the delayed retry topics, so any consumer can tell how often it
+// was attempted, when it may be attempted again and where it came from.
+package retry
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/broker"
+)
+
+const (
+	HeaderAttempt           = "x-retry-attempt"
+	HeaderNotBefore         = "x-retry-not-before" // unix milliseconds
+	HeaderLastError         = "x-retry-last-error"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+)
+
+// Attempt returns how many times the message was retried already, 0 for a
+// message read from the source topic.
+func Attempt(msg broker.Message) int {
+	value, ok := msg.Header(HeaderAttempt)
+	if !ok {
+		return 0
+	}
+	attempt, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
+// NotBefore returns the time before which the message must not be
+// processed, or the zero time when it has no such header.
+func NotBefore(msg broker.Message) time.Time {
+	value, ok := msg.Header(HeaderNotBefore)
+	if !ok {
+		return time.Time{}
+	}
+	millis, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// Origin returns where the message was first read from, which for a retried
+// message is not the retry topic it arrived on.
+func Origin(msg broker.Message) (topic string, partition int, offset int64) {
+	topic, partition, offset = msg.Topic, msg.Partition, msg.Offset
+	if value, ok := msg.Header(HeaderOriginalTopic); ok {
+		topic = string(value)
+	}
+	if value, ok := msg.Header(HeaderOriginalPartition); ok {
+		if p, err := strconv.Atoi(string(value)); err == nil {
+			partition = p
+		}
+	}
+	if value, ok := msg.Header(HeaderOriginalOffset); ok {
+		if o, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			offset = o
+		}
+	}
+	return topic, partition, offset
+}
+
+// Schedule builds the copy of msg to publish on a retry topic: the attempt
+// is incremented, the not-before time set to now+delay and the origin kept.
+func Schedule(msg broker.Message, topic string, delay time.Duration, cause error, now time.Time) broker.Message {
+	origTopic, origPartition, origOffset := Origin(msg)
+	set := map[string]string{
+		HeaderAttempt:           strconv.Itoa(Attempt(msg) + 1),
+		HeaderNotBefore:         strconv.FormatInt(now.Add(delay).UnixMilli(), 10),
+		HeaderLastError:         cause.Error(),
+		HeaderOriginalTopic:     origTopic,
+		HeaderOriginalPartition: strconv.Itoa(origPartition),
+		HeaderOriginalOffset:    strconv.FormatInt(origOffset, 10),
+	}
+
+	out := broker.Message{
+		Topic: topic,
+		Key:   msg.Key,
+		Value: msg.Value,
+	}
+	for _, h := range msg.Headers {
+		if _, ok := set[h.Key]; !ok {
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	for _, key := range []string{HeaderAttempt, HeaderNotBefore, HeaderLastError, HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset} {
+		out.Headers = append(out.Headers, broker.Header{Key: key, Value: []byte(set[key])})
+	}
+	return out
+}
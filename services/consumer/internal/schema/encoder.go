@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 
+	"github.com/dzon2000/eda/pkg/logging"
 	"github.com/linkedin/goavro/v2"
 )
 
+var logger = logging.Logger("schema")
+
 type Encoder struct {
 	codec    *goavro.Codec
 	schemaID int
@@ -22,7 +24,7 @@ func NewEncoder(codec *goavro.Codec, schemaID int) (*Encoder, error) {
 }
 
 func (e *Encoder) Decode(schemaID int, payload []byte) (interface{}, error) {
-	log.Printf("Deserializing message with schema ID: %d", schemaID)
+	logger.Debug("Deserializing message", "schema_id", schemaID)
 
 	native, _, err := e.codec.NativeFromBinary(payload)
 	if err != nil {
@@ -2,34 +2,53 @@
 package schema
 
 import (
+	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/dzon2000/eda/consumer/internal/config"
+	"github.com/dzon2000/eda/consumer/internal/metrics"
 	"github.com/linkedin/goavro/v2"
 )
 
+// ErrSchemaNotFound is returned by GetCodec when the registry does not know
+// the schema ID, as opposed to the registry being unreachable.
+var ErrSchemaNotFound = errors.New("schema not found")
+
 type Registry struct {
 	config config.SchemaRegistryConfig
 	client *http.Client
-	cache  map[int]*goavro.Codec
+
+	mu    sync.RWMutex // guards cache, GetCodec is called from every worker
+	cache map[int]*goavro.Codec
 }
 
-func New(cfg config.SchemaRegistryConfig) *Registry {
+// New returns a registry client sending its requests through transport,
+// http.DefaultTransport when nil.
+func New(cfg config.SchemaRegistryConfig, transport http.RoundTripper) *Registry {
 	return &Registry{
 		config: cfg,
 		client: &http.Client{
-			Timeout: cfg.Timeout,
+			Transport: transport,
+			Timeout:   cfg.Timeout,
 		},
 		cache: make(map[int]*goavro.Codec),
 	}
 }
 
 func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
-	if codec, ok := r.cache[schemaID]; ok {
+	r.mu.RLock()
+	codec, ok := r.cache[schemaID]
+	r.mu.RUnlock()
+	if ok {
+		metrics.SchemaCache.WithLabelValues("hit").Inc()
 		return codec, nil
 	}
+	metrics.SchemaCache.WithLabelValues("miss").Inc()
 
 	url := fmt.Sprintf(
 		"%s/schemas/ids/%d",
@@ -42,6 +61,12 @@ func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: ID %d", ErrSchemaNotFound, schemaID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema registry returned %s for ID %d", resp.Status, schemaID)
+	}
 
 	var res struct {
 		Schema string `json:"schema"`
@@ -51,11 +76,52 @@ func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
 		return nil, err
 	}
 
-	codec, err := goavro.NewCodec(res.Schema)
+	codec, err = goavro.NewCodec(res.Schema)
 	if err != nil {
 		return nil, err
 	}
 
+	r.mu.Lock()
 	r.cache[schemaID] = codec
+	r.mu.Unlock()
 	return codec, nil
 }
+
+// Decode unwraps a Confluent framed message (magic byte, schema ID, Avro
+// payload) and decodes it with the schema it names.
+func (r *Registry) Decode(value []byte) (map[string]interface{}, error) {
+	if len(value) < 5 || value[0] != 0 {
+		return nil, fmt.Errorf("not a schema registry framed message")
+	}
+	schemaID := int(binary.BigEndian.Uint32(value[1:5]))
+	codec, err := r.GetCodec(schemaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get codec for schema ID %d: %w", schemaID, err)
+	}
+	native, _, err := codec.NativeFromBinary(value[5:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize message: %w", err)
+	}
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema ID %d is not a record", schemaID)
+	}
+	return record, nil
+}
+
+// Ping checks that the registry answers, without touching the codec cache.
+func (r *Registry) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.URL+"/subjects", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("schema registry returned %s", resp.Status)
+	}
+	return nil
+}
@@ -1,66 +1,173 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/dzon2000/eda/consumer/internal/admin"
 	"github.com/dzon2000/eda/consumer/internal/config"
 	"github.com/dzon2000/eda/consumer/internal/consumer"
 	"github.com/dzon2000/eda/consumer/internal/dlq"
+	"github.com/dzon2000/eda/consumer/internal/journal"
 	"github.com/dzon2000/eda/consumer/internal/schema"
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
 	"github.com/joho/godotenv"
+	"github.com/linkedin/goavro/v2"
 )
 
-func initializeDLQProducer(cfg *config.Config, encoder *schema.Encoder) (dlq.DLQProducer, error) {
-	dlqProducer := dlq.NewKafkaDLQProducer(cfg.Kafka, encoder)
-	return dlqProducer, nil
+var logger = logging.Logger("main")
+
+// fatal logs err and exits; slog has no Fatal.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
+// schemaFetchAttempts is how often the DLQ schema is fetched on start.
+const schemaFetchAttempts = 5
+
+func initializeDLQProducer(cfg *config.Config, b broker.Broker, encoder *schema.Encoder, registry *schema.Registry) (dlq.DLQProducer, error) {
+	publisher, err := b.Publisher(broker.PublisherConfig{Topic: cfg.Kafka.DLQTopic})
+	if err != nil {
+		return nil, err
+	}
+	return dlq.NewProducer(publisher, encoder, registry, cfg.Kafka.GroupID), nil
+}
+
+// initializeEncoder retries fetching the DLQ schema a few times, so a
+// registry that is still starting or briefly unavailable does not fail the
+// start.
 func initializeEncoder(registry *schema.Registry, cfg *config.Config) *schema.Encoder {
-	dlqCodec, err := registry.GetCodec(cfg.SchemaRegistry.DLQSchemaID)
+	var dlqCodec *goavro.Codec
+	var err error
+	for attempt := 1; ; attempt++ {
+		dlqCodec, err = registry.GetCodec(cfg.SchemaRegistry.DLQSchemaID)
+		if err == nil || errors.Is(err, schema.ErrSchemaNotFound) || attempt == schemaFetchAttempts {
+			break
+		}
+		logger.Warn("Failed to get codec from schema registry, retrying", "attempt", attempt, "error", err)
+		time.Sleep(time.Second)
+	}
 	if err != nil {
-		log.Fatalf("Failed to get codec from schema registry: %v", err)
+		fatal("Failed to get codec from schema registry", err)
 	}
 	encoder, _ := schema.NewEncoder(dlqCodec, cfg.SchemaRegistry.DLQSchemaID)
 	return encoder
 }
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Consumer Service")
 	_ = godotenv.Load(".env.development")
-	cfg, err := config.Load()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stdout); err != nil {
+		fatal("Failed to set up logging", err)
 	}
 
-	log.Printf("Starting consumer in %s environment", cfg.Environment)
-	log.Printf("Kafka brokers: %v", cfg.Kafka.Brokers)
-	log.Printf("Topic: %s", cfg.Kafka.Topic)
-	registry := schema.New(cfg.SchemaRegistry)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	logger.Info("Consumer Service starting",
+		"environment", cfg.Environment,
+		"brokers", cfg.Kafka.Brokers,
+		"topic", cfg.Kafka.Topic,
+		"group_id", cfg.Kafka.GroupID)
+	injector := chaos.New(cfg.Chaos)
+	registry := schema.New(cfg.SchemaRegistry, chaos.Transport(nil, injector))
+	kafkaBroker := chaos.Broker(broker.NewKafka(broker.KafkaConfig{
+		Brokers:    cfg.Kafka.Brokers,
+		MaxRetries: cfg.Kafka.MaxRetries,
+		MinBytes:   cfg.Kafka.MinBytes,
+		MaxBytes:   cfg.Kafka.MaxBytes,
+	}), injector)
 	dlqEncoder := initializeEncoder(registry, cfg)
-	dlqProducer, err := initializeDLQProducer(cfg, dlqEncoder)
+	dlqProducer, err := initializeDLQProducer(cfg, kafkaBroker, dlqEncoder, registry)
 	if err != nil {
-		log.Fatalf("Failed to initialize DLQ producer: %v", err)
+		fatal("Failed to initialize DLQ producer", err)
 	}
-	consumer, err := consumer.New(cfg.Kafka, registry, dlqProducer)
+	consumer, err := consumer.New(cfg.Kafka, cfg.Processing, kafkaBroker, registry, dlqProducer)
 	if err != nil {
-		log.Fatalf("Failed to create consumer: %v", err)
+		fatal("Failed to create consumer", err)
+	}
+	var processed *journal.Journal
+	if cfg.JournalFile != "" {
+		if processed, err = journal.Open(cfg.JournalFile); err != nil {
+			fatal("Failed to open journal", err)
+		}
+		consumer.OnProcessed(processed.Record)
+		logger.Info("Recording processed events", "file", cfg.JournalFile)
 	}
-	defer consumer.Stop()
 
 	// Handle shutdown signals
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	startErr := make(chan error, 1)
 	go func() {
-		if err := consumer.Start(); err != nil {
-			log.Fatalf("Consumer failed: %v", err)
+		startErr <- consumer.Start(ctx)
+	}()
+
+	checker := health.New(cfg.Health.CheckTimeout)
+	checker.Liveness("processing", consumer.CheckStalled(cfg.Health.StallTimeout))
+	checker.Readiness("kafka", consumer.Ping)
+	checker.Readiness("consumer_group", consumer.CheckMembership)
+	checker.Readiness("schema_registry", registry.Ping)
+	checker.Readiness("dlq", consumer.CheckDLQ)
+
+	adminServer := &http.Server{
+		Addr:    cfg.AdminAddr,
+		Handler: admin.NewHandler(consumer, checker, cfg.AdminToken).Router(),
+	}
+	go func() {
+		logger.Info("Admin API listening", "addr", cfg.AdminAddr)
+		if err := adminServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Admin API failed", "error", err)
 		}
 	}()
 
-	<-sigChan
-	log.Println("Shutting down gracefully...")
-	consumer.Stop()
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutting down gracefully")
+	case err := <-startErr:
+		if err != nil {
+			logger.Error("Consumer failed", "error", err)
+			exitCode = 1
+		}
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error shutting down admin API", "error", err)
+	}
+	if err := consumer.Stop(shutdownCtx); err != nil {
+		logger.Error("Error during shutdown", "error", err)
+	}
+	if processed != nil {
+		if err := processed.Close(); err != nil {
+			logger.Error("Failed to close journal", "error", err)
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	cancel()
+	logger.Info("Shutdown complete")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/outbox"
+	"github.com/dzon2000/eda/pkg/tracing"
+)
+
+type Config struct {
+	HTTPAddr        string // listen address of the API, /metrics and /health
+	DB              DBConfig
+	SchemaID        int           // registry ID of the schema OrderCreated events are written with
+	ShutdownTimeout time.Duration // how long in-flight requests may take to drain
+	Health          HealthConfig
+	Tracing         tracing.Config
+	Logging         logging.Config
+	Chaos           chaos.Config
+}
+
+// DBConfig is the database the order service shares with the producer's
+// outbox, configured with the same DB_* keys.
+type DBConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	DBName   string
+	// MigrateOnStart applies pending schema migrations before the service
+	// starts; otherwise they are applied with the migrate subcommand.
+	MigrateOnStart bool
+}
+
+// DSN is the pgx connection string, with user and password escaped.
+func (c DBConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     c.Host + ":" + strconv.Itoa(c.Port),
+		Path:     "/" + c.DBName,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration // per dependency check
+}
+
+// Load reads the configuration from the config file, the environment and
+// the command-line arguments args, see package conf. It returns
+// conf.ErrPrinted after --print-config.
+func Load(args []string) (*Config, error) {
+	l, err := conf.New(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg := &Config{
+		HTTPAddr:        l.String("HTTP_ADDR", ":8080"),
+		ShutdownTimeout: l.Duration("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DB: DBConfig{
+			Host:     l.String("DB_HOST", "localhost"),
+			Port:     l.Int("DB_PORT", 5432),
+			User:     l.String("DB_USER", "postgres"),
+			Password: l.Secret("DB_PASSWORD", "password"),
+			DBName:   l.String("DB_NAME", "producer_db"),
+
+			MigrateOnStart: l.Bool("DB_MIGRATE_ON_START", true),
+		},
+		SchemaID: l.Int("SCHEMA_REGISTRY_ID", outbox.DefaultSchemaID),
+		Health: HealthConfig{
+			CheckTimeout: l.Duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		},
+		Tracing: tracing.Config{
+			ServiceName: "order",
+			Exporter:    l.String("OTEL_TRACES_EXPORTER", tracing.ExporterNone),
+			File:        l.String("OTEL_TRACES_FILE", "traces-order.jsonl"),
+		},
+		Logging: loadLogging(l),
+		Chaos:   loadChaos(l),
+	}
+
+	if err := l.Finish(cfg.Validate); err != nil {
+		if errors.Is(err, conf.ErrPrinted) {
+			return cfg, err
+		}
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	if c.HTTPAddr == "" {
+		return fmt.Errorf("HTTP listen address is required")
+	}
+	if c.DB.Host == "" || c.DB.User == "" || c.DB.DBName == "" {
+		return fmt.Errorf("database host, user and name are required")
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		return fmt.Errorf("database port %d is out of range", c.DB.Port)
+	}
+	if c.SchemaID <= 0 {
+		return fmt.Errorf("schema registry ID must be positive")
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout must be positive")
+	}
+	if c.Health.CheckTimeout <= 0 {
+		return fmt.Errorf("health check timeout must be positive")
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	return c.Tracing.Validate()
+}
+
+// loadChaos reads CHAOS_FAULTS, see chaos.ParseRules, and CHAOS_SEED.
+func loadChaos(l *conf.Loader) chaos.Config {
+	rules, err := chaos.ParseRules(l.String("CHAOS_FAULTS", ""))
+	l.Check("CHAOS_FAULTS", err)
+	return chaos.Config{
+		Rules: rules,
+		Seed:  uint64(l.Int("CHAOS_SEED", 0)),
+	}
+}
+
+// loadLogging reads LOG_FORMAT, LOG_LEVEL, LOG_LEVELS (per-logger
+// overrides such as "web=debug") and LOG_REDACT.
+func loadLogging(l *conf.Loader) logging.Config {
+	level, err := logging.ParseLevel(l.String("LOG_LEVEL", "info"))
+	l.Check("LOG_LEVEL", err)
+	levels, err := logging.ParseLevels(l.String("LOG_LEVELS", ""))
+	l.Check("LOG_LEVELS", err)
+	return logging.Config{
+		Format: l.String("LOG_FORMAT", logging.FormatJSON),
+		Level:  level,
+		Levels: levels,
+		Redact: l.Strings("LOG_REDACT", []string{"customerId", "customer_id"}),
+	}
+}
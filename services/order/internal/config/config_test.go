@@ -0,0 +1,30 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// The order service stores orders in the database it is configured with,
+// so a malformed DB_PORT must stop it rather than fall back to the default.
+func TestLoadRefusesInvalidDatabasePort(t *testing.T) {
+	tests := []struct {
+		port string
+		err  string
+	}{
+		{"5432x", `invalid integer "5432x"`},
+		{"", `invalid integer ""`},
+		{"0", "database port 0 is out of range"},
+		{"65536", "database port 65536 is out of range"},
+	}
+	for _, tt := range tests {
+		_, err := Load([]string{"--db-port", tt.port})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Load with DB_PORT %q = %v, want an error containing %q", tt.port, err, tt.err)
+		}
+	}
+	cfg, err := Load([]string{"--db-port", "15432"})
+	if err != nil || cfg.DB.Port != 15432 {
+		t.Fatalf("Load = %+v, %v, want DB port 15432", cfg, err)
+	}
+}
@@ -5,42 +5,76 @@ import (
 	"database/sql"
 
 	"github.com/dzon2000/eda/order/internal/payload"
+	"github.com/dzon2000/eda/pkg/outbox"
+	"github.com/dzon2000/eda/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/dzon2000/eda/order/internal/db")
+
+const insertOrderQuery = `
+	INSERT INTO orders (id, customer_id, amount, discount, status)
+	VALUES ($1, $2, $3, $4, 'CREATED')
+	ON CONFLICT (id) DO NOTHING
+`
+
+// Queries are the repository's statements by name, for migrate check.
+var Queries = map[string]string{
+	"insert_order":  insertOrderQuery,
+	"insert_outbox": outbox.InsertOrderCreatedQuery,
+}
+
 type OrderRepository struct {
-	db *sql.DB
+	db       *sql.DB
+	schemaID int // of the OrderCreated payload, see config.Config
 }
 
-func NewOrderRepository(db *sql.DB) *OrderRepository {
-	return &OrderRepository{db: db}
+func NewOrderRepository(db *sql.DB, schemaID int) *OrderRepository {
+	return &OrderRepository{db: db, schemaID: schemaID}
 }
 
-func (r *OrderRepository) InsertOrder(ctx context.Context, tx *sql.Tx, req payload.CreateOrderRequest) error {
-	_, err := tx.ExecContext(ctx, `
-        INSERT INTO orders (order_id, customer_id, amount, discount, status)
-        VALUES ($1, $2, $3, $4, 'CREATED')
-        ON CONFLICT (order_id) DO NOTHING
-    `, req.OrderID, req.CustomerID, req.Amount, req.Discount)
-	return err
+// InsertOrder reports false when the order already exists, so a retried
+// request does not emit a second OrderCreated event.
+func (r *OrderRepository) InsertOrder(ctx context.Context, tx *sql.Tx, req payload.CreateOrderRequest) (bool, error) {
+	ctx, span := startSpan(ctx, "db.insert_order", "orders")
+	defer span.End()
+	res, err := tx.ExecContext(ctx, insertOrderQuery, req.OrderID, req.CustomerID, req.Amount, req.Discount)
+	if err != nil {
+		return false, tracing.Fail(span, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, tracing.Fail(span, err)
+	}
+	return n > 0, nil
 }
 
+// InsertOrderCreatedOutbox stores the event with the traceparent of the
+// request that created it, so the outbox publisher continues the trace.
 func (r *OrderRepository) InsertOrderCreatedOutbox(
 	ctx context.Context,
 	tx *sql.Tx,
+	eventID string,
+	orderID string,
 	payload []byte,
+	traceparent string,
 ) error {
-	_, err := tx.ExecContext(ctx, `
-        INSERT INTO outbox_events (
-            id, aggregate_type, aggregate_id,
-            event_type, payload, schema_version
-        ) VALUES (
-            gen_random_uuid(), 'order', $1,
-            'OrderCreated', $2, 1
-        )
-    `, extractOrderID(payload), payload)
-	return err
+	ctx, span := startSpan(ctx, "db.insert_outbox", "outbox_events")
+	defer span.End()
+	err := outbox.InsertOrderCreated(ctx, tx, eventID, orderID, payload, r.schemaID, traceparent)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
 }
 
-func extractOrderID(payload []byte) string {
-	return "etc"
+func startSpan(ctx context.Context, name, table string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.sql.table", table),
+		))
 }
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrderCreated is the outbox payload of a created order. Its JSON is read
+// back by the outbox publisher, which encodes it with the OrderCreated Avro
+// schema.
+type OrderCreated struct {
+	EventID    string   `json:"event_id"`
+	OrderID    string   `json:"order_id"`
+	CustomerID string   `json:"customer_id"`
+	Amount     float64  `json:"amount"`
+	Discount   *float64 `json:"discount,omitempty"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+func NewOrderCreated(orderID, customerID string, amount float64, discount *float64) OrderCreated {
+	return OrderCreated{
+		EventID:    generateEventID(),
+		OrderID:    orderID,
+		CustomerID: customerID,
+		Amount:     amount,
+		Discount:   discount,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func generateEventID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.New().String()
+	}
+	return id.String()
+}
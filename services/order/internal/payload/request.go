@@ -1,7 +1,22 @@
 package payload
 
-import "errors"
+import (
+	"strconv"
+	"strings"
 
+	"github.com/google/uuid"
+)
+
+// Bounds of the orders table's columns: amount is NUMERIC(10, 2) and
+// discount NUMERIC(5, 2).
+const (
+	maxAmount   = 99999999.99
+	maxDiscount = 999.99
+)
+
+// CreateOrderRequest is the body of POST /orders. Amounts carry no
+// currency, the service has one; a request naming a currency is refused as
+// having an unknown field rather than having it ignored.
 type CreateOrderRequest struct {
 	OrderID    string   `json:"order_id"`
 	CustomerID string   `json:"customer_id"`
@@ -9,15 +24,84 @@ type CreateOrderRequest struct {
 	Discount   *float64 `json:"discount,omitempty"`
 }
 
+// FieldError is a rule a field of a request breaks.
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// ValidationError lists every rule a request breaks.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + " " + fe.Detail
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate returns a ValidationError with every field the orders table or
+// the consumer would refuse.
 func (r CreateOrderRequest) Validate() error {
-	if r.OrderID == "" {
-		return errors.New("order_id is required")
+	var errs ValidationError
+	add := func(field, detail string) {
+		errs = append(errs, FieldError{Field: field, Detail: detail})
+	}
+
+	if detail := checkUUID(r.OrderID); detail != "" {
+		add("order_id", detail)
+	}
+	if detail := checkUUID(r.CustomerID); detail != "" {
+		add("customer_id", detail)
 	}
-	if r.CustomerID == "" {
-		return errors.New("customer_id is required")
+
+	switch {
+	case r.Amount <= 0:
+		add("amount", "must be positive")
+	case r.Amount > maxAmount:
+		add("amount", "must be at most "+strconv.FormatFloat(maxAmount, 'f', 2, 64))
+	case decimals(r.Amount) > 2:
+		add("amount", "must have at most 2 decimal places")
+	}
+
+	if r.Discount != nil {
+		d := *r.Discount
+		switch {
+		case d < 0:
+			add("discount", "must not be negative")
+		case d > maxDiscount:
+			add("discount", "must be at most "+strconv.FormatFloat(maxDiscount, 'f', 2, 64))
+		case r.Amount > 0 && d > r.Amount:
+			add("discount", "must not exceed amount")
+		case decimals(d) > 2:
+			add("discount", "must have at most 2 decimal places")
+		}
 	}
-	if r.Amount <= 0 {
-		return errors.New("amount must be positive")
+
+	if len(errs) > 0 {
+		return errs
 	}
 	return nil
 }
+
+// checkUUID returns why id is not a UUID in the hyphenated form, or "".
+func checkUUID(id string) string {
+	if id == "" {
+		return "is required"
+	}
+	if len(id) != 36 || uuid.Validate(id) != nil {
+		return "must be a UUID, e.g. 3f2504e0-4f89-41d3-9a0c-0305e82c3301"
+	}
+	return ""
+}
+
+// decimals counts the decimal places of v as written in the request: the
+// shortest decimal that reads back as v.
+func decimals(v float64) int {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package payload
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const (
+		orderID    = "3f2504e0-4f89-41d3-9a0c-0305e82c3301"
+		customerID = "0b5a7ab6-4f0c-4e0b-9a4e-6f3c2b1d8e7f"
+	)
+	discount := func(d float64) *float64 { return &d }
+	tests := []struct {
+		name   string
+		req    CreateOrderRequest
+		errors []FieldError // nil when the request is valid
+	}{
+		{"valid", CreateOrderRequest{orderID, customerID, 120.5, nil}, nil},
+		{"valid with discount", CreateOrderRequest{orderID, customerID, 120.5, discount(20.25)}, nil},
+		{"uppercase UUIDs", CreateOrderRequest{"3F2504E0-4F89-41D3-9A0C-0305E82C3301", customerID, 1, nil}, nil},
+		{"largest amount and discount", CreateOrderRequest{orderID, customerID, 99999999.99, discount(999.99)}, nil},
+		{"discount equal to amount", CreateOrderRequest{orderID, customerID, 10, discount(10)}, nil},
+		{"zero discount", CreateOrderRequest{orderID, customerID, 10, discount(0)}, nil},
+
+		{"missing IDs", CreateOrderRequest{"", "", 1, nil}, []FieldError{
+			{"order_id", "is required"},
+			{"customer_id", "is required"},
+		}},
+		{"IDs not UUIDs", CreateOrderRequest{"order-1", "3f2504e04f8941d39a0c0305e82c3301", 1, nil}, []FieldError{
+			{"order_id", "must be a UUID, e.g. 3f2504e0-4f89-41d3-9a0c-0305e82c3301"},
+			{"customer_id", "must be a UUID, e.g. 3f2504e0-4f89-41d3-9a0c-0305e82c3301"},
+		}},
+		{"braced UUID", CreateOrderRequest{"{" + orderID + "}", customerID, 1, nil}, []FieldError{
+			{"order_id", "must be a UUID, e.g. 3f2504e0-4f89-41d3-9a0c-0305e82c3301"},
+		}},
+
+		{"zero amount", CreateOrderRequest{orderID, customerID, 0, nil}, []FieldError{{"amount", "must be positive"}}},
+		{"negative amount", CreateOrderRequest{orderID, customerID, -5, nil}, []FieldError{{"amount", "must be positive"}}},
+		{"amount too large", CreateOrderRequest{orderID, customerID, 100000000, nil}, []FieldError{{"amount", "must be at most 99999999.99"}}},
+		{"amount with 3 decimals", CreateOrderRequest{orderID, customerID, 10.005, nil}, []FieldError{{"amount", "must have at most 2 decimal places"}}},
+
+		{"negative discount", CreateOrderRequest{orderID, customerID, 10, discount(-1)}, []FieldError{{"discount", "must not be negative"}}},
+		{"discount too large", CreateOrderRequest{orderID, customerID, 5000, discount(1000)}, []FieldError{{"discount", "must be at most 999.99"}}},
+		{"discount over amount", CreateOrderRequest{orderID, customerID, 10, discount(10.01)}, []FieldError{{"discount", "must not exceed amount"}}},
+		{"discount with 3 decimals", CreateOrderRequest{orderID, customerID, 10, discount(0.125)}, []FieldError{{"discount", "must have at most 2 decimal places"}}},
+		// Compared with an invalid amount, the discount is only checked on
+		// its own.
+		{"discount with invalid amount", CreateOrderRequest{orderID, customerID, 0, discount(5)}, []FieldError{{"amount", "must be positive"}}},
+
+		{"every field", CreateOrderRequest{"x", "", 10.001, discount(25)}, []FieldError{
+			{"order_id", "must be a UUID, e.g. 3f2504e0-4f89-41d3-9a0c-0305e82c3301"},
+			{"customer_id", "is required"},
+			{"amount", "must have at most 2 decimal places"},
+			{"discount", "must not exceed amount"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if tt.errors == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var invalid ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate = %v, want a ValidationError", err)
+			}
+			if !slices.Equal(invalid, tt.errors) {
+				t.Errorf("Validate = %v, want %v", invalid, ValidationError(tt.errors))
+			}
+		})
+	}
+}
@@ -1,51 +1,186 @@
 package web
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/dzon2000/eda/order/internal/db"
+	"github.com/dzon2000/eda/order/internal/events"
+	"github.com/dzon2000/eda/order/internal/metrics"
 	"github.com/dzon2000/eda/order/internal/payload"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+var logger = logging.Logger("web")
+
+// maxBodyBytes bounds a request body; an order takes a few hundred bytes.
+const maxBodyBytes = 64 << 10
+
 type Handler struct {
-	orderRepository db.OrderRepository
+	orderRepository *db.OrderRepository
 	db              *sql.DB
+	health          *health.Checker
 }
 
-func NewHandler() *Handler {
-	return &Handler{}
+// NewHandler writes OrderCreated events with the registry schema schemaID.
+func NewHandler(database *sql.DB, schemaID int, checker *health.Checker) *Handler {
+	return &Handler{
+		orderRepository: db.NewOrderRepository(database, schemaID),
+		db:              database,
+		health:          checker,
+	}
 }
 
 func (h *Handler) Router() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /orders", h.CreateOrder)
-	mux.HandleFunc("GET /health", h.Health)
+	// The server span started here is the root of the order's trace, which
+	// continues through the outbox into the consumer.
+	mux.Handle("POST /orders", otelhttp.NewHandler(
+		metrics.Instrument("/orders", http.HandlerFunc(h.CreateOrder)), "POST /orders"))
+	// /health predates the split probes and stays an alias of liveness.
+	mux.Handle("GET /health", h.health.LiveHandler())
+	h.health.Register(mux)
+	mux.Handle("GET /metrics", promhttp.Handler())
 	return mux
 }
 
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	var req payload.CreateOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	if err := req.Validate(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		var invalid payload.ValidationError
+		errors.As(err, &invalid)
+		respondProblem(w, http.StatusBadRequest, "the order is invalid", invalid...)
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, payload.CreateOrderResponse{
+	created, err := h.createOrder(r.Context(), req)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to create order", "order_id", req.OrderID, "error", err)
+		respondProblem(w, http.StatusInternalServerError, "failed to create order")
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK // a retried request for an order that already exists
+	}
+	respondJSON(w, status, payload.CreateOrderResponse{
 		OrderID: req.OrderID,
 		Status:  "CREATED",
 	})
 }
 
-func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+// createOrder stores the order and its OrderCreated outbox event in one
+// transaction. It reports false, writing nothing, when the order exists.
+func (h *Handler) createOrder(ctx context.Context, req payload.CreateOrderRequest) (bool, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	created, err := h.orderRepository.InsertOrder(ctx, tx, req)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert order: %w", err)
+	}
+	if !created {
+		return false, nil
+	}
+
+	event := events.NewOrderCreated(req.OrderID, req.CustomerID, req.Amount, req.Discount)
+	eventPayload, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal OrderCreated event: %w", err)
+	}
+	if err := h.orderRepository.InsertOrderCreatedOutbox(ctx, tx, event.EventID, req.OrderID, eventPayload, tracing.Traceparent(ctx)); err != nil {
+		return false, fmt.Errorf("failed to insert outbox event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	logger.InfoContext(ctx, "Order created", "order_id", req.OrderID, "event_id", event.EventID)
+	return true, nil
+}
+
+// decodeJSON reads the request's JSON object into v, refusing other
+// content types, oversized bodies, unknown fields and trailing data. It
+// responds with a problem and returns false when the body is refused.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		respondProblem(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&json.RawMessage{}) != io.EOF {
+		respondProblem(w, http.StatusBadRequest, "body must be a single JSON object")
+		return false
+	}
+
+	var (
+		tooLarge   *http.MaxBytesError
+		syntaxErr  *json.SyntaxError
+		typeErr    *json.UnmarshalTypeError
+		unknownErr = "json: unknown field "
+	)
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &tooLarge):
+		respondProblem(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", tooLarge.Limit))
+	case errors.As(err, &syntaxErr):
+		respondProblem(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON at offset %d: %v", syntaxErr.Offset, err))
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		respondProblem(w, http.StatusBadRequest, "body is not a complete JSON object")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondProblem(w, http.StatusBadRequest, "the order is invalid",
+			payload.FieldError{Field: typeErr.Field, Detail: "must be a " + jsonType(typeErr.Type)})
+	case strings.HasPrefix(err.Error(), unknownErr):
+		// encoding/json has no error type for unknown fields.
+		field := strings.TrimPrefix(err.Error(), unknownErr)
+		if unquoted, err := strconv.Unquote(field); err == nil {
+			field = unquoted
+		}
+		respondProblem(w, http.StatusBadRequest, "the order is invalid",
+			payload.FieldError{Field: field, Detail: "is not a known field"})
+	default:
+		respondProblem(w, http.StatusBadRequest, "body must be a JSON object")
+	}
+	return false
+}
+
+// jsonType names the JSON type decoded into a Go type.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "number"
+	default:
+		return t.Kind().String()
+	}
 }
 
 func respondJSON(w http.ResponseWriter, httpStatus int, createOrderResponse payload.CreateOrderResponse) {
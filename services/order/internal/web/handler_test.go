@@ -0,0 +1,93 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dzon2000/eda/order/internal/payload"
+)
+
+func TestCreateOrderRefusesRequests(t *testing.T) {
+	const (
+		orderID    = "3f2504e0-4f89-41d3-9a0c-0305e82c3301"
+		customerID = "0b5a7ab6-4f0c-4e0b-9a4e-6f3c2b1d8e7f"
+	)
+	valid := `{"order_id": "` + orderID + `", "customer_id": "` + customerID + `", "amount": 10}`
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		detail      string   // a substring of the problem's detail
+		fields      []string // of the problem's errors
+	}{
+		{"no content type", "", valid, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil},
+		{"text", "text/plain", valid, http.StatusUnsupportedMediaType, "Content-Type must be application/json", nil},
+		{"too large", "application/json", `{"order_id": "` + strings.Repeat("a", maxBodyBytes) + `"}`,
+			http.StatusRequestEntityTooLarge, "body must be at most 65536 bytes", nil},
+		{"empty", "application/json", "", http.StatusBadRequest, "body is not a complete JSON object", nil},
+		{"truncated", "application/json", `{"order_id": "`, http.StatusBadRequest, "body is not a complete JSON object", nil},
+		{"syntax error", "application/json", `{"order_id": }`, http.StatusBadRequest, "invalid JSON at offset", nil},
+		{"not an object", "application/json", `[1, 2]`, http.StatusBadRequest, "body must be a JSON object", nil},
+		{"trailing object", "application/json", valid + ` {}`, http.StatusBadRequest, "body must be a single JSON object", nil},
+		{"trailing garbage", "application/json", valid + ` x`, http.StatusBadRequest, "body must be a single JSON object", nil},
+		{"unknown field", "application/json", `{"order_id": "` + orderID + `", "currency": "EUR"}`,
+			http.StatusBadRequest, "the order is invalid", []string{"currency"}},
+		{"type mismatch", "application/json", `{"order_id": "` + orderID + `", "amount": "ten"}`,
+			http.StatusBadRequest, "the order is invalid", []string{"amount"}},
+		{"string for UUID", "application/json", `{"order_id": 42}`,
+			http.StatusBadRequest, "the order is invalid", []string{"order_id"}},
+		{"every field invalid", "application/json; charset=utf-8", `{"order_id": "x", "amount": 10.001, "discount": 25}`,
+			http.StatusBadRequest, "the order is invalid", []string{"order_id", "customer_id", "amount", "discount"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Refused requests never reach the database.
+			h := &Handler{}
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.CreateOrder(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", ct)
+			}
+			var p struct {
+				Type   string
+				Title  string
+				Status int
+				Detail string
+				Errors []payload.FieldError
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatalf("body is not a problem: %v: %s", err, rec.Body)
+			}
+			if p.Type != "about:blank" || p.Title != http.StatusText(tt.status) || p.Status != tt.status {
+				t.Errorf("problem type %q, title %q, status %d, want about:blank, %q, %d",
+					p.Type, p.Title, p.Status, http.StatusText(tt.status), tt.status)
+			}
+			if !strings.Contains(p.Detail, tt.detail) {
+				t.Errorf("detail = %q, want it to contain %q", p.Detail, tt.detail)
+			}
+			var fields []string
+			for _, e := range p.Errors {
+				fields = append(fields, e.Field)
+				if e.Detail == "" {
+					t.Errorf("error of field %s has no detail", e.Field)
+				}
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("problem fields = %v, want %v: %s", fields, tt.fields, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dzon2000/eda/order/internal/payload"
+)
+
+// problem is an RFC 7807 problem details object. Problems have no type of
+// their own, about:blank, so the title is the status text; errors lists
+// the fields of a request that failed validation.
+type problem struct {
+	Type   string               `json:"type"`
+	Title  string               `json:"title"`
+	Status int                  `json:"status"`
+	Detail string               `json:"detail,omitempty"`
+	Errors []payload.FieldError `json:"errors,omitempty"`
+}
+
+func respondProblem(w http.ResponseWriter, status int, detail string, errs ...payload.FieldError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Errors: errs,
+	})
+}
@@ -1,17 +1,147 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/dzon2000/eda/order/internal/config"
+	"github.com/dzon2000/eda/order/internal/db"
 	"github.com/dzon2000/eda/order/internal/web"
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/migrate"
+	"github.com/dzon2000/eda/pkg/tracing"
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+var logger = logging.Logger("main")
+
+// fatal logs err and exits; slog has no Fatal.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stdout); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	if cfg.DB.MigrateOnStart {
+		if err := migrateOnStart(context.Background(), cfg.DB.DSN()); err != nil {
+			fatal("Failed to migrate database", err)
+		}
+	}
+	dbPool, err := chaos.OpenDB("pgx", cfg.DB.DSN(), chaos.New(cfg.Chaos))
+	if err != nil {
+		fatal("Failed to open database", err)
+	}
+
+	checker := health.New(cfg.Health.CheckTimeout)
+	checker.Readiness("postgres", dbPool.PingContext)
+
 	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: web.NewHandler().Router(),
+		Addr:    cfg.HTTPAddr,
+		Handler: web.NewHandler(dbPool, cfg.SchemaID, checker).Router(),
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", err)
+		}
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("Shutting down, draining in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error during shutdown", "error", err)
+	}
+	if err := dbPool.Close(); err != nil {
+		logger.Error("Failed to close database pool", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	logger.Info("Shutdown complete")
+}
+
+// runMigrate implements "order migrate <command> [flags]", see
+// migrate.Usage. check prepares the queries of the order repository.
+func runMigrate(args []string) {
+	command, flags := migrate.SplitArgs(args)
+	cfg, err := config.Load(flags)
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stderr); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	dbPool, err := sql.Open("pgx", cfg.DB.DSN())
+	if err != nil {
+		fatal("Failed to open database", err)
+	}
+	defer dbPool.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := migrate.Run(ctx, dbPool, command, db.Queries, os.Stdout); err != nil {
+		fatal("Migration failed", err)
+	}
+}
+
+// migrateOnStart applies pending migrations. Instances starting together
+// wait for each other on the migration lock. It uses a pool of its own, so
+// injected faults cannot fail the start.
+func migrateOnStart(ctx context.Context, dsn string) error {
+	dbPool, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer dbPool.Close()
+	m, err := migrate.New(dbPool)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(ctx)
+	for _, migration := range applied {
+		logger.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+	}
+	return err
 }
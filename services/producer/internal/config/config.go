@@ -1,75 +1,177 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"net/url"
 	"strconv"
-	"strings"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/tracing"
 )
 
 type Config struct {
-	Kafka          KafkaConfig
-	Schema         SchemaConfig
-	Environment    string
-	ProducerConfig ProducerConfig
-	DB             DBConfig
+	Kafka           KafkaConfig
+	Schema          SchemaConfig
+	Environment     string
+	ProducerConfig  ProducerConfig
+	DB              DBConfig
+	Retention       RetentionConfig
+	ShutdownTimeout time.Duration // how long the batch in flight may take to drain
+	MetricsAddr     string        // listen address of the /metrics and /health endpoints
+	Health          HealthConfig
+	Tracing         tracing.Config
+	Logging         logging.Config
+	Chaos           chaos.Config
 }
 
 type KafkaConfig struct {
-	Brokers    []string
-	Topic      string
-	MaxRetries int
+	Brokers         []string
+	Topic           string
+	MaxRetries      int
+	ProducerMode    string
+	TransactionalID string // required in transactional mode, stable per producer instance
 }
 
+const (
+	ProducerModeDefault       = "default"       // at-least-once, retries may duplicate
+	ProducerModeIdempotent    = "idempotent"    // no duplicates from internal retries
+	ProducerModeTransactional = "transactional" // one Kafka transaction per outbox batch
+)
+
 type DBConfig struct {
 	Host     string
 	Port     int
 	User     string
 	Password string
 	DBName   string
+	// MigrateOnStart applies pending schema migrations before the service
+	// starts; otherwise they are applied with the migrate subcommand.
+	MigrateOnStart bool
+}
+
+// DSN is the pgx connection string, with user and password escaped.
+func (c DBConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     c.Host + ":" + strconv.Itoa(c.Port),
+		Path:     "/" + c.DBName,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
 }
 
 type SchemaConfig struct {
 	FilePath string // Path to .avsc file
 	SchemaID int
 	URL      string
-	Timeout  string
+	Timeout  time.Duration
 }
 
 type ProducerConfig struct {
-	MaxRetries int
+	MaxRetries         int           // failed attempts to publish an event before it is marked ERROR
+	RetryBackoff       time.Duration // first delay after a failed batch, doubles up to MaxRetryBackoff
+	MaxRetryBackoff    time.Duration
+	BreakerThreshold   int           // consecutive failures before a dependency is considered down
+	BreakerOpenTimeout time.Duration // how long to fail fast before probing the dependency again
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration // per dependency check
+	// StallTimeout is how long one publish batch may run before the relay
+	// is reported as wedged and fails its liveness probe.
+	StallTimeout time.Duration
+	// The relay is not ready while more events than BacklogMaxPending are
+	// PENDING, or the oldest of them is older than BacklogMaxAge.
+	BacklogMaxPending int
+	BacklogMaxAge     time.Duration
+}
+
+const (
+	RetentionModeArchive  = "archive"  // move PUBLISHED rows into outbox_events_archive
+	RetentionModeDelete   = "delete"   // drop PUBLISHED rows
+	RetentionModeDisabled = "disabled" // keep everything in outbox_events
+)
+
+type RetentionConfig struct {
+	Mode      string
+	MaxAge    time.Duration // PUBLISHED rows older than this are purged
+	BatchSize int           // rows moved per statement, keeps row locks short
+	Interval  time.Duration
 }
 
-func Load() (*Config, error) {
+// Load reads the configuration from the config file, the environment and
+// the command-line arguments args, see package conf. It returns
+// conf.ErrPrinted after --print-config.
+func Load(args []string) (*Config, error) {
+	l, err := conf.New(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 	cfg := &Config{
-		Environment: getEnv("ENVIRONMENT", "development"),
+		Environment:     l.String("ENVIRONMENT", "development"),
+		ShutdownTimeout: l.Duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		MetricsAddr:     l.String("METRICS_HTTP_ADDR", ":8083"),
+		Health: HealthConfig{
+			CheckTimeout:      l.Duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			StallTimeout:      l.Duration("HEALTH_STALL_TIMEOUT", 2*time.Minute),
+			BacklogMaxPending: l.Int("HEALTH_BACKLOG_MAX_PENDING", 10000),
+			BacklogMaxAge:     l.Duration("HEALTH_BACKLOG_MAX_AGE", 5*time.Minute),
+		},
+		Tracing: tracing.Config{
+			ServiceName: "producer",
+			Exporter:    l.String("OTEL_TRACES_EXPORTER", tracing.ExporterNone),
+			File:        l.String("OTEL_TRACES_FILE", "traces-producer.jsonl"),
+		},
 		Kafka: KafkaConfig{
-			Brokers:    getBrokersFromEnv(),
-			Topic:      getEnv("KAFKA_TOPIC", "orders.v1"),
-			MaxRetries: getEnvAsInt("KAFKA_MAX_RETRIES", 10),
+			Brokers:         l.Strings("KAFKA_BROKERS", []string{"kafka:9092"}),
+			Topic:           l.String("KAFKA_TOPIC", "orders.v1"),
+			MaxRetries:      l.Int("KAFKA_MAX_RETRIES", 10),
+			ProducerMode:    l.String("KAFKA_PRODUCER_MODE", ProducerModeDefault),
+			TransactionalID: l.String("KAFKA_TRANSACTIONAL_ID", ""),
 		},
 		DB: DBConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvAsInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "password"),
-			DBName:   getEnv("DB_NAME", "producer_db"),
+			Host:     l.String("DB_HOST", "localhost"),
+			Port:     l.Int("DB_PORT", 5432),
+			User:     l.String("DB_USER", "postgres"),
+			Password: l.Secret("DB_PASSWORD", "password"),
+			DBName:   l.String("DB_NAME", "producer_db"),
+
+			MigrateOnStart: l.Bool("DB_MIGRATE_ON_START", true),
 		},
 		Schema: SchemaConfig{
-			FilePath: getEnv("SCHEMA_REGISTRY_FILE_PATH", "order_created.avsc"),
-			SchemaID: getEnvAsInt("SCHEMA_REGISTRY_ID", 3),
-			URL:      getEnv("SCHEMA_REGISTRY_URL", "http://schema-registry:8081"),
-			Timeout:  getEnv("SCHEMA_REGISTRY_TIMEOUT", "10s"),
+			FilePath: l.String("SCHEMA_REGISTRY_FILE_PATH", "order_created.avsc"),
+			SchemaID: l.Int("SCHEMA_REGISTRY_ID", 3),
+			URL:      l.String("SCHEMA_REGISTRY_URL", "http://schema-registry:8081"),
+			Timeout:  l.Duration("SCHEMA_REGISTRY_TIMEOUT", 10*time.Second),
 		},
 		ProducerConfig: ProducerConfig{
-			MaxRetries: getEnvAsInt("PRODUCER_MAX_RETRIES", 5),
+			MaxRetries:         l.Int("PRODUCER_MAX_RETRIES", 5),
+			RetryBackoff:       l.Duration("PRODUCER_RETRY_BACKOFF", 500*time.Millisecond),
+			MaxRetryBackoff:    l.Duration("PRODUCER_MAX_RETRY_BACKOFF", 30*time.Second),
+			BreakerThreshold:   l.Int("PRODUCER_BREAKER_THRESHOLD", 5),
+			BreakerOpenTimeout: l.Duration("PRODUCER_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+		},
+		Retention: RetentionConfig{
+			Mode:      l.String("OUTBOX_RETENTION_MODE", RetentionModeArchive),
+			MaxAge:    l.Duration("OUTBOX_RETENTION_MAX_AGE", 7*24*time.Hour),
+			BatchSize: l.Int("OUTBOX_RETENTION_BATCH_SIZE", 500),
+			Interval:  l.Duration("OUTBOX_RETENTION_INTERVAL", time.Minute),
 		},
+		Logging: loadLogging(l),
+		Chaos:   loadChaos(l),
 	}
 
-	if err := cfg.Validate(); err != nil {
+	if err := l.Finish(cfg.Validate); err != nil {
+		if errors.Is(err, conf.ErrPrinted) {
+			return cfg, err
+		}
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
-
 	return cfg, nil
 }
 
@@ -80,26 +182,85 @@ func (c *Config) Validate() error {
 	if c.Kafka.Topic == "" {
 		return fmt.Errorf("Kafka topic is required")
 	}
+	if c.DB.Host == "" || c.DB.User == "" || c.DB.DBName == "" {
+		return fmt.Errorf("database host, user and name are required")
+	}
+	if c.DB.Port <= 0 || c.DB.Port > 65535 {
+		return fmt.Errorf("database port %d is out of range", c.DB.Port)
+	}
+	if c.Schema.URL == "" {
+		return fmt.Errorf("Schema Registry URL is required")
+	}
+	if c.Schema.Timeout <= 0 {
+		return fmt.Errorf("Schema Registry timeout must be positive")
+	}
+	switch c.Kafka.ProducerMode {
+	case ProducerModeDefault, ProducerModeIdempotent:
+	case ProducerModeTransactional:
+		if c.Kafka.TransactionalID == "" {
+			return fmt.Errorf("Kafka transactional ID is required in transactional mode")
+		}
+	default:
+		return fmt.Errorf("unknown Kafka producer mode %q", c.Kafka.ProducerMode)
+	}
+	if c.ProducerConfig.RetryBackoff <= 0 || c.ProducerConfig.MaxRetryBackoff < c.ProducerConfig.RetryBackoff {
+		return fmt.Errorf("producer retry backoff must be positive and not exceed the max retry backoff")
+	}
+	if c.ProducerConfig.MaxRetries <= 0 {
+		return fmt.Errorf("producer max retries must be positive")
+	}
+	if c.ProducerConfig.BreakerThreshold <= 0 {
+		return fmt.Errorf("producer breaker threshold must be positive")
+	}
+	if c.Health.CheckTimeout <= 0 || c.Health.StallTimeout <= 0 {
+		return fmt.Errorf("health check and stall timeouts must be positive")
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	switch c.Retention.Mode {
+	case RetentionModeArchive, RetentionModeDelete, RetentionModeDisabled:
+	default:
+		return fmt.Errorf("unknown outbox retention mode %q", c.Retention.Mode)
+	}
+	if c.Retention.Mode != RetentionModeDisabled {
+		if c.Retention.MaxAge <= 0 {
+			return fmt.Errorf("outbox retention max age must be positive")
+		}
+		if c.Retention.BatchSize <= 0 {
+			return fmt.Errorf("outbox retention batch size must be positive")
+		}
+		if c.Retention.Interval <= 0 {
+			return fmt.Errorf("outbox retention interval must be positive")
+		}
+	}
 	return nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// loadChaos reads CHAOS_FAULTS, see chaos.ParseRules, and CHAOS_SEED.
+func loadChaos(l *conf.Loader) chaos.Config {
+	rules, err := chaos.ParseRules(l.String("CHAOS_FAULTS", ""))
+	l.Check("CHAOS_FAULTS", err)
+	return chaos.Config{
+		Rules: rules,
+		Seed:  uint64(l.Int("CHAOS_SEED", 0)),
 	}
-	return defaultValue
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
-		}
+// loadLogging reads LOG_FORMAT, LOG_LEVEL, LOG_LEVELS (per-logger
+// overrides such as "publisher=debug,retention=warn") and LOG_REDACT.
+func loadLogging(l *conf.Loader) logging.Config {
+	level, err := logging.ParseLevel(l.String("LOG_LEVEL", "info"))
+	l.Check("LOG_LEVEL", err)
+	levels, err := logging.ParseLevels(l.String("LOG_LEVELS", ""))
+	l.Check("LOG_LEVELS", err)
+	return logging.Config{
+		Format: l.String("LOG_FORMAT", logging.FormatJSON),
+		Level:  level,
+		Levels: levels,
+		Redact: l.Strings("LOG_REDACT", []string{"customerId", "customer_id"}),
 	}
-	return defaultValue
-}
-
-func getBrokersFromEnv() []string {
-	brokers := getEnv("KAFKA_BROKERS", "kafka:9092")
-	return strings.Split(brokers, ",")
 }
@@ -3,11 +3,100 @@ package db
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
+	"github.com/dzon2000/eda/pkg/tracing"
 	"github.com/dzon2000/eda/producer/internal/events"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/dzon2000/eda/producer/internal/db")
+
+// Statements of the repository, listed in Queries. The DDL creating an
+// archive partition is built per month and cannot be listed.
+const (
+	fetchPendingQuery = `
+	SELECT id, aggregate_type, aggregate_id, event_type, payload, schema_version, created_at,
+	    COALESCE(traceparent, '')
+	FROM outbox_events
+	WHERE status = 'PENDING'
+	ORDER BY created_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+`
+	backlogQuery = `
+	SELECT count(*), min(created_at)
+	FROM outbox_events
+	WHERE status = 'PENDING'
+`
+	markSentQuery = `
+	UPDATE outbox_events
+	SET status = 'PUBLISHED', published_at = NOW()
+	WHERE id = $1
+`
+	markFailedQuery = `
+	UPDATE outbox_events
+	SET attempts = attempts + 1, last_error = $2, updated_at = NOW(),
+	    status = CASE WHEN attempts + 1 >= $3 THEN 'ERROR' ELSE status END
+	WHERE id = $1
+	RETURNING status = 'ERROR'
+`
+	oldestPublishedQuery = `
+	SELECT MIN(published_at)
+	FROM outbox_events
+	WHERE status = 'PUBLISHED' AND published_at < $1
+`
+	archivePublishedQuery = `
+	WITH batch AS (
+		SELECT id
+		FROM outbox_events
+		WHERE status = 'PUBLISHED' AND published_at < $1
+		ORDER BY published_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	), moved AS (
+		DELETE FROM outbox_events o
+		USING batch
+		WHERE o.id = batch.id
+		RETURNING o.id, o.aggregate_type, o.aggregate_id, o.event_type,
+			o.payload, o.schema_version, o.status, o.created_at, o.published_at,
+			o.traceparent
+	)
+	INSERT INTO outbox_events_archive (
+		id, aggregate_type, aggregate_id, event_type,
+		payload, schema_version, status, created_at, published_at,
+		traceparent
+	)
+	SELECT * FROM moved
+`
+	deletePublishedQuery = `
+	DELETE FROM outbox_events
+	WHERE id IN (
+		SELECT id
+		FROM outbox_events
+		WHERE status = 'PUBLISHED' AND published_at < $1
+		ORDER BY published_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+`
+)
+
+// Queries are the repository's statements by name, for migrate check.
+var Queries = map[string]string{
+	"fetch_pending":     fetchPendingQuery,
+	"backlog":           backlogQuery,
+	"mark_sent":         markSentQuery,
+	"mark_failed":       markFailedQuery,
+	"oldest_published":  oldestPublishedQuery,
+	"archive_published": archivePublishedQuery,
+	"delete_published":  deletePublishedQuery,
+}
+
 type OutboxRepository struct {
 	db *sql.DB
 }
@@ -20,15 +109,13 @@ func (r *OutboxRepository) FetchPending(
 	ctx context.Context,
 	tx *sql.Tx,
 	limit int,
-) ([]events.OutboxEvent, error) {
-	rows, err := tx.QueryContext(ctx, `
-		SELECT id, aggregate_type, aggregate_id, event_type, payload, schema_version, created_at
-        FROM outbox_events
-        WHERE status = 'PENDING'
-        ORDER BY created_at
-        LIMIT $1
-        FOR UPDATE SKIP LOCKED
-	`, limit)
+) (_ []events.OutboxEvent, err error) {
+	ctx, span := startSpan(ctx, "db.fetch_pending")
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	rows, err := tx.QueryContext(ctx, fetchPendingQuery, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +132,7 @@ func (r *OutboxRepository) FetchPending(
 			&event.Payload,
 			&event.SchemaVersion,
 			&event.CreatedAt,
+			&event.Traceparent,
 		); err != nil {
 			return nil, err
 		}
@@ -53,28 +141,106 @@ func (r *OutboxRepository) FetchPending(
 	return eventsList, rows.Err()
 }
 
+// Backlog returns the number of PENDING events and the creation time of the
+// oldest one, zero when there is none.
+func (r *OutboxRepository) Backlog(ctx context.Context) (int64, time.Time, error) {
+	var (
+		pending int64
+		oldest  sql.NullTime
+	)
+	err := r.db.QueryRowContext(ctx, backlogQuery).Scan(&pending, &oldest)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to query outbox backlog: %w", err)
+	}
+	return pending, oldest.Time, nil
+}
+
 func (r *OutboxRepository) MarkSent(
 	ctx context.Context,
 	tx *sql.Tx,
 	eventID uuid.UUID,
 ) error {
-	_, err := tx.ExecContext(ctx, `
-		UPDATE outbox_events
-		SET status = 'PUBLISHED', published_at = NOW()
-		WHERE id = $1
-	`, eventID)
-	return err
+	ctx, span := startSpan(ctx, "db.mark_sent")
+	defer span.End()
+	_, err := tx.ExecContext(ctx, markSentQuery, eventID)
+	return tracing.Fail(span, err)
 }
-func (r *OutboxRepository) MarkError(
+
+// MarkFailed records a failed attempt to publish the event. The event stays
+// PENDING until maxAttempts attempts failed, then it is marked ERROR and
+// gaveUp is true.
+func (r *OutboxRepository) MarkFailed(
 	ctx context.Context,
 	tx *sql.Tx,
 	eventID uuid.UUID,
 	cause error,
-) error {
-	_, err := tx.ExecContext(ctx, `
-		UPDATE outbox_events
-		SET status = 'ERROR', last_error = $2, updated_at = NOW()
-		WHERE id = $1
-	`, eventID, cause.Error())
+	maxAttempts int,
+) (gaveUp bool, err error) {
+	ctx, span := startSpan(ctx, "db.mark_failed")
+	defer span.End()
+	err = tx.QueryRowContext(ctx, markFailedQuery, eventID, cause.Error(), maxAttempts).Scan(&gaveUp)
+	return gaveUp, tracing.Fail(span, err)
+}
+
+// OldestPublished returns the published_at of the oldest PUBLISHED row
+// older than the cutoff. ok is false when there is nothing to purge.
+func (r *OutboxRepository) OldestPublished(
+	ctx context.Context,
+	publishedBefore time.Time,
+) (oldest time.Time, ok bool, err error) {
+	var ts sql.NullTime
+	err = r.db.QueryRowContext(ctx, oldestPublishedQuery, publishedBefore).Scan(&ts)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return ts.Time, ts.Valid, nil
+}
+
+// EnsureArchivePartition creates the monthly partition of
+// outbox_events_archive that holds rows published in the month of t.
+func (r *OutboxRepository) EnsureArchivePartition(ctx context.Context, t time.Time) error {
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS outbox_events_archive_%04d_%02d
+		PARTITION OF outbox_events_archive
+		FOR VALUES FROM ('%s') TO ('%s')
+	`, from.Year(), from.Month(), from.Format(time.RFC3339), to.Format(time.RFC3339)))
 	return err
 }
+
+// ArchivePublished moves up to limit PUBLISHED rows older than the cutoff
+// into outbox_events_archive in a single statement.
+func (r *OutboxRepository) ArchivePublished(
+	ctx context.Context,
+	publishedBefore time.Time,
+	limit int,
+) (int64, error) {
+	res, err := r.db.ExecContext(ctx, archivePublishedQuery, publishedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeletePublished drops up to limit PUBLISHED rows older than the cutoff.
+func (r *OutboxRepository) DeletePublished(
+	ctx context.Context,
+	publishedBefore time.Time,
+	limit int,
+) (int64, error) {
+	res, err := r.db.ExecContext(ctx, deletePublishedQuery, publishedBefore, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.sql.table", "outbox_events"),
+		))
+}
@@ -14,6 +14,7 @@ type OutboxEvent struct {
 	Payload       []byte // JSON from DB
 	SchemaVersion int
 	CreatedAt     time.Time
+	Traceparent   string // W3C trace context of the request that wrote the event, may be empty
 }
 
 func NewOutboxEvent(
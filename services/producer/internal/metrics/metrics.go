@@ -0,0 +1,136 @@
+// Package metrics defines the outbox publisher's Prometheus metrics. They
+// are registered with the default registry and served on /metrics.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/producer/internal/resilience"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "producer"
+
+var logger = logging.Logger("metrics")
+
+var (
+	PublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "publish_duration_seconds",
+		Help:      "Time to encode and publish one outbox event to Kafka.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	Published = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "published_events_total",
+		Help:      "Outbox events published to Kafka.",
+	})
+	PublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "publish_failures_total",
+		Help:      "Outbox events that failed to publish, for any reason.",
+	})
+	GaveUp = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failed_events_total",
+		Help:      "Outbox events marked ERROR after failing PRODUCER_MAX_RETRIES times.",
+	})
+	KafkaWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_write_errors_total",
+		Help:      "Failed Kafka produce and transaction calls.",
+	})
+	SchemaCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_registry_cache_requests_total",
+		Help:      "Codec lookups by result, hit or miss. A miss calls the schema registry.",
+	}, []string{"result"})
+
+	RetentionRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_retention_runs_total",
+		Help:      "Completed outbox retention runs.",
+	})
+	RetentionErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_retention_errors_total",
+		Help:      "Failed outbox retention runs.",
+	})
+	RetentionRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_retention_rows_total",
+		Help:      "PUBLISHED outbox rows purged, by retention mode.",
+	}, []string{"mode"})
+)
+
+// BreakerState exports a circuit breaker's state as 0 (closed), 1
+// (half-open) or 2 (open).
+func BreakerState(dependency string, breaker *resilience.CircuitBreaker) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "circuit_breaker_state",
+		Help:        "Circuit breaker state of a dependency: 0 closed, 1 half-open, 2 open.",
+		ConstLabels: prometheus.Labels{"dependency": dependency},
+	}, func() float64 {
+		switch breaker.State() {
+		case resilience.StateHalfOpen:
+			return 1
+		case resilience.StateOpen:
+			return 2
+		default:
+			return 0
+		}
+	})
+}
+
+// BacklogFunc returns the number of PENDING outbox rows and the creation
+// time of the oldest one, zero when there is none.
+type BacklogFunc func(ctx context.Context) (pending int64, oldest time.Time, err error)
+
+// backlogCollector queries the outbox on every scrape, so the backlog is
+// exact and costs nothing between scrapes.
+type backlogCollector struct {
+	backlog BacklogFunc
+	pending *prometheus.Desc
+	age     *prometheus.Desc
+}
+
+// RegisterBacklog exports the outbox backlog size and the age of its oldest
+// PENDING row.
+func RegisterBacklog(backlog BacklogFunc) {
+	prometheus.MustRegister(&backlogCollector{
+		backlog: backlog,
+		pending: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "outbox", "pending_events"),
+			"Outbox rows waiting to be published.", nil, nil),
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "outbox", "oldest_pending_age_seconds"),
+			"Age of the oldest PENDING outbox row, 0 when the backlog is empty.", nil, nil),
+	})
+}
+
+func (c *backlogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pending
+	ch <- c.age
+}
+
+func (c *backlogCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pending, oldest, err := c.backlog(ctx)
+	if err != nil {
+		// Leaving the series out makes the gap visible instead of reporting
+		// a backlog of zero while the database is down.
+		logger.Warn("Failed to query outbox backlog", "error", err)
+		return
+	}
+	var age float64
+	if !oldest.IsZero() {
+		age = time.Since(oldest).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(pending))
+	ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, age)
+}
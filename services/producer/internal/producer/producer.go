@@ -2,45 +2,105 @@ package producer
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/tracing"
 	"github.com/dzon2000/eda/producer/internal/config"
 	"github.com/dzon2000/eda/producer/internal/events"
-	"github.com/segmentio/kafka-go"
 )
 
 type Producer struct {
-	writer *kafka.Writer
-	config config.KafkaConfig
-}
-
-func New(cfg config.KafkaConfig) *Producer {
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      cfg.Brokers,
-		Topic:        cfg.Topic,
-		Balancer:     &kafka.LeastBytes{},
-		RequiredAcks: int(kafka.RequireAll),
-		MaxAttempts:  cfg.MaxRetries,
-		Async:        false,
-	})
+	publisher     broker.Publisher
+	transactional bool
+}
+
+func New(publisher broker.Publisher, cfg config.KafkaConfig) *Producer {
 	return &Producer{
-		writer: writer,
-		config: cfg,
+		publisher:     publisher,
+		transactional: cfg.ProducerMode == config.ProducerModeTransactional,
+	}
+}
+
+// PublisherConfig maps the producer mode onto broker publisher settings.
+func PublisherConfig(cfg config.KafkaConfig) broker.PublisherConfig {
+	pubCfg := broker.PublisherConfig{Topic: cfg.Topic}
+	switch cfg.ProducerMode {
+	case config.ProducerModeIdempotent:
+		pubCfg.Idempotent = true
+	case config.ProducerModeTransactional:
+		pubCfg.Idempotent = true
+		pubCfg.TransactionalID = cfg.TransactionalID
 	}
+	return pubCfg
 }
 
+// Send publishes the encoded event with the trace context of ctx in its
+// headers, next to the event's ID and type.
 func (p *Producer) Send(ctx context.Context, event events.OutboxEvent, avroBytes []byte) error {
-	return p.writer.WriteMessages(ctx, kafka.Message{
+	msg := broker.Message{
 		Key:   []byte(event.ID.String()),
 		Value: avroBytes,
-		Headers: []kafka.Header{
+		Headers: []broker.Header{
 			{Key: "event_id", Value: []byte(event.ID.String())},
 			{Key: "event_type", Value: []byte(event.EventType)},
 			{Key: "schema_version", Value: []byte(strconv.Itoa(event.SchemaVersion))},
 		},
-	})
+	}
+	tracing.Inject(ctx, &msg)
+	return p.publisher.Publish(ctx, msg)
+}
+
+// BeginBatch starts a Kafka transaction in transactional mode. Records sent
+// until CommitBatch are invisible to read_committed consumers, and are
+// fenced if the producer crashes or aborts before committing.
+func (p *Producer) BeginBatch(ctx context.Context) error {
+	if !p.transactional {
+		return nil
+	}
+	txPublisher, err := p.txPublisher()
+	if err != nil {
+		return err
+	}
+	return txPublisher.BeginTx(ctx)
+}
+
+func (p *Producer) CommitBatch(ctx context.Context) error {
+	if !p.transactional {
+		return nil
+	}
+	txPublisher, err := p.txPublisher()
+	if err != nil {
+		return err
+	}
+	return txPublisher.CommitTx(ctx)
+}
+
+func (p *Producer) AbortBatch(ctx context.Context) error {
+	if !p.transactional {
+		return nil
+	}
+	txPublisher, err := p.txPublisher()
+	if err != nil {
+		return err
+	}
+	return txPublisher.AbortTx(ctx)
+}
+
+func (p *Producer) txPublisher() (broker.TxPublisher, error) {
+	txPublisher, ok := p.publisher.(broker.TxPublisher)
+	if !ok {
+		return nil, fmt.Errorf("publisher %T does not support transactions", p.publisher)
+	}
+	return txPublisher, nil
+}
+
+// Ping checks that the Kafka cluster is reachable.
+func (p *Producer) Ping(ctx context.Context) error {
+	return p.publisher.Ping(ctx)
 }
 
 func (p *Producer) Close() error {
-	return p.writer.Close()
+	return p.publisher.Close()
 }
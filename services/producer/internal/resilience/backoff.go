@@ -0,0 +1,33 @@
+package resilience
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff produces exponentially growing delays with full jitter, so a fleet
+// of producers does not retry a recovering database in lockstep.
+type Backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func NewBackoff(initial, max time.Duration) *Backoff {
+	return &Backoff{initial: initial, max: max}
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	ceiling := b.initial << b.attempt
+	if ceiling <= 0 || ceiling > b.max {
+		ceiling = b.max
+	} else {
+		b.attempt++
+	}
+	return time.Duration(rand.Int64N(int64(ceiling))) + 1
+}
+
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
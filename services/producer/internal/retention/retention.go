@@ -0,0 +1,106 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/producer/internal/config"
+	"github.com/dzon2000/eda/producer/internal/db"
+	"github.com/dzon2000/eda/producer/internal/metrics"
+)
+
+var logger = logging.Logger("retention")
+
+// Job periodically purges PUBLISHED outbox rows so outbox_events and its
+// status index only hold events that are still in flight.
+type Job struct {
+	outboxRepo *db.OutboxRepository
+	config     config.RetentionConfig
+}
+
+func New(outboxRepo *db.OutboxRepository, cfg config.RetentionConfig) *Job {
+	return &Job{
+		outboxRepo: outboxRepo,
+		config:     cfg,
+	}
+}
+
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.purge(ctx); err != nil {
+				metrics.RetentionErrors.Inc()
+				logger.Error("Outbox retention failed", "error", err)
+			}
+		}
+	}
+}
+
+func (j *Job) purge(ctx context.Context) error {
+	cutoff := time.Now().UTC().Add(-j.config.MaxAge)
+
+	if j.config.Mode == config.RetentionModeArchive {
+		if err := j.ensurePartitions(ctx, cutoff); err != nil {
+			return err
+		}
+	}
+
+	var total int64
+	for {
+		n, err := j.purgeBatch(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		total += n
+		metrics.RetentionRows.WithLabelValues(j.config.Mode).Add(float64(n))
+		if n < int64(j.config.BatchSize) {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	metrics.RetentionRuns.Inc()
+	if total > 0 {
+		logger.Info("Outbox retention purged published rows", "mode", j.config.Mode, "rows", total, "published_before", cutoff.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (j *Job) purgeBatch(ctx context.Context, cutoff time.Time) (int64, error) {
+	if j.config.Mode == config.RetentionModeDelete {
+		return j.outboxRepo.DeletePublished(ctx, cutoff, j.config.BatchSize)
+	}
+	return j.outboxRepo.ArchivePublished(ctx, cutoff, j.config.BatchSize)
+}
+
+// ensurePartitions creates every monthly archive partition between the
+// oldest purgeable row and the cutoff, so the archive INSERT never hits a
+// month without a partition.
+func (j *Job) ensurePartitions(ctx context.Context, cutoff time.Time) error {
+	oldest, ok, err := j.outboxRepo.OldestPublished(ctx, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to find oldest published event: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	oldest = oldest.UTC()
+	month := time.Date(oldest.Year(), oldest.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for !month.After(cutoff) {
+		if err := j.outboxRepo.EnsureArchivePartition(ctx, month); err != nil {
+			return fmt.Errorf("failed to create archive partition for %s: %w", month.Format("2006-01"), err)
+		}
+		month = month.AddDate(0, 1, 0)
+	}
+	return nil
+}
@@ -1,27 +1,39 @@
 package schema
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/dzon2000/eda/producer/internal/config"
+	"github.com/dzon2000/eda/producer/internal/metrics"
 	"github.com/linkedin/goavro/v2"
 )
 
+// Errors of GetCodec that a retry cannot fix, as opposed to the registry
+// being unreachable or failing.
+var (
+	ErrSchemaNotFound = errors.New("schema not found")
+	ErrInvalidSchema  = errors.New("invalid schema")
+)
+
 type Registry struct {
 	config config.SchemaConfig
 	client *http.Client
 	cache  map[int]*goavro.Codec
 }
 
-func NewRegistry(cfg config.SchemaConfig) *Registry {
+// NewRegistry returns a registry client sending its requests through
+// transport, http.DefaultTransport when nil.
+func NewRegistry(cfg config.SchemaConfig, transport http.RoundTripper) *Registry {
 	return &Registry{
 		config: cfg,
 		client: &http.Client{
-			Timeout: 10 * time.Second,
+			Transport: transport,
+			Timeout:   cfg.Timeout,
 		},
 		cache: make(map[int]*goavro.Codec),
 	}
@@ -29,8 +41,10 @@ func NewRegistry(cfg config.SchemaConfig) *Registry {
 
 func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
 	if codec, ok := r.cache[schemaID]; ok {
+		metrics.SchemaCache.WithLabelValues("hit").Inc()
 		return codec, nil
 	}
+	metrics.SchemaCache.WithLabelValues("miss").Inc()
 	url := fmt.Sprintf(
 		"%s/schemas/ids/%d",
 		r.config.URL,
@@ -42,6 +56,12 @@ func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: ID %d", ErrSchemaNotFound, schemaID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("schema registry returned %s for ID %d", resp.Status, schemaID)
+	}
 
 	var res struct {
 		Schema string `json:"schema"`
@@ -53,13 +73,30 @@ func (r *Registry) GetCodec(schemaID int) (*goavro.Codec, error) {
 
 	codec, err := goavro.NewCodec(res.Schema)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: ID %d: %w", ErrInvalidSchema, schemaID, err)
 	}
 
 	r.cache[schemaID] = codec
 	return codec, nil
 }
 
+// Ping checks that the registry answers, without touching the codec cache.
+func (r *Registry) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.URL+"/subjects", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("schema registry returned %s", resp.Status)
+	}
+	return nil
+}
+
 func (r *Registry) LoadSchemaFromFile() (string, error) {
 	schemaBytes, err := os.ReadFile(r.config.FilePath)
 	if err != nil {
@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dzon2000/eda/producer/internal/config"
+)
+
+func TestGetCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		ok        bool
+		permanent error // nil when the registry failed rather than the schema
+	}{
+		{"found", http.StatusOK, `{"schema": "\"string\""}`, true, nil},
+		{"not found", http.StatusNotFound, `{"error_code": 40403}`, false, ErrSchemaNotFound},
+		{"invalid schema", http.StatusOK, `{"schema": "{\"type\": \"nope\"}"}`, false, ErrInvalidSchema},
+		{"server error", http.StatusInternalServerError, `{"error_code": 50001}`, false, nil},
+		{"unavailable", http.StatusServiceUnavailable, ``, false, nil},
+		{"garbled response", http.StatusOK, `<html>`, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			r := NewRegistry(config.SchemaConfig{URL: srv.URL, Timeout: time.Second}, nil)
+
+			codec, err := r.GetCodec(1)
+			if tt.ok {
+				if err != nil || codec == nil {
+					t.Fatalf("GetCodec = %v, %v, want a codec", codec, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("GetCodec succeeded, want an error")
+			}
+			for _, permanent := range []error{ErrSchemaNotFound, ErrInvalidSchema} {
+				if got, want := errors.Is(err, permanent), permanent == tt.permanent; got != want {
+					t.Errorf("GetCodec = %v, errors.Is(%v) = %v, want %v", err, permanent, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCodecUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	r := NewRegistry(config.SchemaConfig{URL: srv.URL, Timeout: time.Second}, nil)
+	_, err := r.GetCodec(1)
+	if err == nil || errors.Is(err, ErrSchemaNotFound) || errors.Is(err, ErrInvalidSchema) {
+		t.Errorf("GetCodec = %v, want a transport error", err)
+	}
+}
@@ -4,20 +4,60 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dzon2000/eda/pkg/broker"
+	"github.com/dzon2000/eda/pkg/chaos"
+	"github.com/dzon2000/eda/pkg/conf"
+	"github.com/dzon2000/eda/pkg/health"
+	"github.com/dzon2000/eda/pkg/logging"
+	"github.com/dzon2000/eda/pkg/migrate"
+	"github.com/dzon2000/eda/pkg/tracing"
 	"github.com/dzon2000/eda/producer/internal/config"
 	"github.com/dzon2000/eda/producer/internal/db"
 	"github.com/dzon2000/eda/producer/internal/events"
+	"github.com/dzon2000/eda/producer/internal/metrics"
 	"github.com/dzon2000/eda/producer/internal/producer"
+	"github.com/dzon2000/eda/producer/internal/resilience"
+	"github.com/dzon2000/eda/producer/internal/retention"
 	"github.com/dzon2000/eda/producer/internal/schema"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
+	"github.com/linkedin/goavro/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer = otel.Tracer("github.com/dzon2000/eda/producer")
+	logger = logging.Logger("publisher")
+)
+
+// fatal logs err and exits; slog has no Fatal.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// Failures of the relay's dependencies are transient: the batch is retried
+// with backoff and the process stays up. So is a failure to publish one
+// event, until it failed PRODUCER_MAX_RETRIES times. Only failures caused by
+// the event itself, such as an unknown schema ID or a payload that does not
+// encode, count towards that limit.
+var (
+	ErrDBUnavailable       = errors.New("outbox database unavailable")
+	ErrKafkaUnavailable    = errors.New("kafka unavailable")
+	ErrRegistryUnavailable = errors.New("schema registry unavailable")
+	ErrEventFailed         = errors.New("event could not be published")
 )
 
 type Publisher struct {
@@ -25,71 +65,269 @@ type Publisher struct {
 	dbPool         *sql.DB
 	schemaRegistry *schema.Registry
 	kafkaProducer  *producer.Producer
+	config         config.ProducerConfig
+	dbBreaker      *resilience.CircuitBreaker
+	kafkaBreaker   *resilience.CircuitBreaker
+	watchdog       health.Watchdog // tracks the batch in flight
+
+	// workCtx outlives the context passed to Run so the batch in flight can
+	// finish during shutdown. Shutdown cancels it once the drain timeout
+	// expires.
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	done       chan struct{}
 }
 
-func NewPublisher(outboxRepo *db.OutboxRepository, dbPool *sql.DB, schemaRegistry *schema.Registry, kafkaProducer *producer.Producer) *Publisher {
+func NewPublisher(outboxRepo *db.OutboxRepository, dbPool *sql.DB, schemaRegistry *schema.Registry, kafkaProducer *producer.Producer, cfg config.ProducerConfig) *Publisher {
+	workCtx, cancelWork := context.WithCancel(context.Background())
 	return &Publisher{
 		outboxRepo:     outboxRepo,
 		dbPool:         dbPool,
 		schemaRegistry: schemaRegistry,
 		kafkaProducer:  kafkaProducer,
+		config:         cfg,
+		dbBreaker:      resilience.NewCircuitBreaker("postgres", cfg.BreakerThreshold, cfg.BreakerOpenTimeout),
+		kafkaBreaker:   resilience.NewCircuitBreaker("kafka", cfg.BreakerThreshold, cfg.BreakerOpenTimeout),
+		workCtx:        workCtx,
+		cancelWork:     cancelWork,
+		done:           make(chan struct{}),
 	}
 }
 
 func (p *Publisher) Run(ctx context.Context) {
+	defer close(p.done)
 	ticker := time.NewTicker(500 * time.Millisecond)
 	defer ticker.Stop()
+	backoff := resilience.NewBackoff(p.config.RetryBackoff, p.config.MaxRetryBackoff)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := p.publishBatch(ctx); err != nil {
-				log.Println("publish batch failed", err)
+			p.watchdog.Begin(batchKey)
+			err := p.publishBatch(p.workCtx)
+			p.watchdog.End(batchKey)
+			if err == nil {
+				backoff.Reset()
+				continue
+			}
+			logger.Error("Publish batch failed", "error", err)
+			if errors.Is(err, ErrDBUnavailable) || errors.Is(err, ErrKafkaUnavailable) ||
+				errors.Is(err, ErrRegistryUnavailable) || errors.Is(err, ErrEventFailed) {
+				delay := backoff.Next()
+				logger.Warn("Retrying publish batch", "delay", delay, "health", p.Health().Status)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
 			}
 		}
 	}
 }
 
-func (p *Publisher) publishBatch(ctx context.Context) error {
-	log.Println("Starting batch publishing.")
-	tx, err := p.dbPool.BeginTx(context.Background(), &sql.TxOptions{
+// batchKey identifies the single batch in flight to the watchdog.
+const batchKey = "publish_batch"
+
+type Health struct {
+	Status string           `json:"status"` // "ok" or "degraded"
+	DB     resilience.State `json:"db"`
+	Kafka  resilience.State `json:"kafka"`
+}
+
+// Health reports the relay as degraded while either dependency's circuit
+// breaker is not closed.
+func (p *Publisher) Health() Health {
+	h := Health{
+		Status: "ok",
+		DB:     p.dbBreaker.State(),
+		Kafka:  p.kafkaBreaker.State(),
+	}
+	if h.DB != resilience.StateClosed || h.Kafka != resilience.StateClosed {
+		h.Status = "degraded"
+	}
+	return h
+}
+
+// Shutdown waits for Run to return after its context was cancelled. If ctx
+// expires first, the batch in flight is cancelled and its transaction rolled
+// back, leaving the rows PENDING for the next start.
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	defer p.cancelWork()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		p.cancelWork()
+		<-p.done
+		return ctx.Err()
+	}
+}
+
+func (p *Publisher) publishBatch(ctx context.Context) (err error) {
+	logger.Debug("Starting batch publishing")
+	ctx, span := tracer.Start(ctx, "outbox.publish_batch")
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	if err := p.dbBreaker.Allow(); err != nil {
+		return fmt.Errorf("%w: %w", ErrDBUnavailable, err)
+	}
+	if err := p.kafkaBreaker.Allow(); err != nil {
+		return fmt.Errorf("%w: %w", ErrKafkaUnavailable, err)
+	}
+
+	tx, err := p.dbPool.BeginTx(ctx, &sql.TxOptions{
 		Isolation: sql.LevelReadCommitted,
 	})
 	if err != nil {
-		log.Fatal(err)
+		return p.dbResult(fmt.Errorf("failed to begin transaction: %w", err))
 	}
 	defer tx.Rollback()
 
 	events, err := p.outboxRepo.FetchPending(ctx, tx, 100)
 	if err != nil {
-		return err
+		return p.dbResult(fmt.Errorf("failed to fetch pending events: %w", err))
 	}
 
 	if len(events) == 0 {
-		log.Println("No pending events to publish.")
-		return tx.Commit()
+		logger.DebugContext(ctx, "No pending events to publish")
+		return p.dbResult(tx.Commit())
+	}
+	span.SetAttributes(attribute.Int("outbox.batch_size", len(events)))
+
+	// In transactional mode the whole batch is one Kafka transaction. If we
+	// crash or fail before CommitBatch, the records are aborted and never
+	// reach read_committed consumers, while the rows stay PENDING.
+	if err := p.kafkaProducer.BeginBatch(ctx); err != nil {
+		return p.kafkaResult(fmt.Errorf("failed to begin Kafka transaction: %w", err))
 	}
 
 	for _, e := range events {
 		if err := p.publishOne(ctx, e); err != nil {
-			log.Println("Failed to publish event:", err)
-			p.outboxRepo.MarkError(ctx, tx, e.ID, err)
-			return err
+			metrics.PublishFailures.Inc()
+			logger.ErrorContext(ctx, "Failed to publish event", "event_id", e.ID, "error", err)
+			// A Kafka or schema registry outage says nothing about the event
+			// itself: the batch is rolled back and retried without counting an
+			// attempt.
+			if errors.Is(err, ErrKafkaUnavailable) || errors.Is(err, ErrRegistryUnavailable) {
+				p.abortBatch(ctx)
+				return err
+			}
+			return p.failEvent(ctx, tx, e, err)
 		}
-		log.Println("Published event ID:", e.ID)
 		if err := p.outboxRepo.MarkSent(ctx, tx, e.ID); err != nil {
-			return err
+			p.abortBatch(ctx)
+			return p.dbResult(fmt.Errorf("failed to mark event %s sent: %w", e.ID, err))
 		}
 	}
 
-	return tx.Commit()
+	if err := p.commitKafka(ctx); err != nil {
+		return fmt.Errorf("failed to commit Kafka transaction: %w", err)
+	}
+
+	// A crash between CommitBatch and tx.Commit republishes the batch in a
+	// new transaction; consumers drop the duplicates by event ID.
+	return p.dbResult(p.commitDB(ctx, tx))
 }
 
-func (p *Publisher) publishOne(ctx context.Context, event events.OutboxEvent) error {
-	log.Printf("Publishing event ID: %s, Type: %s, Schema: %d", event.ID, event.EventType, event.SchemaVersion)
-	codec, err := p.schemaRegistry.GetCodec(event.SchemaVersion)
+// failEvent ends the batch at an event that failed to publish. The events
+// before it were published and are committed with the failed attempt, so
+// they are not published again and the attempt counts towards the limit.
+// The events after it stay PENDING, keeping the outbox order.
+func (p *Publisher) failEvent(ctx context.Context, tx *sql.Tx, event events.OutboxEvent, cause error) error {
+	gaveUp, err := p.outboxRepo.MarkFailed(ctx, tx, event.ID, cause, p.config.MaxRetries)
+	if err != nil {
+		p.abortBatch(ctx)
+		return p.dbResult(fmt.Errorf("failed to record the failure of event %s: %w", event.ID, err))
+	}
+	if err := p.commitKafka(ctx); err != nil {
+		return fmt.Errorf("failed to commit Kafka transaction: %w", err)
+	}
+	if err := p.dbResult(p.commitDB(ctx, tx)); err != nil {
+		return err
+	}
+	if gaveUp {
+		metrics.GaveUp.Inc()
+		logger.ErrorContext(ctx, "Giving up on event", "event_id", event.ID, "attempts", p.config.MaxRetries, "error", cause)
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrEventFailed, cause)
+}
+
+func (p *Publisher) commitKafka(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "kafka.commit_batch")
+	defer span.End()
+	return tracing.Fail(span, p.kafkaResult(p.kafkaProducer.CommitBatch(ctx)))
+}
+
+func (p *Publisher) commitDB(ctx context.Context, tx *sql.Tx) error {
+	_, span := tracer.Start(ctx, "db.commit")
+	defer span.End()
+	return tracing.Fail(span, tx.Commit())
+}
+
+// dbResult records the outcome of a database call on the DB circuit breaker
+// and tags failures with ErrDBUnavailable.
+func (p *Publisher) dbResult(err error) error {
+	return recordResult(p.dbBreaker, ErrDBUnavailable, err)
+}
+
+// kafkaResult records the outcome of a Kafka call on the Kafka circuit
+// breaker and tags failures with ErrKafkaUnavailable.
+func (p *Publisher) kafkaResult(err error) error {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		metrics.KafkaWriteErrors.Inc()
+	}
+	return recordResult(p.kafkaBreaker, ErrKafkaUnavailable, err)
+}
+
+func recordResult(breaker *resilience.CircuitBreaker, kind error, err error) error {
+	if err == nil {
+		breaker.Success()
+		return nil
+	}
+	// Shutdown cancelling the batch is not a dependency failure.
+	if !errors.Is(err, context.Canceled) {
+		breaker.Failure()
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+func (p *Publisher) abortBatch(ctx context.Context) {
+	if err := p.kafkaProducer.AbortBatch(ctx); err != nil {
+		logger.ErrorContext(ctx, "Failed to abort Kafka transaction", "error", err)
+	}
+}
+
+// publishOne continues the trace of the request that wrote the event, so
+// one trace covers the event from the HTTP request to the consumer. The
+// batch it was published in is linked.
+func (p *Publisher) publishOne(ctx context.Context, event events.OutboxEvent) (err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(tracing.WithTraceparent(ctx, event.Traceparent), "outbox.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("event.id", event.ID.String()),
+			attribute.String("event.type", event.EventType),
+		))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	log := logger.With(
+		"event_id", event.ID,
+		"event_type", event.EventType,
+		"order_id", event.AggregateID,
+		"schema_version", event.SchemaVersion,
+	)
+	log.DebugContext(ctx, "Publishing event")
+
+	codec, err := p.getCodec(ctx, event.SchemaVersion)
 	if err != nil {
 		return fmt.Errorf("failed to get codec for schema ID %d: %w", event.SchemaVersion, err)
 	}
@@ -103,19 +341,36 @@ func (p *Publisher) publishOne(ctx context.Context, event events.OutboxEvent) er
 	if err != nil {
 		return fmt.Errorf("failed to deserialize event ID %s: %w", event.ID, err)
 	}
+	_, encodeSpan := tracer.Start(ctx, "avro.encode")
 	avroBytes, err := encoder.Encode(orderEvent.ToMap())
+	tracing.Fail(encodeSpan, err)
+	encodeSpan.End()
 	if err != nil {
 		return fmt.Errorf("failed to encode event ID %s: %w", event.ID, err)
 	}
 
-	if err := p.kafkaProducer.Send(ctx, event, avroBytes); err != nil {
+	if err := p.kafkaResult(p.kafkaProducer.Send(ctx, event, avroBytes)); err != nil {
 		return fmt.Errorf("failed to send event ID %s to Kafka: %w", event.ID, err)
 	}
 
-	log.Printf("Successfully published event ID: %s", event.ID)
+	metrics.PublishDuration.Observe(time.Since(start).Seconds())
+	metrics.Published.Inc()
+	log.InfoContext(ctx, "Published event")
 	return nil
 }
 
+// getCodec tags failures other than an unknown or invalid schema with
+// ErrRegistryUnavailable.
+func (p *Publisher) getCodec(ctx context.Context, schemaID int) (*goavro.Codec, error) {
+	_, span := tracer.Start(ctx, "schema.get_codec", trace.WithAttributes(attribute.Int("schema.id", schemaID)))
+	defer span.End()
+	codec, err := p.schemaRegistry.GetCodec(schemaID)
+	if err != nil && !errors.Is(err, schema.ErrSchemaNotFound) && !errors.Is(err, schema.ErrInvalidSchema) {
+		err = fmt.Errorf("%w: %w", ErrRegistryUnavailable, err)
+	}
+	return codec, tracing.Fail(span, err)
+}
+
 func (p *Publisher) DeserializeOrderCreated(payload []byte) (*events.OrderCreated, error) {
 	var orderCreated events.OrderCreated
 	err := json.Unmarshal(payload, &orderCreated)
@@ -125,28 +380,60 @@ func (p *Publisher) DeserializeOrderCreated(payload []byte) (*events.OrderCreate
 	return &orderCreated, nil
 }
 
-func (p *Publisher) Close() {
-	p.kafkaProducer.Close()
+func (p *Publisher) Close() error {
+	return p.kafkaProducer.Close()
+}
+
+// backlogCheck fails while the outbox backlog exceeds the configured
+// thresholds: the relay is up but not keeping up.
+func backlogCheck(repo *db.OutboxRepository, cfg config.HealthConfig) health.Check {
+	return func(ctx context.Context) error {
+		pending, oldest, err := repo.Backlog(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > int64(cfg.BacklogMaxPending) {
+			return fmt.Errorf("%d events pending, limit %d", pending, cfg.BacklogMaxPending)
+		}
+		if age := time.Since(oldest); !oldest.IsZero() && age > cfg.BacklogMaxAge {
+			return fmt.Errorf("oldest pending event is %s old, limit %s", age.Round(time.Second), cfg.BacklogMaxAge)
+		}
+		return nil
+	}
 }
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("Producer Service")
 	_ = godotenv.Load(".env.development")
-	cfg, err := config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to load configuration", err)
 	}
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		cfg.DB.User,
-		cfg.DB.Password,
-		cfg.DB.Host,
-		cfg.DB.Port,
-		cfg.DB.DBName,
-	)
-	dbPool, err := sql.Open("pgx", dsn)
+	if err := logging.Setup(cfg.Logging, os.Stdout); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	logger.Info("Producer Service starting", "environment", cfg.Environment, "topic", cfg.Kafka.Topic, "mode", cfg.Kafka.ProducerMode)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to set up tracing", err)
+	}
+
+	if cfg.DB.MigrateOnStart {
+		if err := migrateOnStart(context.Background(), cfg.DB.DSN()); err != nil {
+			fatal("Failed to migrate database", err)
+		}
+	}
+
+	injector := chaos.New(cfg.Chaos)
+	dbPool, err := chaos.OpenDB("pgx", cfg.DB.DSN(), injector)
+	if err != nil {
+		fatal("Failed to open database", err)
 	}
 
 	dbPool.SetMaxOpenConns(20)
@@ -154,23 +441,122 @@ func main() {
 	dbPool.SetConnMaxLifetime(time.Hour)
 
 	outboxRepo := db.NewOutboxRepository(dbPool)
-	schemaRegistry := schema.NewRegistry(cfg.Schema)
-	producer := producer.New(cfg.Kafka)
-	defer producer.Close()
-	publisher := NewPublisher(outboxRepo, dbPool, schemaRegistry, producer)
+	schemaRegistry := schema.NewRegistry(cfg.Schema, chaos.Transport(nil, injector))
+	kafkaBroker := chaos.Broker(broker.NewKafka(broker.KafkaConfig{
+		Brokers:    cfg.Kafka.Brokers,
+		MaxRetries: cfg.Kafka.MaxRetries,
+	}), injector)
+	kafkaPublisher, err := kafkaBroker.Publisher(producer.PublisherConfig(cfg.Kafka))
+	if err != nil {
+		fatal("Failed to create Kafka publisher", err)
+	}
+	producer := producer.New(kafkaPublisher, cfg.Kafka)
+	publisher := NewPublisher(outboxRepo, dbPool, schemaRegistry, producer, cfg.ProducerConfig)
+	metrics.BreakerState("postgres", publisher.dbBreaker)
+	metrics.BreakerState("kafka", publisher.kafkaBreaker)
+	metrics.RegisterBacklog(outboxRepo.Backlog)
+
+	checker := health.New(cfg.Health.CheckTimeout)
+	checker.Liveness("publish_loop", publisher.watchdog.Check(cfg.Health.StallTimeout))
+	checker.Readiness("postgres", dbPool.PingContext)
+	checker.Readiness("kafka", producer.Ping)
+	checker.Readiness("schema_registry", schemaRegistry.Ping)
+	checker.Readiness("outbox_backlog", backlogCheck(outboxRepo, cfg.Health))
+
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", promhttp.Handler())
+	checker.Register(metricsMux)
+	metricsServer := &http.Server{
+		Addr:    cfg.MetricsAddr,
+		Handler: metricsMux,
+	}
+	go func() {
+		logger.Info("Metrics and health probes listening", "addr", cfg.MetricsAddr)
+		if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics server failed", "error", err)
+		}
+	}()
+
+	// Cancelled on interrupt signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	ctx := context.Background()
-	go publisher.Run(ctx)
+	var wg sync.WaitGroup
+	wg.Go(func() { publisher.Run(ctx) })
+	if cfg.Retention.Mode != config.RetentionModeDisabled {
+		// A purge cut short by shutdown is a single statement and rolls back.
+		wg.Go(func() { retention.New(outboxRepo, cfg.Retention).Run(ctx) })
+	}
 
-	log.Println("Publisher started. Press Ctrl+C to stop.")
+	logger.Info("Publisher started")
+	<-ctx.Done()
+	stop()
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	<-sigChan
+	logger.Info("Shutdown signal received, stopping")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := publisher.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Publisher did not drain in time", "error", err)
+	}
+	wg.Wait()
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down metrics server", "error", err)
+	}
+
+	if err := publisher.Close(); err != nil {
+		logger.Error("Failed to close Kafka producer", "error", err)
+	}
+	if err := dbPool.Close(); err != nil {
+		logger.Error("Failed to close database pool", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	logger.Info("Shutdown complete")
+}
 
-	log.Println("Shutdown signal received, stopping...")
-	publisher.Close()
-	time.Sleep(1 * time.Second)
-	log.Println("Shutdown complete")
+// runMigrate implements "producer migrate <command> [flags]", see
+// migrate.Usage. check prepares the queries of the outbox repository.
+func runMigrate(args []string) {
+	command, flags := migrate.SplitArgs(args)
+	cfg, err := config.Load(flags)
+	if errors.Is(err, conf.ErrPrinted) {
+		return
+	}
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg.Logging, os.Stderr); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+	dbPool, err := sql.Open("pgx", cfg.DB.DSN())
+	if err != nil {
+		fatal("Failed to open database", err)
+	}
+	defer dbPool.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := migrate.Run(ctx, dbPool, command, db.Queries, os.Stdout); err != nil {
+		fatal("Migration failed", err)
+	}
+}
+
+// migrateOnStart applies pending migrations. Instances starting together
+// wait for each other on the migration lock. It uses a pool of its own, so
+// injected faults cannot fail the start.
+func migrateOnStart(ctx context.Context, dsn string) error {
+	dbPool, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer dbPool.Close()
+	m, err := migrate.New(dbPool)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(ctx)
+	for _, migration := range applied {
+		logger.Info("Applied migration", "version", migration.Version, "name", migration.Name)
+	}
+	return err
 }
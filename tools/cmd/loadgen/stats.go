@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type stats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	kinds     map[string]*kindStats
+	errors    map[string]int // transport errors by message
+	created   map[string]time.Time
+}
+
+type kindStats struct {
+	requests int
+	statuses map[int]int
+}
+
+func newStats() *stats {
+	return &stats{
+		kinds:   make(map[string]*kindStats),
+		errors:  make(map[string]int),
+		created: make(map[string]time.Time),
+	}
+}
+
+func (s *stats) record(req request, start time.Time, latency time.Duration, status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.kinds[req.kind]
+	if k == nil {
+		k = &kindStats{statuses: make(map[int]int)}
+		s.kinds[req.kind] = k
+	}
+	k.requests++
+	if err != nil {
+		s.errors[err.Error()]++
+		return
+	}
+	k.statuses[status]++
+	if req.kind == kindMalformed {
+		// Not a request to the order service.
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+	if req.kind == kindNew && status == http.StatusCreated {
+		s.created[req.order.OrderID] = start
+	}
+}
+
+// sentAt returns when each order created by a new request was sent.
+func (s *stats) sentAt() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.created)
+}
+
+func (s *stats) printRequests(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, k := range s.kinds {
+		total += k.requests
+	}
+	fmt.Fprintf(w, "Requests: %d in %s, %.1f/s\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+	for _, kind := range []string{kindNew, kindDuplicate, kindInvalid, kindMalformed} {
+		k := s.kinds[kind]
+		if k == nil {
+			continue
+		}
+		if kind == kindMalformed {
+			fmt.Fprintf(w, "  %-9s %6d  written to the outbox: %d\n", kind, k.requests, k.statuses[0])
+			continue
+		}
+		var statuses []string
+		for _, status := range slices.Sorted(maps.Keys(k.statuses)) {
+			statuses = append(statuses, fmt.Sprintf("%d: %d", status, k.statuses[status]))
+		}
+		fmt.Fprintf(w, "  %-9s %6d  %s\n", kind, k.requests, strings.Join(statuses, ", "))
+	}
+	if len(s.errors) > 0 {
+		fmt.Fprintln(w, "Failed requests:")
+		for msg, n := range s.errors {
+			fmt.Fprintf(w, "  %6d  %s\n", n, msg)
+		}
+	}
+	fmt.Fprintf(w, "Latency: %s\n", percentiles(s.latencies))
+}
+
+func printConsumed(w io.Writer, took []time.Duration, created int) {
+	fmt.Fprintf(w, "Time to consume, %d of %d new orders processed: %s\n", len(took), created, percentiles(took))
+}
+
+// percentiles formats the p50, p95, p99 and max of durations.
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "no samples"
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	at := func(q float64) time.Duration {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		return sorted[max(i, 0)].Round(10 * time.Microsecond)
+	}
+	return fmt.Sprintf("p50 %s, p95 %s, p99 %s, max %s", at(0.50), at(0.95), at(0.99), sorted[len(sorted)-1].Round(10*time.Microsecond))
+}
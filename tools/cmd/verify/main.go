@@ -0,0 +1,255 @@
+// Command verify checks the delivery guarantees of the pipeline end to end.
+// It sends orders to the order service, then follows the consumer's journal
+// until every accepted order's OrderCreated event was processed, and fails
+// when an event was lost, processed more than once, or an order produced
+// more than one event.
+//
+//	verify -journal ../services/consumer/processed.jsonl [-orders 1000] [-concurrency 16]
+//
+// It is meant to run against services started with CHAOS_FAULTS, see
+// package chaos, and the consumer's CONSUMER_JOURNAL_FILE set, e.g.
+//
+//	order:    CHAOS_FAULTS=db.commit=drop@0.05
+//	producer: CHAOS_FAULTS=kafka.publish=duplicate@0.1,kafka.publish=drop@0.05,db.commit=error@0.05
+//	consumer: CHAOS_FAULTS=kafka.fetch=drop@0.02,kafka.commit=error@0.1,registry=error@0.2
+//
+// A POST that fails with a server error is retried with the same order ID,
+// as a client would. Orders never accepted are reported but not checked:
+// whether they exist is unknown.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzon2000/eda/tools/internal/journal"
+	"github.com/dzon2000/eda/tools/internal/orders"
+)
+
+// maxListed is how many orders of each kind of violation are listed.
+const maxListed = 10
+
+type sendResult struct {
+	order  orders.Order
+	status int // of the last attempt, 0 when it failed without a response
+	err    error
+}
+
+func (r sendResult) accepted() bool {
+	return r.status == http.StatusCreated || r.status == http.StatusOK
+}
+
+func main() {
+	log.SetFlags(0)
+	var (
+		orderURL    = flag.String("order-url", "http://localhost:8080", "base URL of the order service")
+		journals    = flag.String("journal", "", "the consumers' journal files, comma separated (required)")
+		count       = flag.Int("orders", 100, "orders to send")
+		concurrency = flag.Int("concurrency", 8, "requests in flight")
+		attempts    = flag.Int("attempts", 10, "POST attempts per order on server errors")
+		timeout     = flag.Duration("timeout", 2*time.Minute, "how long to wait for every accepted order to be processed")
+		settle      = flag.Duration("settle", 10*time.Second, "how long to keep watching for duplicates afterwards")
+	)
+	flag.Parse()
+	if *journals == "" {
+		log.Fatal("-journal is required")
+	}
+	if *count <= 0 || *concurrency <= 0 || *attempts <= 0 {
+		log.Fatal("-orders, -concurrency and -attempts must be positive")
+	}
+
+	// Started before sending so no entry is missed.
+	tail, err := journal.NewTail(strings.Split(*journals, ",")...)
+	if err != nil {
+		log.Fatalf("Failed to open journal: %v", err)
+	}
+	ctx := context.Background()
+	client := orders.NewClient(*orderURL, 10*time.Second)
+
+	start := time.Now()
+	results := send(ctx, client, *count, *concurrency, *attempts)
+	fmt.Printf("Sent %d orders in %s\n", len(results), time.Since(start).Round(time.Millisecond))
+
+	c := newChecker(results)
+	deadline := time.Now().Add(*timeout)
+	for {
+		entries, err := tail.Read()
+		if err != nil {
+			log.Fatalf("Failed to read journal: %v", err)
+		}
+		c.add(entries)
+		if c.complete() {
+			fmt.Printf("Every accepted order was processed %s after the first was sent, watching for duplicates for %s\n",
+				time.Since(start).Round(time.Millisecond), *settle)
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("Gave up waiting after %s\n", *timeout)
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	time.Sleep(*settle)
+	entries, err := tail.Read()
+	if err != nil {
+		log.Fatalf("Failed to read journal: %v", err)
+	}
+	c.add(entries)
+
+	if !c.report(os.Stdout) {
+		os.Exit(1)
+	}
+}
+
+// send posts count new orders from concurrency workers.
+func send(ctx context.Context, client *orders.Client, count, concurrency, attempts int) []sendResult {
+	jobs := make(chan orders.Order)
+	out := make(chan sendResult)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Go(func() {
+			for order := range jobs {
+				out <- post(ctx, client, order, attempts)
+			}
+		})
+	}
+	go func() {
+		for range count {
+			jobs <- orders.New()
+		}
+		close(jobs)
+		wg.Wait()
+		close(out)
+	}()
+
+	var results []sendResult
+	for r := range out {
+		results = append(results, r)
+	}
+	return results
+}
+
+// post sends order until it is accepted or rejected, retrying failed
+// requests and server errors with backoff.
+func post(ctx context.Context, client *orders.Client, order orders.Order, attempts int) sendResult {
+	r := sendResult{order: order}
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		r.status, r.err = client.Post(ctx, order)
+		if (r.err == nil && r.status < 500) || attempt == attempts {
+			return r
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 2*time.Second)
+	}
+}
+
+// checker matches the journal against the orders sent.
+type checker struct {
+	results []sendResult
+	sent    map[string]bool           // order ID, accepted
+	events  map[string]map[string]int // order ID, event ID, times processed
+}
+
+func newChecker(results []sendResult) *checker {
+	c := &checker{
+		results: results,
+		sent:    make(map[string]bool),
+		events:  make(map[string]map[string]int),
+	}
+	for _, r := range results {
+		c.sent[r.order.OrderID] = r.accepted()
+	}
+	return c
+}
+
+// add counts the entries of the orders sent, the journal may hold others.
+func (c *checker) add(entries []journal.Entry) {
+	for _, e := range entries {
+		if _, ok := c.sent[e.OrderID]; !ok {
+			continue
+		}
+		if c.events[e.OrderID] == nil {
+			c.events[e.OrderID] = make(map[string]int)
+		}
+		c.events[e.OrderID][e.EventID]++
+	}
+}
+
+// complete reports whether every accepted order has a processed event.
+func (c *checker) complete() bool {
+	for id, accepted := range c.sent {
+		if accepted && len(c.events[id]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// report prints the outcome and returns whether every accepted order's
+// event was processed exactly once.
+func (c *checker) report(w io.Writer) bool {
+	var accepted, rejected, failed, unconfirmedProcessed int
+	var lost, duplicated, multiple []string
+	for _, r := range c.results {
+		id := r.order.OrderID
+		events := c.events[id]
+		switch {
+		case r.accepted():
+			accepted++
+			if len(events) == 0 {
+				lost = append(lost, id)
+			}
+		case r.status >= 400 && r.status < 500:
+			rejected++
+			fmt.Fprintf(w, "Order %s was rejected with %d\n", id, r.status)
+		default:
+			failed++
+			if len(events) > 0 {
+				unconfirmedProcessed++
+			}
+		}
+		if len(events) > 1 {
+			multiple = append(multiple, fmt.Sprintf("%s: %d events", id, len(events)))
+		}
+		for eventID, n := range events {
+			if n > 1 {
+				duplicated = append(duplicated, fmt.Sprintf("%s (order %s): processed %d times", eventID, id, n))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Orders: %d sent, %d accepted, %d rejected, %d failed after retries (%d of them processed anyway)\n",
+		len(c.results), accepted, rejected, failed, unconfirmedProcessed)
+	list(w, "Lost, accepted but never processed", lost)
+	list(w, "Processed more than once", duplicated)
+	list(w, "Orders with more than one event", multiple)
+	ok := accepted > 0 && rejected == 0 && len(lost) == 0 && len(duplicated) == 0 && len(multiple) == 0
+	if ok {
+		fmt.Fprintf(w, "OK: %d events processed exactly once\n", accepted)
+	} else {
+		fmt.Fprintln(w, "FAILED")
+	}
+	return ok
+}
+
+func list(w io.Writer, title string, items []string) {
+	fmt.Fprintf(w, "%s: %d\n", title, len(items))
+	slices.Sort(items)
+	for i, item := range items {
+		if i == maxListed {
+			fmt.Fprintf(w, "  … and %d more\n", len(items)-maxListed)
+			break
+		}
+		fmt.Fprintf(w, "  %s\n", item)
+	}
+}
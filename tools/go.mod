@@ -0,0 +1,19 @@
+module github.com/dzon2000/eda/tools
+
+go 1.25.5
+
+require (
+	github.com/dzon2000/eda/pkg/outbox v0.0.0
+	github.com/google/uuid v1.6.0
+	github.com/jackc/pgx/v5 v5.8.0
+)
+
+require (
+	github.com/jackc/pgpassfile v1.0.0 // indirect
+	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
+	github.com/jackc/puddle/v2 v2.2.2 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
+)
+
+replace github.com/dzon2000/eda/pkg/outbox => ../pkg/outbox
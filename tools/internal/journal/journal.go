@@ -0,0 +1,107 @@
+// Package journal reads the consumer's journal of processed events, the
+// file named by its CONSUMER_JOURNAL_FILE: one JSON Entry per line.
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Entry is an event the consumer processed. Redeliveries it dropped as
+// duplicates are not recorded.
+type Entry struct {
+	EventID     string    `json:"event_id"`
+	OrderID     string    `json:"order_id"`
+	Topic       string    `json:"topic"`
+	Partition   int       `json:"partition"`
+	Offset      int64     `json:"offset"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// Tail reads what the consumers append to their journals, e.g. one file
+// per consumer instance, from the moment it was created.
+type Tail struct {
+	files []*tailFile
+}
+
+type tailFile struct {
+	path    string
+	offset  int64
+	partial []byte // a line not completely written yet
+}
+
+// NewTail starts reading at the current end of the journals. A journal
+// that does not exist yet is read from its start once it is created.
+func NewTail(paths ...string) (*Tail, error) {
+	t := &Tail{}
+	for _, path := range paths {
+		f := &tailFile{path: path}
+		info, err := os.Stat(path)
+		switch {
+		case err == nil:
+			f.offset = info.Size()
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+		t.files = append(t.files, f)
+	}
+	return t, nil
+}
+
+// Read returns the entries appended since the previous Read.
+func (t *Tail) Read() ([]Entry, error) {
+	var entries []Entry
+	for _, f := range t.files {
+		read, err := f.read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.path, err)
+		}
+		entries = append(entries, read...)
+	}
+	return entries, nil
+}
+
+func (f *tailFile) read() ([]Entry, error) {
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	f.offset += int64(len(data))
+	data = append(f.partial, data...)
+
+	var entries []Entry
+	for {
+		line, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			break
+		}
+		data = rest
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("invalid journal line %q: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	f.partial = bytes.Clone(data)
+	return entries, nil
+}
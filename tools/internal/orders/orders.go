@@ -0,0 +1,86 @@
+// Package orders sends orders to the order service's POST /orders.
+package orders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Order is the body of POST /orders.
+type Order struct {
+	OrderID    string   `json:"order_id"`
+	CustomerID string   `json:"customer_id"`
+	Amount     float64  `json:"amount"`
+	Discount   *float64 `json:"discount,omitempty"`
+}
+
+// New returns a valid order with fresh IDs, an amount between 1 and 500
+// and, every other time, a discount of at most a fifth of it.
+func New() Order {
+	o := Order{
+		OrderID:    uuid.NewString(),
+		CustomerID: uuid.NewString(),
+		Amount:     cents(1 + rand.Float64()*499),
+	}
+	if rand.IntN(2) == 0 {
+		discount := cents(o.Amount * rand.Float64() / 5)
+		o.Discount = &discount
+	}
+	return o
+}
+
+func cents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+// NewClient sends orders to the order service at baseURL, e.g.
+// http://localhost:8080.
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		url:  baseURL + "/orders",
+		http: &http.Client{Timeout: timeout},
+	}
+}
+
+// Post sends order and returns the response status.
+func (c *Client) Post(ctx context.Context, order Order) (int, error) {
+	body, err := json.Marshal(order)
+	if err != nil {
+		return 0, err
+	}
+	return c.PostBody(ctx, body)
+}
+
+// PostBody sends body as is, e.g. to check how invalid requests are
+// rejected, and returns the response status.
+func (c *Client) PostBody(ctx context.Context, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection is reused.
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return 0, fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp.StatusCode, nil
+}